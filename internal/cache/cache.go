@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	TagProducts        = "products"
+	TagBrands          = "brands"
+	TagCategories      = "categories"
+	TagRecommendations = "recommendations"
+
+	keyPrefix     = "cache:"
+	tagPrefix     = "cache-tag:"
+	lockPrefix    = "cache-lock:"
+	changedPrefix = "cache-changed:"
+
+	lockTTL      = 5 * time.Second
+	lockWait     = 50 * time.Millisecond
+	lockAttempts = 40
+)
+
+var cacheInstance *Cache
+
+// Cache is a read-through cache of serialised responses in Redis.
+// Entries are grouped by tags so writers can drop every entry they affect.
+type Cache struct {
+	redis *redis.Client
+	group singleflight.Group
+
+	mu      sync.Mutex
+	metrics map[string]*counters
+}
+
+type counters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+// Hit and miss counts of a key namespace
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+func NewCache(redis *redis.Client) *Cache {
+
+	if cacheInstance != nil {
+		return cacheInstance
+	}
+
+	cacheInstance = &Cache{
+		redis:   redis,
+		metrics: make(map[string]*counters),
+	}
+
+	return cacheInstance
+}
+
+// Return the cached value of key, or load, store and return it.
+// Concurrent misses in this process share one load, and a short Redis lock
+// keeps other instances waiting for the value instead of loading it too.
+// Redis failures are logged and the value is loaded directly.
+func (c *Cache) Fetch(ctx context.Context, key string, ttl time.Duration, tags []string, load func() ([]byte, error)) ([]byte, error) {
+	stats := c.counters(key)
+
+	value, err := c.redis.Get(ctx, keyPrefix+key).Bytes()
+	if err == nil {
+		stats.hits.Add(1)
+		return value, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		stats.errors.Add(1)
+		log.Printf("Cache read of %s failed: %v", key, err)
+		return load()
+	}
+	stats.misses.Add(1)
+
+	result, err, _ := c.group.Do(key, func() (interface{}, error) {
+		return c.fill(ctx, key, ttl, tags, load)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]byte), nil
+}
+
+func (c *Cache) fill(ctx context.Context, key string, ttl time.Duration, tags []string, load func() ([]byte, error)) ([]byte, error) {
+	lockKey := lockPrefix + key
+	locked := false
+	for attempt := 0; attempt < lockAttempts; attempt++ {
+		acquired, err := c.redis.SetNX(ctx, lockKey, 1, lockTTL).Result()
+		if err != nil {
+			break
+		}
+		if acquired {
+			locked = true
+			break
+		}
+
+		// Another instance is loading, wait for its value
+		time.Sleep(lockWait)
+		if value, err := c.redis.Get(ctx, keyPrefix+key).Bytes(); err == nil {
+			return value, nil
+		}
+	}
+	if locked {
+		defer c.redis.Del(ctx, lockKey)
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := c.redis.TxPipeline()
+	pipe.Set(ctx, keyPrefix+key, value, ttl)
+	for _, tag := range tags {
+		pipe.SAdd(ctx, tagPrefix+tag, keyPrefix+key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.counters(key).errors.Add(1)
+		log.Printf("Cache write of %s failed: %v", key, err)
+	}
+
+	return value, nil
+}
+
+// Drop every entry stored with one of the tags.
+// The time is kept per tag so readers know when their data last changed.
+func (c *Cache) Invalidate(ctx context.Context, tags ...string) error {
+	now := time.Now().UnixMilli()
+	for _, tag := range tags {
+		keys, err := c.redis.SMembers(ctx, tagPrefix+tag).Result()
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, tagPrefix+tag)
+		if err := c.redis.Del(ctx, keys...).Err(); err != nil {
+			return err
+		}
+		if err := c.redis.Set(ctx, changedPrefix+tag, now, 0).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Last time one of the tags was invalidated, zero if none ever was
+func (c *Cache) Changed(ctx context.Context, tags ...string) (time.Time, error) {
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, changedPrefix+tag)
+	}
+
+	values, err := c.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	for _, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		millis, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue
+		}
+		if changed := time.UnixMilli(millis); changed.After(latest) {
+			latest = changed
+		}
+	}
+	return latest, nil
+}
+
+// Same as Invalidate but failures are only logged, for use after writes
+// that already succeeded.
+func (c *Cache) Drop(ctx context.Context, tags ...string) {
+	if err := c.Invalidate(ctx, tags...); err != nil {
+		log.Printf("Cache invalidation of %v failed: %v", tags, err)
+	}
+}
+
+// Counters per key namespace, the part of the key before the first colon
+func (c *Cache) Stats() map[string]Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]Stats, len(c.metrics))
+	for namespace, counter := range c.metrics {
+		stats[namespace] = Stats{
+			Hits:   counter.hits.Load(),
+			Misses: counter.misses.Load(),
+			Errors: counter.errors.Load(),
+		}
+	}
+	return stats
+}
+
+func (c *Cache) counters(key string) *counters {
+	namespace, _, _ := strings.Cut(key, ":")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.metrics[namespace]
+	if !ok {
+		counter = &counters{}
+		c.metrics[namespace] = counter
+	}
+	return counter
+}
@@ -0,0 +1,484 @@
+package carts
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/promotions"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const MaxQuantity = 99
+
+var (
+	ErrInvalidQuantity = errors.New("quantity must be between 1 and 99")
+	ErrUnknownVariant  = errors.New("product has no such colour or size")
+	ErrUnknownProduct  = errors.New("product is not available")
+	ErrUnknownLine     = errors.New("cart line not found")
+)
+
+// Amounts of a cart in a single currency
+type Totals struct {
+	Subtotal    int    `json:"subtotal"`
+	Discount    int    `json:"discount"` // coupon and free units of promotions
+	Shipping    int    `json:"shipping"`
+	TaxEstimate int    `json:"tax_estimate"`
+	Total       int    `json:"total"`
+	Currency    string `json:"currency"`
+
+	// Tax by rate, already in the prices when inclusive
+	Taxes        []models.TaxLine `json:"taxes"`
+	TaxInclusive bool             `json:"tax_inclusive"`
+}
+
+// Tax of the cart lines
+type Tax struct {
+	Amount    int
+	Inclusive bool
+	Lines     []models.TaxLine
+}
+
+// Estimates the tax of the cart lines and their shipping to the address,
+// amounts in the base currency
+type TaxEstimator func(db *gorm.DB, address models.Address, lines []coupons.Line, discount int, shipping int) (Tax, error)
+
+// Without a tax engine a flat TAX_ESTIMATE_RATE percentage is applied
+var estimator TaxEstimator = func(db *gorm.DB, address models.Address, lines []coupons.Line, discount int, shipping int) (Tax, error) {
+	tax := Tax{Lines: []models.TaxLine{}}
+	rate, _ := strconv.ParseFloat(os.Getenv("TAX_ESTIMATE_RATE"), 64)
+	if rate <= 0 {
+		return tax, nil
+	}
+
+	taxable := -discount
+	for _, line := range lines {
+		taxable += line.UnitPrice * line.Quantity
+	}
+	taxable = max(taxable, 0) + shipping
+	tax.Amount = int(float64(taxable)*rate/100 + 0.5)
+	tax.Lines = append(tax.Lines, models.TaxLine{Name: "Tax", Rate: rate, Taxable: taxable, Amount: tax.Amount})
+	return tax, nil
+}
+
+func SetTaxEstimator(fn TaxEstimator) {
+	estimator = fn
+}
+
+// An empty colour or size means any
+func ValidateVariant(product models.Product, colour string, size string) error {
+	if colour != "" && !slices.Contains(product.Colour, colour) {
+		return ErrUnknownVariant
+	}
+	if size != "" && !slices.Contains(product.Size, size) {
+		return ErrUnknownVariant
+	}
+	return nil
+}
+
+// Load the cart of the id with its lines, a missing cart is empty
+func Load(db *gorm.DB, id uuid.UUID) (models.Cart, error) {
+	cart := models.Cart{Id: id}
+	if err := db.Where("id = ?", id).Find(&cart).Error; err != nil {
+		return cart, err
+	}
+
+	cart.Lines = []models.CartLine{}
+	err := db.Where("cart = ?", id).Order("added_at").Find(&cart.Lines).Error
+	return cart, err
+}
+
+// Price of one unit of the product right now, in the base currency
+func UnitPrice(db *gorm.DB, converter *currency.Converter, product models.Product) (int, error) {
+	var active []models.Promotion
+	if err := db.Where("active").Find(&active).Error; err != nil {
+		return 0, err
+	}
+	return unitPrice(converter, product, active)
+}
+
+func unitPrice(converter *currency.Converter, product models.Product, active []models.Promotion) (int, error) {
+	return converter.Convert(promotions.EffectivePrice(product, active).Price, product.Currency, converter.Base())
+}
+
+// Amount of the lines given free by the running buy X get Y promotions.
+// Units of a product count together whatever their variant, the cheapest
+// are the free ones.
+func freeUnits(db *gorm.DB, lines []coupons.Line) (int, error) {
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	var active []models.Promotion
+	if err := db.Where("active and type = ?", promotions.TypeBuyXGetY).Find(&active).Error; err != nil {
+		return 0, err
+	}
+	return quantityDiscount(lines, active), nil
+}
+
+func quantityDiscount(lines []coupons.Line, active []models.Promotion) int {
+	var order []uuid.UUID
+	products := map[uuid.UUID]coupons.Line{}
+	for _, line := range lines {
+		grouped, ok := products[line.Product.Id]
+		if !ok {
+			order = append(order, line.Product.Id)
+			products[line.Product.Id] = line
+			continue
+		}
+		grouped.Quantity += line.Quantity
+		grouped.UnitPrice = min(grouped.UnitPrice, line.UnitPrice)
+		products[line.Product.Id] = grouped
+	}
+
+	amount := 0
+	for _, id := range order {
+		line := products[id]
+		amount += promotions.QuantityDiscount(line.Product, active, line.Quantity, line.UnitPrice)
+	}
+	return amount
+}
+
+// Add units of a product to the cart, a line of the same variant grows instead
+func AddLine(tx *gorm.DB, converter *currency.Converter, cartId uuid.UUID, line models.CartLine) (models.CartLine, error) {
+	product, err := checkLine(tx, line)
+	if err != nil {
+		return line, err
+	}
+
+	if err := touch(tx, cartId); err != nil {
+		return line, err
+	}
+
+	var existing models.CartLine
+	err = tx.Where("cart = ? and product = ? and colour = ? and size = ?", cartId, line.Product, line.Colour, line.Size).
+		First(&existing).Error
+	if err == nil {
+		return SetQuantity(tx, cartId, existing.Id, existing.Quantity+line.Quantity)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return line, err
+	}
+
+	if err := reserveStored(tx, cartId, product.Id, line.Quantity); err != nil {
+		return line, err
+	}
+
+	price, err := UnitPrice(tx, converter, product)
+	if err != nil {
+		return line, err
+	}
+
+	line.Id = uuid.Nil
+	line.Cart = cartId
+	line.UnitPrice = price
+	line.Currency = converter.Base()
+	return line, tx.Create(&line).Error
+}
+
+// The product of the line must be on sale in its variant
+func checkLine(db *gorm.DB, line models.CartLine) (models.Product, error) {
+	var product models.Product
+	if line.Quantity < 1 || line.Quantity > MaxQuantity {
+		return product, ErrInvalidQuantity
+	}
+	if err := db.First(&product, "id = ? and active and status = ?", line.Product, workflow.StatusPublished).Error; err != nil {
+		return product, ErrUnknownProduct
+	}
+	return product, ValidateVariant(product, line.Colour, line.Size)
+}
+
+// Change the quantity of a line, zero removes it
+func SetQuantity(tx *gorm.DB, cartId uuid.UUID, lineId uuid.UUID, quantity int) (models.CartLine, error) {
+	var line models.CartLine
+	if quantity == 0 {
+		return line, RemoveLine(tx, cartId, lineId)
+	}
+	if quantity < 0 || quantity > MaxQuantity {
+		return line, ErrInvalidQuantity
+	}
+
+	if err := tx.First(&line, "id = ? and cart = ?", lineId, cartId).Error; err != nil {
+		return line, ErrUnknownLine
+	}
+	if err := reserveStored(tx, cartId, line.Product, quantity-line.Quantity); err != nil {
+		return line, err
+	}
+	if err := touch(tx, cartId); err != nil {
+		return line, err
+	}
+
+	line.Quantity = quantity
+	return line, tx.Model(&line).Update("quantity", quantity).Error
+}
+
+func RemoveLine(tx *gorm.DB, cartId uuid.UUID, lineId uuid.UUID) error {
+	var line models.CartLine
+	if err := tx.First(&line, "id = ? and cart = ?", lineId, cartId).Error; err != nil {
+		return ErrUnknownLine
+	}
+	if err := reserveStored(tx, cartId, line.Product, -line.Quantity); err != nil {
+		return err
+	}
+	if err := tx.Delete(&line).Error; err != nil {
+		return err
+	}
+	return touch(tx, cartId)
+}
+
+// Replace every line of the cart
+func Replace(tx *gorm.DB, converter *currency.Converter, cartId uuid.UUID, lines []models.CartLine) error {
+	if err := tx.Where("cart = ?", cartId).Delete(&models.CartLine{}).Error; err != nil {
+		return err
+	}
+	if err := inventory.ReleaseCart(tx, cartId); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := AddLine(tx, converter, cartId, line); err != nil {
+			return err
+		}
+	}
+	return touch(tx, cartId)
+}
+
+// Lines priced with their unit price snapshot, for coupons and taxes
+func CouponLines(db *gorm.DB, cart models.Cart) ([]coupons.Line, error) {
+	if len(cart.Lines) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(cart.Lines))
+	for _, line := range cart.Lines {
+		ids = append(ids, line.Product)
+	}
+
+	var items []models.Product
+	if err := db.Where("id in ?", ids).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	products := make(map[uuid.UUID]models.Product, len(items))
+	for _, item := range items {
+		products[item.Id] = item
+	}
+
+	lines := make([]coupons.Line, 0, len(cart.Lines))
+	for _, line := range cart.Lines {
+		product, ok := products[line.Product]
+		if !ok {
+			continue
+		}
+		lines = append(lines, coupons.Line{
+			Product:   product,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+		})
+	}
+	return lines, nil
+}
+
+// Flag the lines whose product can no longer be sold in the cart quantity,
+// and the lines whose price changed since they were added
+func Check(db *gorm.DB, converter *currency.Converter, cart *models.Cart) error {
+	if len(cart.Lines) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(cart.Lines))
+	wanted := map[uuid.UUID]int{}
+	for _, line := range cart.Lines {
+		ids = append(ids, line.Product)
+		wanted[line.Product] += line.Quantity
+	}
+
+	var items []models.Product
+	if err := db.Where("id in ?", ids).Find(&items).Error; err != nil {
+		return err
+	}
+	var active []models.Promotion
+	if err := db.Where("active").Find(&active).Error; err != nil {
+		return err
+	}
+
+	available := make(map[uuid.UUID]int, len(items))
+	products := make(map[uuid.UUID]models.Product, len(items))
+	for _, item := range items {
+		units, err := inventory.Available(db, item, cart.Id)
+		if err != nil {
+			return err
+		}
+		available[item.Id] = units
+		products[item.Id] = item
+	}
+
+	for i := range cart.Lines {
+		line := &cart.Lines[i]
+		product, ok := products[line.Product]
+		line.Unavailable = !ok || !product.Active || product.Status != workflow.StatusPublished ||
+			ValidateVariant(product, line.Colour, line.Size) != nil ||
+			wanted[line.Product] > available[line.Product]
+		if !ok {
+			continue
+		}
+
+		price, err := unitPrice(converter, product, active)
+		if err != nil {
+			return err
+		}
+		line.CurrentPrice = price
+		line.PriceChanged = price != line.UnitPrice
+	}
+	return nil
+}
+
+// Take the current prices into the lines whose price changed, once the
+// customer was shown the change
+func Reprice(db *gorm.DB, converter *currency.Converter, cart *models.Cart) error {
+	if err := Check(db, converter, cart); err != nil {
+		return err
+	}
+	for i := range cart.Lines {
+		line := &cart.Lines[i]
+		if !line.PriceChanged {
+			continue
+		}
+		if err := db.Model(line).Update("unit_price", line.CurrentPrice).Error; err != nil {
+			return err
+		}
+		line.PriceChanged = false
+	}
+	return nil
+}
+
+// Validate a coupon against the cart and its account.
+// returns the coupon and the discount it gives on the cart.
+func CheckCoupon(db *gorm.DB, cart models.Cart, code string) (models.Coupon, int, error) {
+	coupon, err := coupons.FindByCode(db, code)
+	if err != nil {
+		return coupon, 0, err
+	}
+
+	// A guest cart has no account, its limits are checked again at checkout
+	var owner models.Account
+	uses := 0
+	err = db.First(&owner, "id = ?", cart.Id).Error
+	switch {
+	case err == nil:
+		if uses, err = coupons.AccountUses(db, coupon, owner); err != nil {
+			return coupon, 0, err
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return coupon, 0, err
+	}
+
+	if err := coupons.Validate(coupon, owner, uses, time.Now()); err != nil {
+		return coupon, 0, err
+	}
+
+	lines, err := CouponLines(db, cart)
+	if err != nil {
+		return coupon, 0, err
+	}
+
+	discount, err := coupons.Discount(coupon, lines)
+	return coupon, discount, err
+}
+
+// Compute the totals of the cart lines shipped to the address in the base
+// currency, discount is what the applied coupon takes off and shipping what
+// the chosen method costs, both before tax. Units given free by buy X get Y
+// promotions are added to the discount.
+func Compute(db *gorm.DB, lines []coupons.Line, discount int, shipping int, address models.Address, base string) (Totals, error) {
+	free, err := freeUnits(db, lines)
+	if err != nil {
+		return Totals{}, err
+	}
+	discount += free
+
+	totals := Totals{Discount: discount, Shipping: shipping, Currency: base}
+	for _, line := range lines {
+		totals.Subtotal += line.UnitPrice * line.Quantity
+	}
+
+	tax, err := estimator(db, address, lines, discount, shipping)
+	if err != nil {
+		return totals, err
+	}
+
+	totals.TaxEstimate = tax.Amount
+	totals.Taxes = tax.Lines
+	totals.TaxInclusive = tax.Inclusive
+	totals.Total = totals.total()
+	return totals, nil
+}
+
+// Tax is only added on top of prices that do not include it
+func (t Totals) total() int {
+	if t.TaxInclusive {
+		return max(t.Subtotal-t.Discount, 0) + t.Shipping
+	}
+	return max(t.Subtotal-t.Discount, 0) + t.Shipping + t.TaxEstimate
+}
+
+// Convert the amounts of the totals, the total is recomputed
+// so it still adds up after rounding
+func (t Totals) Convert(converter *currency.Converter, code string) (Totals, error) {
+	converted := Totals{Currency: code, TaxInclusive: t.TaxInclusive}
+	var err error
+	if converted.Subtotal, err = converter.Convert(t.Subtotal, t.Currency, code); err != nil {
+		return t, err
+	}
+	if converted.Discount, err = converter.Convert(t.Discount, t.Currency, code); err != nil {
+		return t, err
+	}
+	if converted.Shipping, err = converter.Convert(t.Shipping, t.Currency, code); err != nil {
+		return t, err
+	}
+	if converted.TaxEstimate, err = converter.Convert(t.TaxEstimate, t.Currency, code); err != nil {
+		return t, err
+	}
+
+	converted.Taxes = make([]models.TaxLine, len(t.Taxes))
+	for i, line := range t.Taxes {
+		converted.Taxes[i] = line
+		if converted.Taxes[i].Taxable, err = converter.Convert(line.Taxable, t.Currency, code); err != nil {
+			return t, err
+		}
+		if converted.Taxes[i].Amount, err = converter.Convert(line.Amount, t.Currency, code); err != nil {
+			return t, err
+		}
+	}
+
+	converted.Total = converted.total()
+	return converted, nil
+}
+
+// Hold stock for the units of the product in the stored cart,
+// after one of its lines of the product changes by delta units
+func reserveStored(tx *gorm.DB, cartId uuid.UUID, product uuid.UUID, delta int) error {
+	var held int
+	if err := tx.Model(&models.CartLine{}).
+		Select("coalesce(sum(quantity), 0)").
+		Where("cart = ? and product = ?", cartId, product).
+		Scan(&held).Error; err != nil {
+		return err
+	}
+	return inventory.Reserve(tx, cartId, product, held+delta)
+}
+
+// Create the cart row if needed and bump its updated_at
+func touch(tx *gorm.DB, cartId uuid.UUID) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+	}).Create(&models.Cart{Id: cartId}).Error
+}
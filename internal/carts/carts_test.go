@@ -0,0 +1,49 @@
+package carts
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/promotions"
+)
+
+func TestQuantityDiscount(t *testing.T) {
+	shirt := models.Product{Id: uuid.New()}
+	socks := models.Product{Id: uuid.New()}
+	threeForTwo := []models.Promotion{{
+		Active:      true,
+		Type:        promotions.TypeBuyXGetY,
+		BuyQuantity: 2,
+		GetQuantity: 1,
+		TargetType:  promotions.TargetProduct,
+		Targets:     []string{shirt.Id.String()},
+	}}
+
+	tests := []struct {
+		name  string
+		lines []coupons.Line
+		want  int
+	}{
+		{"product without promotion", []coupons.Line{{Product: socks, Quantity: 3, UnitPrice: 500}}, 0},
+		{"single line", []coupons.Line{{Product: shirt, Quantity: 3, UnitPrice: 2000}}, 2000},
+		{
+			name: "variants count together, the cheapest is free",
+			lines: []coupons.Line{
+				{Product: shirt, Quantity: 2, UnitPrice: 2000},
+				{Product: socks, Quantity: 3, UnitPrice: 500},
+				{Product: shirt, Quantity: 1, UnitPrice: 1800},
+			},
+			want: 1800,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := quantityDiscount(test.lines, threeForTwo); got != test.want {
+				t.Errorf("quantityDiscount() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
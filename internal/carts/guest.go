@@ -0,0 +1,326 @@
+package carts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	GuestCookie = "cart_token"
+	GuestHeader = "X-Cart-Token"
+
+	// How conflicting lines are merged at login
+	MergeSum    = "sum"
+	MergeNewest = "newest"
+
+	DefaultGuestTTL = 7 * 24 * time.Hour
+
+	guestPrefix = "guest-cart:"
+
+	// Tries of a guest cart change racing with others
+	guestAttempts = 5
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid cart token")
+	ErrCartBusy     = errors.New("cart is being changed, try again")
+
+	guestInstance *GuestStore
+)
+
+// GuestStore keeps the carts of shoppers without an account in Redis.
+// A guest holds a signed token of its cart id, every change renews the TTL.
+type GuestStore struct {
+	redis  *redis.Client
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewGuestStore(redis *redis.Client, secret []byte) *GuestStore {
+
+	if guestInstance != nil {
+		return guestInstance
+	}
+
+	ttl, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = DefaultGuestTTL
+	}
+
+	guestInstance = &GuestStore{
+		redis:  redis,
+		secret: secret,
+		ttl:    ttl,
+	}
+
+	return guestInstance
+}
+
+func (gs *GuestStore) TTL() time.Duration {
+	return gs.ttl
+}
+
+// A new guest cart id and its token
+func (gs *GuestStore) NewToken() (uuid.UUID, string) {
+	id := uuid.New()
+	return id, id.String() + "." + gs.sign(id)
+}
+
+// The guest cart id of a token, if it was signed by this store
+func (gs *GuestStore) Parse(token string) (uuid.UUID, error) {
+	value, signature, found := strings.Cut(token, ".")
+	if !found {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(gs.sign(id))) {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return id, nil
+}
+
+func (gs *GuestStore) sign(id uuid.UUID) string {
+	mac := hmac.New(sha256.New, gs.secret)
+	mac.Write([]byte(id.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Load the guest cart, an expired or unknown cart is empty
+func (gs *GuestStore) Load(ctx context.Context, id uuid.UUID) (models.Cart, error) {
+	return gs.read(ctx, gs.redis, id)
+}
+
+// Apply a change to the guest cart and store it. The cart is watched while
+// it changes, a concurrent change makes this one start over from the new cart.
+func (gs *GuestStore) Update(ctx context.Context, id uuid.UUID, change func(cart *models.Cart) error) (models.Cart, error) {
+	key := guestPrefix + id.String()
+
+	var cart models.Cart
+	for attempt := 0; attempt < guestAttempts; attempt++ {
+		err := gs.redis.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			if cart, err = gs.read(ctx, tx, id); err != nil {
+				return err
+			}
+			if err := change(&cart); err != nil {
+				return err
+			}
+
+			cart.UpdatedAt = time.Now()
+			value, err := json.Marshal(&cart)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return pipe.Set(ctx, key, value, gs.ttl).Err()
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return cart, err
+		}
+	}
+	return cart, ErrCartBusy
+}
+
+func (gs *GuestStore) read(ctx context.Context, client redis.Cmdable, id uuid.UUID) (models.Cart, error) {
+	cart := models.Cart{Id: id, Lines: []models.CartLine{}}
+
+	value, err := client.Get(ctx, guestPrefix+id.String()).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return cart, nil
+	}
+	if err != nil {
+		return cart, err
+	}
+
+	err = json.Unmarshal(value, &cart)
+	return cart, err
+}
+
+func (gs *GuestStore) Drop(ctx context.Context, id uuid.UUID) error {
+	return gs.redis.Del(ctx, guestPrefix+id.String()).Err()
+}
+
+// Add units of a product to a guest cart, a line of the same variant grows instead
+func AddGuestLine(db *gorm.DB, converter *currency.Converter, cart *models.Cart, line models.CartLine) error {
+	product, err := checkLine(db, line)
+	if err != nil {
+		return err
+	}
+
+	if i := findLine(cart.Lines, line); i >= 0 {
+		return SetGuestQuantity(db, cart, cart.Lines[i].Id, cart.Lines[i].Quantity+line.Quantity)
+	}
+
+	if err := reserveGuest(db, cart, product.Id, line.Quantity); err != nil {
+		return err
+	}
+
+	price, err := UnitPrice(db, converter, product)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	line.Id = uuid.New()
+	line.Cart = cart.Id
+	line.UnitPrice = price
+	line.Currency = converter.Base()
+	line.AddedAt = now
+	line.UpdatedAt = now
+	cart.Lines = append(cart.Lines, line)
+	return nil
+}
+
+// Change the quantity of a guest cart line, zero removes it
+func SetGuestQuantity(db *gorm.DB, cart *models.Cart, lineId uuid.UUID, quantity int) error {
+	if quantity == 0 {
+		return RemoveGuestLine(db, cart, lineId)
+	}
+	if quantity < 0 || quantity > MaxQuantity {
+		return ErrInvalidQuantity
+	}
+
+	for i := range cart.Lines {
+		if cart.Lines[i].Id == lineId {
+			if err := reserveGuest(db, cart, cart.Lines[i].Product, quantity-cart.Lines[i].Quantity); err != nil {
+				return err
+			}
+			cart.Lines[i].Quantity = quantity
+			cart.Lines[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrUnknownLine
+}
+
+func RemoveGuestLine(db *gorm.DB, cart *models.Cart, lineId uuid.UUID) error {
+	i := slices.IndexFunc(cart.Lines, func(line models.CartLine) bool {
+		return line.Id == lineId
+	})
+	if i < 0 {
+		return ErrUnknownLine
+	}
+	if err := reserveGuest(db, cart, cart.Lines[i].Product, -cart.Lines[i].Quantity); err != nil {
+		return err
+	}
+
+	cart.Lines = slices.Delete(cart.Lines, i, i+1)
+	return nil
+}
+
+// Replace every line of a guest cart
+func ReplaceGuest(db *gorm.DB, converter *currency.Converter, cart *models.Cart, lines []models.CartLine) error {
+	if err := inventory.ReleaseCart(db, cart.Id); err != nil {
+		return err
+	}
+
+	cart.Lines = []models.CartLine{}
+	for _, line := range lines {
+		if err := AddGuestLine(db, converter, cart, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge rule of GUEST_CART_MERGE, quantities are summed by default
+func MergeRule() string {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("GUEST_CART_MERGE"))) == MergeNewest {
+		return MergeNewest
+	}
+	return MergeSum
+}
+
+// Move the lines of a guest cart into the cart of the account.
+// A variant in both carts is merged by the rule, summed quantities are
+// capped and lines of products no longer on sale or in stock are left behind.
+// The coupon of the guest moves along when the account cart has none.
+func Merge(tx *gorm.DB, converter *currency.Converter, account uuid.UUID, guest models.Cart, rule string) error {
+	cart, err := Load(tx, account)
+	if err != nil {
+		return err
+	}
+
+	// The account cart holds its own stock from here
+	if err := inventory.ReleaseCart(tx, guest.Id); err != nil {
+		return err
+	}
+
+	for _, line := range guest.Lines {
+		i := findLine(cart.Lines, line)
+		if i < 0 {
+			_, err := AddLine(tx, converter, account, models.CartLine{
+				Product:  line.Product,
+				Colour:   line.Colour,
+				Size:     line.Size,
+				Quantity: line.Quantity,
+			})
+			if errors.Is(err, ErrUnknownProduct) || errors.Is(err, ErrUnknownVariant) ||
+				errors.Is(err, inventory.ErrInsufficientStock) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		existing := cart.Lines[i]
+		quantity := min(existing.Quantity+line.Quantity, MaxQuantity)
+		if rule == MergeNewest {
+			quantity = existing.Quantity
+			if line.UpdatedAt.After(existing.UpdatedAt) {
+				quantity = line.Quantity
+			}
+		}
+		if quantity == existing.Quantity {
+			continue
+		}
+		_, err := SetQuantity(tx, account, existing.Id, quantity)
+		if err != nil && !errors.Is(err, inventory.ErrInsufficientStock) {
+			return err
+		}
+	}
+
+	if guest.Coupon != "" && cart.Coupon == "" {
+		return tx.Model(&models.Cart{}).Where("id = ?", account).Update("coupon", guest.Coupon).Error
+	}
+	return nil
+}
+
+// Hold stock for the units of the product in the guest cart,
+// after one of its lines of the product changes by delta units
+func reserveGuest(db *gorm.DB, cart *models.Cart, product uuid.UUID, delta int) error {
+	held := 0
+	for _, line := range cart.Lines {
+		if line.Product == product {
+			held += line.Quantity
+		}
+	}
+	return inventory.Reserve(db, cart.Id, product, held+delta)
+}
+
+// Index of the line of the same variant, -1 when there is none
+func findLine(lines []models.CartLine, line models.CartLine) int {
+	return slices.IndexFunc(lines, func(other models.CartLine) bool {
+		return other.Product == line.Product && other.Colour == line.Colour && other.Size == line.Size
+	})
+}
@@ -0,0 +1,412 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+const (
+	EntityProduct  = "product"
+	EntityBrand    = "brand"
+	EntityCategory = "category"
+
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+
+	// Separator used for list columns (images, colours, size...) in CSV files
+	listSeparator = "|"
+)
+
+var (
+	ErrUnknownEntity = errors.New("unknown catalog entity")
+	ErrUnknownFormat = errors.New("unknown catalog format")
+
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// A single decoded line of an import file.
+// Record is a pointer to the model matching the import entity.
+type Row struct {
+	Line   int
+	Record any
+	Errors []models.ImportRowError
+
+	// Columns the line gives a value for, the others are left as stored
+	Fields []string
+
+	// Whether Record was merged onto a stored record of the same id
+	Stored bool
+}
+
+func (r *Row) addError(field, message string) {
+	r.Errors = append(r.Errors, models.ImportRowError{
+		Row:     r.Line,
+		Field:   field,
+		Message: message,
+	})
+}
+
+// Return an empty model for the given entity
+func NewRecord(entity string) (any, error) {
+	switch entity {
+	case EntityProduct:
+		return &models.Product{}, nil
+	case EntityBrand:
+		return &models.Brand{}, nil
+	case EntityCategory:
+		return &models.Category{}, nil
+	}
+	return nil, ErrUnknownEntity
+}
+
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
+// Read every row of an import file.
+// returns an error only if the file itself cannot be read,
+// problems with individual rows are reported on the row.
+func ReadRows(r io.Reader, entity, format string) ([]Row, error) {
+	if _, err := NewRecord(entity); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatCSV:
+		return readCSV(r, entity)
+	case FormatNDJSON:
+		return readNDJSON(r, entity)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func readCSV(r io.Reader, entity string) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv header: %w", err)
+	}
+
+	record, _ := NewRecord(entity)
+	fields := jsonFields(reflect.TypeOf(record).Elem())
+	columns := make([]int, len(header))
+	for i, name := range header {
+		index, ok := fields[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[i] = index
+	}
+
+	var rows []Row
+	line := 1
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+
+		record, _ := NewRecord(entity)
+		row := Row{Line: line, Record: record}
+		if err != nil {
+			row.addError("", err.Error())
+			rows = append(rows, row)
+			continue
+		}
+
+		// Empty cells are not given, the stored value is kept
+		value := reflect.ValueOf(record).Elem()
+		for i, raw := range values {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			row.Fields = append(row.Fields, strings.TrimSpace(header[i]))
+
+			field := value.Field(columns[i])
+			if err := setField(field, raw); err != nil {
+				row.addError(header[i], err.Error())
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func readNDJSON(r io.Reader, entity string) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		record, _ := NewRecord(entity)
+		row := Row{Line: line, Record: record}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(record); err != nil {
+			row.addError("", err.Error())
+		}
+
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(data, &present); err == nil {
+			for name, value := range present {
+				if string(value) != "null" {
+					row.Fields = append(row.Fields, name)
+				}
+			}
+			sort.Strings(row.Fields)
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Writer streams catalog records as CSV or NDJSON
+type Writer struct {
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	columns []string
+	header  bool
+}
+
+func NewWriter(w io.Writer, entity, format string) (*Writer, error) {
+	record, err := NewRecord(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatCSV:
+		return &Writer{
+			format:  format,
+			csv:     csv.NewWriter(w),
+			columns: columnNames(reflect.TypeOf(record).Elem()),
+		}, nil
+	case FormatNDJSON:
+		return &Writer{
+			format: format,
+			json:   json.NewEncoder(w),
+		}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Write a single model, record must be a model or a pointer to one.
+func (w *Writer) Write(record any) error {
+	if w.format == FormatNDJSON {
+		return w.json.Encode(record)
+	}
+
+	if !w.header {
+		if err := w.csv.Write(w.columns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(record))
+	values := make([]string, 0, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		if _, ok := jsonName(value.Type().Field(i)); !ok {
+			continue
+		}
+		values = append(values, formatField(value.Field(i)))
+	}
+	return w.csv.Write(values)
+}
+
+func (w *Writer) Flush() error {
+	if w.csv == nil {
+		return nil
+	}
+
+	// Empty exports still get a header
+	if !w.header {
+		if err := w.csv.Write(w.columns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// Column name of a model field, computed fields are not part of the files
+func jsonName(field reflect.StructField) (string, bool) {
+	if field.Tag.Get("gorm") == "-" {
+		return "", false
+	}
+
+	tag := field.Tag.Get("json")
+	name := strings.Split(tag, ",")[0]
+	if name == "" || name == "-" {
+		return "", false
+	}
+	return name, true
+}
+
+// Apply the fields the row gives onto the stored record with the same id,
+// so the row is validated as it will be saved. stored is a model value.
+func Merge(row *Row, stored any) {
+	merged := reflect.New(reflect.TypeOf(stored)).Elem()
+	merged.Set(reflect.ValueOf(stored))
+
+	given := reflect.ValueOf(row.Record).Elem()
+	fields := jsonFields(given.Type())
+	for _, name := range row.Fields {
+		if index, ok := fields[name]; ok {
+			merged.Field(index).Set(given.Field(index))
+		}
+	}
+
+	row.Record = merged.Addr().Interface()
+	row.Stored = true
+}
+
+// Names of the struct fields of the record behind the file columns
+func FieldNames(record any, columns []string) []string {
+	t := reflect.TypeOf(record).Elem()
+	fields := jsonFields(t)
+
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if index, ok := fields[column]; ok {
+			names = append(names, t.Field(index).Name)
+		}
+	}
+	return names
+}
+
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonName(t.Field(i)); ok {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func columnNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonName(t.Field(i)); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func setField(field reflect.Value, raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	switch field.Type() {
+	case uuidType:
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return errors.New("invalid uuid")
+		}
+		field.Set(reflect.ValueOf(id))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return errors.New("invalid timestamp, expected RFC3339")
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return errors.New("invalid number")
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.New("invalid boolean")
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("unsupported column")
+		}
+		parts := strings.Split(raw, listSeparator)
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		field.Set(reflect.ValueOf(parts))
+	default:
+		return errors.New("unsupported column")
+	}
+	return nil
+}
+
+func formatField(field reflect.Value) string {
+	switch field.Type() {
+	case uuidType:
+		id := field.Interface().(uuid.UUID)
+		if id == uuid.Nil {
+			return ""
+		}
+		return id.String()
+	case timeType:
+		t := field.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String()
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(field.Int(), 10)
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool())
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			return strings.Join(field.Interface().([]string), listSeparator)
+		}
+	}
+	return fmt.Sprint(field.Interface())
+}
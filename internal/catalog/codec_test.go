@@ -0,0 +1,95 @@
+package catalog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+func TestReadRowsFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		file   string
+		want   [][]string
+	}{
+		{
+			name:   "every column given",
+			format: FormatCSV,
+			file:   "id,name,stock\n6a1c3c1e-0d4b-4b8e-9d55-1f0c3a2b4c5d,Shirt,3\n",
+			want:   [][]string{{"id", "name", "stock"}},
+		},
+		{
+			name:   "empty cells are not given",
+			format: FormatCSV,
+			file:   "id,name,stock\n6a1c3c1e-0d4b-4b8e-9d55-1f0c3a2b4c5d,,3\n6a1c3c1e-0d4b-4b8e-9d55-1f0c3a2b4c5d, Shirt ,\n",
+			want:   [][]string{{"id", "stock"}, {"id", "name"}},
+		},
+		{
+			name:   "keys present on the line",
+			format: FormatNDJSON,
+			file:   `{"stock":3,"id":"6a1c3c1e-0d4b-4b8e-9d55-1f0c3a2b4c5d"}` + "\n",
+			want:   [][]string{{"id", "stock"}},
+		},
+		{
+			name:   "null values are not given",
+			format: FormatNDJSON,
+			file:   `{"id":"6a1c3c1e-0d4b-4b8e-9d55-1f0c3a2b4c5d","name":null,"stock":0}` + "\n",
+			want:   [][]string{{"id", "stock"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := ReadRows(strings.NewReader(test.file), EntityProduct, test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(test.want) {
+				t.Fatalf("read %d rows, want %d", len(rows), len(test.want))
+			}
+			for i, row := range rows {
+				if len(row.Errors) > 0 {
+					t.Errorf("row %d errors = %v", i, row.Errors)
+				}
+				if !reflect.DeepEqual(row.Fields, test.want[i]) {
+					t.Errorf("row %d fields = %v, want %v", i, row.Fields, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	id := uuid.New()
+	brand := uuid.New()
+	stored := models.Product{Id: id, Name: "Shirt", Price: 2500, Brand: brand, Stock: 10, Version: 4}
+
+	rows, err := ReadRows(strings.NewReader("id,stock\n"+id.String()+",3\n"), EntityProduct, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &rows[0]
+	Merge(row, stored)
+
+	want := stored
+	want.Stock = 3
+	if got := *row.Record.(*models.Product); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %+v, want %+v", got, want)
+	}
+	if !row.Stored {
+		t.Error("merged row is not marked as stored")
+	}
+	if stored.Stock != 10 {
+		t.Errorf("stored stock changed to %d", stored.Stock)
+	}
+
+	// The merged row passes the rules a partial row alone would fail
+	NewReferences([]models.Brand{{Id: brand}}, nil, nil).Validate(row)
+	if len(row.Errors) > 0 {
+		t.Errorf("merged row errors = %v", row.Errors)
+	}
+}
@@ -0,0 +1,106 @@
+package catalog
+
+import (
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+// Existing catalog entries rows are allowed to reference.
+// Brands and categories created earlier in the same import are added as they pass.
+type References struct {
+	Brands     map[uuid.UUID]bool
+	Categories map[string]bool
+	TaxClasses map[string]bool
+}
+
+func NewReferences(brands []models.Brand, categories []models.Category, taxClasses []string) References {
+	refs := References{
+		Brands:     make(map[uuid.UUID]bool, len(brands)),
+		Categories: make(map[string]bool, len(categories)),
+		TaxClasses: make(map[string]bool, len(taxClasses)),
+	}
+	for _, class := range taxClasses {
+		refs.TaxClasses[class] = true
+	}
+	for _, brand := range brands {
+		refs.Brands[brand.Id] = true
+	}
+	for _, category := range categories {
+		refs.Categories[category.Id.String()] = true
+	}
+	return refs
+}
+
+// Check the row against the model rules.
+// Valid brands and categories are registered in refs.
+func (refs References) Validate(row *Row) {
+	switch record := row.Record.(type) {
+	case *models.Product:
+		validateProduct(row, record, refs)
+	case *models.Brand:
+		validateBrand(row, record)
+		if len(row.Errors) == 0 && record.Id != uuid.Nil {
+			refs.Brands[record.Id] = true
+		}
+	case *models.Category:
+		validateCategory(row, record)
+		if len(row.Errors) == 0 && record.Id != uuid.Nil {
+			refs.Categories[record.Id.String()] = true
+		}
+	}
+}
+
+func validateProduct(row *Row, product *models.Product, refs References) {
+	if product.Name == "" {
+		row.addError("name", "name is required")
+	}
+	if product.Price < 0 {
+		row.addError("price", "price cannot be negative")
+	}
+	// Prices without a currency are in the store currency
+	if product.Currency != "" && !currency.Known(product.Currency) {
+		row.addError("currency", "unknown currency "+product.Currency)
+	}
+	if product.Stock < 0 {
+		row.addError("stock", "stock cannot be negative")
+	}
+	if product.Weight < 0 || product.Length < 0 || product.Width < 0 || product.Height < 0 {
+		row.addError("weight", "weight and dimensions cannot be negative")
+	}
+	if product.SalePercent < 0 || product.SalePercent > 100 {
+		row.addError("sale_percent", "sale percent must be between 0 and 100")
+	}
+	if product.OnSale && product.SalePrice <= 0 && product.SalePercent == 0 {
+		row.addError("sale_price", "product on sale requires a sale price or sale percent")
+	}
+	if product.SalePrice < 0 || (product.SalePrice > 0 && product.SalePrice >= product.Price) {
+		row.addError("sale_price", "sale price must be lower than price")
+	}
+	if product.Brand == uuid.Nil {
+		row.addError("brand", "brand is required")
+	} else if !refs.Brands[product.Brand] {
+		row.addError("brand", "brand "+product.Brand.String()+" does not exist")
+	}
+	for _, category := range product.Categories {
+		if !refs.Categories[category] {
+			row.addError("categories", "category "+category+" does not exist")
+		}
+	}
+	// Products without a tax class are in the standard class
+	if product.TaxClass != "" && !refs.TaxClasses[product.TaxClass] {
+		row.addError("tax_class", "tax class "+product.TaxClass+" has no rates")
+	}
+}
+
+func validateBrand(row *Row, brand *models.Brand) {
+	if brand.Name == "" {
+		row.addError("name", "name is required")
+	}
+}
+
+func validateCategory(row *Row, category *models.Category) {
+	if category.Name == "" {
+		row.addError("name", "name is required")
+	}
+}
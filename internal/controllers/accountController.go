@@ -3,15 +3,23 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/mail"
 	"reflect"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/currency"
 	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
 	"github.com/kevinhartarto/market-be/internal/models"
 	"github.com/kevinhartarto/market-be/internal/utils"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 )
 
 // Identity Controller represent a service that handle all things related to Identity
@@ -45,6 +53,11 @@ type AccountController interface {
 	// Update a role
 	// return an error if the role not found
 	UpdateRole(c *fiber.Ctx) error
+
+	// Partial updates with a JSON merge patch or JSON patch
+	PatchAccount(c *fiber.Ctx) error
+
+	PatchRole(c *fiber.Ctx) error
 }
 
 var (
@@ -56,14 +69,23 @@ var (
 	loginCreds   loginCredentials
 	affectedRows int64
 
+	// Fields a patch may change, passwords and roles have their own flows
+	accountPatchFields = []string{"email", "username"}
+	rolePatchFields    = []string{
+		"name", "can_view", "can_add", "can_edit", "can_delete",
+		"can_buy", "can_wishlist", "is_admin", "is_owner", "deprecated",
+	}
+
 	rolesKey   = "roles"
 	unverified = "unverified"
 	verified   = "verified"
 )
 
 type accountController struct {
-	db    database.Service
-	redis *redis.Client
+	db        database.Service
+	redis     *redis.Client
+	converter *currency.Converter
+	guests    *carts.GuestStore
 }
 
 type loginCredentials struct {
@@ -78,8 +100,10 @@ func NewAccountController(db database.Service, redis *redis.Client) *accountCont
 	}
 
 	accountInstance = &accountController{
-		db:    db,
-		redis: redis,
+		db:        db,
+		redis:     redis,
+		converter: currency.NewConverter(),
+		guests:    guestCarts(redis),
 	}
 
 	return accountInstance
@@ -98,6 +122,11 @@ func (ac *accountController) Login(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusUnauthorized)
 	}
 
+	// The login goes on without the guest cart rather than failing
+	if err := ac.mergeGuestCart(c, account.Id); err != nil {
+		log.Printf("Unable to merge guest cart: %v", err)
+	}
+
 	tokenString := utils.GenerateJWT(account.Email, account.Role)
 	if tokenString == "" {
 		return c.SendStatus(fiber.StatusBadRequest)
@@ -110,6 +139,32 @@ func (ac *accountController) Login(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"token": tokenString})
 }
 
+// A cart filled before logging in joins the cart of the account,
+// the guest cart and its token are dropped afterwards
+func (ac *accountController) mergeGuestCart(c *fiber.Ctx, accountId uuid.UUID) error {
+	guestId, err := ac.guests.Parse(guestToken(c))
+	if err != nil {
+		return nil
+	}
+
+	guest, err := ac.guests.Load(c.Context(), guestId)
+	if err != nil {
+		return err
+	}
+
+	if len(guest.Lines) > 0 || guest.Coupon != "" {
+		err := ac.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+			return carts.Merge(tx, ac.converter, accountId, guest, carts.MergeRule())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	c.ClearCookie(carts.GuestCookie)
+	return ac.guests.Drop(c.Context(), guestId)
+}
+
 func (ac *accountController) CreateAccount(c *fiber.Ctx) error {
 	if err := c.BodyParser(&account); err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
@@ -134,37 +189,83 @@ func (ac *accountController) CreateAccount(c *fiber.Ctx) error {
 
 func (ac *accountController) UpdateAccount(c *fiber.Ctx) error {
 	var updateAccount struct {
-		account     models.Account
-		updateType  string
-		updateValue bool
+		Account     models.Account `json:"account"`
+		UpdateType  string         `json:"update_type"`
+		UpdateValue bool           `json:"update_value"`
 	}
-	success := false
 
 	if err := c.BodyParser(&updateAccount); err != nil {
 		return err
 	}
 
-	switch updateAccount.updateType {
-	case "update":
-		affectedRows = ac.db.UseGorm().Save(&updateAccount.account).RowsAffected
-	case "status":
-		affectedRows = ac.db.UseGorm().Model(&updateAccount.account).Update("active", updateAccount.updateValue).RowsAffected
-	case "verified":
-		affectedRows = ac.db.UseGorm().Model(&updateAccount.account).Update("role", getRole(c.Context(), *ac.redis, ac.db, verified)).RowsAffected
+	target := updateAccount.Account
+	role, _ := middlewares.CurrentRole(c)
+	if !canEditAccount(c, target.Id) || (updateAccount.UpdateType != "update" && !role.IsAdmin) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden: insufficient permissions",
+		})
 	}
 
-	// This is not a batch updates
-	// Expect only 1 row changed
-	if affectedRows == 1 {
-		success = true
+	version, checked := expectedVersion(c, target.Version)
+	if updateAccount.UpdateType == "update" && !checked {
+		return missingVersion(c)
+	}
+	if updateAccount.UpdateType == "update" {
+		if err := validateAccountEmail(ac.db.UseGorm(), &target); err != nil {
+			status := fiber.StatusBadRequest
+			if errors.Is(err, errInUse) {
+				status = fiber.StatusConflict
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 	}
-	result, _ := json.Marshal(&updateAccount.account)
 
-	if success {
-		return c.SendString(string(result))
-	} else {
+	err := ac.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		switch updateAccount.UpdateType {
+		case "update":
+			// Passwords and roles have their own flows,
+			// only admins activate and verify accounts
+			omit := []string{"password", "role"}
+			if !role.IsAdmin {
+				omit = append(omit, "active", "verified")
+			}
+			target.Version = version + 1
+			result = updateVersioned(tx, &target, version, editorId(c), omit...)
+		case "status":
+			result = updateColumnVersioned(tx, &target, "active", updateAccount.UpdateValue, version, checked)
+		case "verified":
+			result = updateColumnVersioned(tx, &target, "role", getRole(c.Context(), *ac.redis, ac.db, verified), version, checked)
+		default:
+			return errInvalidUpdate
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// This is not a batch updates
+		// Expect only 1 row changed
+		affectedRows = result.RowsAffected
+		if affectedRows != 1 {
+			return errStaleVersion
+		}
+		return tx.First(&target, "id = ?", target.Id).Error
+	})
+
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict(c, ac.db.UseGorm(), target.Id, func(current *models.Account) {
+			current.Password = ""
+		})
+	}
+	if err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+
+	target.Password = ""
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
 }
 
 func (ac *accountController) GetAccount(c *fiber.Ctx) error {
@@ -203,37 +304,102 @@ func (ac *accountController) CreateRole(c *fiber.Ctx) error {
 
 func (ac *accountController) UpdateRole(c *fiber.Ctx) error {
 	var updateRole struct {
-		role        models.Role
-		updateType  string
-		updateValue bool
+		Role        models.Role `json:"role"`
+		UpdateType  string      `json:"update_type"`
+		UpdateValue bool        `json:"update_value"`
 	}
-	success := false
 
 	if err := c.BodyParser(&updateRole); err != nil {
 		return err
 	}
 
-	switch updateRole.updateType {
-	case "update":
-		affectedRows = ac.db.UseGorm().Save(&updateRole.role).RowsAffected
-	case "admin":
-		affectedRows = ac.db.UseGorm().Model(&updateRole.role).Update("is_admin", updateRole.updateValue).RowsAffected
-	case "status":
-		affectedRows = ac.db.UseGorm().Model(&updateRole.role).Update("deprecated", updateRole.updateValue).RowsAffected
+	target := updateRole.Role
+	version, checked := expectedVersion(c, target.Version)
+	if updateRole.UpdateType == "update" && !checked {
+		return missingVersion(c)
 	}
 
-	// This is not a batch updates
-	// Expect only 1 row changed
-	if affectedRows == 1 {
-		success = true
-	}
-	result, _ := json.Marshal(&updateRole.role)
+	err := ac.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		switch updateRole.UpdateType {
+		case "update":
+			target.Version = version + 1
+			result = updateVersioned(tx, &target, version, editorId(c))
+		case "admin":
+			result = updateColumnVersioned(tx, &target, "is_admin", updateRole.UpdateValue, version, checked)
+		case "status":
+			result = updateColumnVersioned(tx, &target, "deprecated", updateRole.UpdateValue, version, checked)
+		default:
+			return errInvalidUpdate
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// This is not a batch updates
+		// Expect only 1 row changed
+		affectedRows = result.RowsAffected
+		if affectedRows != 1 {
+			return errStaleVersion
+		}
+		return tx.First(&target, "id = ?", target.Id).Error
+	})
 
-	if success {
-		return c.SendString(string(result))
-	} else {
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict[models.Role](c, ac.db.UseGorm(), target.Id, nil)
+	}
+	if err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+
+	// Cached roles are what permissions are checked against
+	ac.LoadRoles(c.Context())
+
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
+}
+
+func (ac *accountController) PatchAccount(c *fiber.Ctx) error {
+	id, _ := uuid.Parse(c.Query("id"))
+	if !canEditAccount(c, id) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden: insufficient permissions",
+		})
+	}
+
+	return patchRecord(c, ac.db.UseGorm(), patchOptions[models.Account]{
+		allowed:  accountPatchFields,
+		validate: validateAccountEmail,
+		hide: func(record *models.Account) {
+			record.Password = ""
+		},
+	})
+}
+
+// Accounts log in with their email, it must be an address no other account uses
+func validateAccountEmail(db *gorm.DB, account *models.Account) error {
+	account.Email = strings.TrimSpace(account.Email)
+	if address, err := mail.ParseAddress(account.Email); err != nil || address.Address != account.Email {
+		return errors.New("invalid email address")
+	}
+
+	var taken int64
+	if err := db.Model(&models.Account{}).Where("email = ? and id <> ?", account.Email, account.Id).Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return fmt.Errorf("email %w", errInUse)
+	}
+	return nil
+}
+
+func (ac *accountController) PatchRole(c *fiber.Ctx) error {
+	return patchRecord(c, ac.db.UseGorm(), patchOptions[models.Role]{
+		allowed: rolePatchFields,
+		done: func(record *models.Role) {
+			ac.LoadRoles(c.Context())
+		},
+	})
 }
 
 func (ac *accountController) GetAllRoles(c *fiber.Ctx) error {
@@ -272,3 +438,12 @@ func getRole(ctx context.Context, redis redis.Client, db database.Service, roleN
 
 	return role.Id
 }
+
+// Users may only change their own account, admins any
+func canEditAccount(c *fiber.Ctx, id uuid.UUID) bool {
+	if role, ok := middlewares.CurrentRole(c); ok && role.IsAdmin {
+		return true
+	}
+	current, ok := middlewares.CurrentAccount(c)
+	return ok && current.Id == id
+}
@@ -2,36 +2,68 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/currency"
 	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
 	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/shipping"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 )
 
 type CartController interface {
 
-	// Get cart by Id
+	// Get the cart of the signed in account or of the guest, with its totals
 	GetCart(c *fiber.Ctx) error
 
-	// Add selected product(s) to cart
+	// Replace the lines of the cart
 	UpdateCart(c *fiber.Ctx) error
+
+	// Add units of a product variant to the cart
+	AddLine(c *fiber.Ctx) error
+
+	// Change the quantity of a cart line, zero removes it
+	UpdateLine(c *fiber.Ctx) error
+
+	// Remove a line from the cart
+	RemoveLine(c *fiber.Ctx) error
+
+	// Apply a coupon code to the cart.
+	// returns an error if the coupon cannot be used on the cart
+	ApplyCoupon(c *fiber.Ctx) error
+
+	// Remove the coupon from the cart
+	RemoveCoupon(c *fiber.Ctx) error
+
+	// Quote the shipping methods to an address for the cart
+	GetShippingQuotes(c *fiber.Ctx) error
 }
 
-var (
-	cartInstance *cartController
-	cart         models.Cart
-)
+var cartInstance *cartController
 
 type cartController struct {
-	db    database.Service
-	redis *redis.Client
+	db        database.Service
+	redis     *redis.Client
+	converter *currency.Converter
+	guests    *carts.GuestStore
 }
 
+// Changes apply to the cart of the signed in account,
+// or to the guest cart of the request token
 type cartRequest struct {
-	Id       uuid.UUID
-	Products []string
+	Line  uuid.UUID         `json:"line"`
+	Lines []models.CartLine `json:"lines"`
+	models.CartLine
 }
 
 func NewCartController(db database.Service, redis *redis.Client) *cartController {
@@ -41,36 +73,385 @@ func NewCartController(db database.Service, redis *redis.Client) *cartController
 	}
 
 	cartInstance = &cartController{
-		db:    db,
-		redis: redis,
+		db:        db,
+		redis:     redis,
+		converter: currency.NewConverter(),
+		guests:    guestCarts(redis),
 	}
 
 	return cartInstance
 }
 
 func (cc *cartController) GetCart(c *fiber.Ctx) error {
-	accountId := c.Query("id")
-	if accountId == "" {
+	userCart, err := cc.requestCart(c)
+	if err != nil {
+		return err
+	}
+
+	return cc.sendCart(c, userCart)
+}
+
+func (cc *cartController) GetShippingQuotes(c *fiber.Ctx) error {
+	code, ok := cc.responseCurrency(c)
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid Request",
+			"error": currency.ErrUnknownCurrency.Error(),
 		})
 	}
 
-	if err := cc.db.UseGorm().First(&cart, accountId); err != nil {
-		return c.SendString("error: Unable to find account")
+	userCart, err := cc.requestCart(c)
+	if err != nil {
+		return err
 	}
 
-	result, _ := json.Marshal(&cart)
+	lines, discount, err := cc.pricedLines(&userCart)
+	if err != nil {
+		return err
+	}
 
-	return c.SendString(string(result))
+	quotes, err := shipping.Quotes(c.Context(), cc.db.UseGorm(), cc.converter, cartAddress(c), shipping.ParcelOf(lines, discount))
+	if errors.Is(err, shipping.ErrNoZone) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
 
+	for i := range quotes {
+		if quotes[i].Amount, err = cc.converter.Convert(quotes[i].Amount, quotes[i].Currency, code); err != nil {
+			return err
+		}
+		quotes[i].Currency = code
+	}
+
+	result, _ := json.Marshal(quotes)
+	return c.SendString(string(result))
 }
 
 func (cc *cartController) UpdateCart(c *fiber.Ctx) error {
-	requestBody := new(cartRequest)
-	if err := c.BodyParser(&requestBody); err != nil {
+	var request cartRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	cartId, ok := accountCart(c)
+	if !ok {
+		return cc.guestChanged(c, func(guest *models.Cart) error {
+			return carts.ReplaceGuest(cc.db.UseGorm(), cc.converter, guest, request.Lines)
+		})
+	}
+
+	err := cc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		return carts.Replace(tx, cc.converter, cartId, request.Lines)
+	})
+	return cc.cartChanged(c, cartId, err)
+}
+
+func (cc *cartController) AddLine(c *fiber.Ctx) error {
+	var request cartRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	cartId, ok := accountCart(c)
+	if !ok {
+		return cc.guestChanged(c, func(guest *models.Cart) error {
+			return carts.AddGuestLine(cc.db.UseGorm(), cc.converter, guest, request.CartLine)
+		})
+	}
+
+	err := cc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		_, err := carts.AddLine(tx, cc.converter, cartId, request.CartLine)
+		return err
+	})
+	return cc.cartChanged(c, cartId, err)
+}
+
+func (cc *cartController) UpdateLine(c *fiber.Ctx) error {
+	var request cartRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	cartId, ok := accountCart(c)
+	if !ok {
+		return cc.guestChanged(c, func(guest *models.Cart) error {
+			return carts.SetGuestQuantity(cc.db.UseGorm(), guest, request.Line, request.Quantity)
+		})
+	}
+
+	err := cc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		_, err := carts.SetQuantity(tx, cartId, request.Line, request.Quantity)
+		return err
+	})
+	return cc.cartChanged(c, cartId, err)
+}
+
+func (cc *cartController) RemoveLine(c *fiber.Ctx) error {
+	lineId, err := uuid.Parse(c.Query("line"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	cartId, ok := accountCart(c)
+	if !ok {
+		return cc.guestChanged(c, func(guest *models.Cart) error {
+			return carts.RemoveGuestLine(cc.db.UseGorm(), guest, lineId)
+		})
+	}
+
+	err = cc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		return carts.RemoveLine(tx, cartId, lineId)
+	})
+	return cc.cartChanged(c, cartId, err)
+}
+
+// Answer a cart change with the cart, or with what went wrong
+func (cc *cartController) cartChanged(c *fiber.Ctx, cartId uuid.UUID, err error) error {
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	userCart, err := carts.Load(cc.db.UseGorm(), cartId)
+	if err != nil {
+		return err
+	}
+	return cc.sendCart(c, userCart)
+}
+
+// Apply a change to the guest cart of the request and store it
+func (cc *cartController) guestChanged(c *fiber.Ctx, change func(guest *models.Cart) error) error {
+	guest, err := cc.guests.Update(c.Context(), cc.guestId(c), change)
+	if err != nil {
+		return cartError(c, err)
+	}
+	return cc.sendCart(c, guest)
+}
+
+// The guest cart of the request token
+func (cc *cartController) guestCart(c *fiber.Ctx) (models.Cart, error) {
+	return cc.guests.Load(c.Context(), cc.guestId(c))
+}
+
+// The guest cart id of the request token.
+// Without a valid token a new guest cart is started and its token handed out.
+func (cc *cartController) guestId(c *fiber.Ctx) uuid.UUID {
+	if id, err := cc.guests.Parse(guestToken(c)); err == nil {
+		c.Set(carts.GuestHeader, guestToken(c))
+		return id
+	}
+
+	id, token := cc.guests.NewToken()
+	c.Cookie(&fiber.Cookie{
+		Name:     carts.GuestCookie,
+		Value:    token,
+		Expires:  time.Now().Add(cc.guests.TTL()),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	c.Set(carts.GuestHeader, token)
+	return id
+}
+
+func cartError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, carts.ErrUnknownLine), errors.Is(err, carts.ErrUnknownProduct):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, carts.ErrInvalidQuantity), errors.Is(err, carts.ErrUnknownVariant):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrInsufficientStock), errors.Is(err, carts.ErrCartBusy):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return err
+}
+
+func couponError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, coupons.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, carts.ErrCartBusy) {
+		return cartError(c, err)
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// Guest cart token of the request, from its cookie or header
+func guestToken(c *fiber.Ctx) string {
+	if token := c.Cookies(carts.GuestCookie); token != "" {
+		return token
+	}
+	return c.Get(carts.GuestHeader)
+}
+
+// Guest cart tokens are signed with GUEST_CART_SECRET, or the JWT key
+func guestCarts(redis *redis.Client) *carts.GuestStore {
+	secret := []byte(os.Getenv("GUEST_CART_SECRET"))
+	if len(secret) == 0 {
+		secret = middlewares.SecretKey
+	}
+	return carts.NewGuestStore(redis, secret)
+}
+
+// Send the cart with its totals in the requested currency, taxed where
+// the request ships to. A coupon that no longer applies gives no discount.
+func (cc *cartController) sendCart(c *fiber.Ctx, userCart models.Cart) error {
+	code, ok := cc.responseCurrency(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": currency.ErrUnknownCurrency.Error(),
+		})
+	}
+
+	lines, discount, err := cc.pricedLines(&userCart)
+	if err != nil {
+		return err
+	}
+
+	// Shipping is only added once a method is chosen at checkout
+	totals, err := carts.Compute(cc.db.UseGorm(), lines, discount, 0, cartAddress(c), cc.converter.Base())
+	if err != nil {
+		return err
+	}
+	if totals, err = totals.Convert(cc.converter, code); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"cart":   userCart,
+		"totals": totals,
+	})
+}
+
+// The cart of the signed in account, the guest cart without one
+func (cc *cartController) requestCart(c *fiber.Ctx) (models.Cart, error) {
+	cartId, ok := accountCart(c)
+	if !ok {
+		return cc.guestCart(c)
+	}
+	return carts.Load(cc.db.UseGorm(), cartId)
+}
+
+// Carts are keyed by the account they belong to, taken from the token
+func accountCart(c *fiber.Ctx) (uuid.UUID, bool) {
+	account, ok := middlewares.CurrentAccount(c)
+	return account.Id, ok
+}
+
+// Check the cart lines and price them, with the discount of its coupon
+func (cc *cartController) pricedLines(userCart *models.Cart) ([]coupons.Line, int, error) {
+	if err := carts.Check(cc.db.UseGorm(), cc.converter, userCart); err != nil {
+		return nil, 0, err
+	}
+
+	lines, err := carts.CouponLines(cc.db.UseGorm(), *userCart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	discount := 0
+	if userCart.Coupon != "" {
+		if _, amount, err := carts.CheckCoupon(cc.db.UseGorm(), *userCart, userCart.Coupon); err == nil {
+			discount = amount
+		}
+	}
+	return lines, discount, nil
+}
+
+// Where the cart would ship to, from the country and postcode of the request
+func cartAddress(c *fiber.Ctx) models.Address {
+	return models.Address{
+		Country:  strings.ToUpper(c.Query("country")),
+		Postcode: c.Query("postcode"),
+	}
+}
+
+// Currency of the response, the base currency unless another is requested
+func (cc *cartController) responseCurrency(c *fiber.Ctx) (string, bool) {
+	code := requestCurrency(c)
+	if code == "" {
+		code = cc.converter.Base()
+	}
+	return code, cc.converter.Supports(code)
+}
+
+func (cc *cartController) ApplyCoupon(c *fiber.Ctx) error {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	cartId, ok := accountCart(c)
+	if !ok {
+		guest, err := cc.guests.Update(c.Context(), cc.guestId(c), func(guest *models.Cart) error {
+			coupon, _, err := carts.CheckCoupon(cc.db.UseGorm(), *guest, request.Code)
+			guest.Coupon = coupon.Code
+			return err
+		})
+		if err != nil {
+			return couponError(c, err)
+		}
+		return cc.sendCart(c, guest)
+	}
+	if err := cc.db.UseGorm().First(&models.Cart{}, "id = ?", cartId).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find cart",
+		})
+	}
+
+	userCart, err := carts.Load(cc.db.UseGorm(), cartId)
+	if err != nil {
+		return err
+	}
+
+	coupon, _, err := carts.CheckCoupon(cc.db.UseGorm(), userCart, request.Code)
+	if err != nil {
+		return couponError(c, err)
+	}
+
+	userCart.Coupon = coupon.Code
+	if err := cc.db.UseGorm().Model(&userCart).Update("coupon", coupon.Code).Error; err != nil {
 		return err
 	}
 
-	return cc.db.UseGorm().Save(&requestBody).Error
+	return cc.sendCart(c, userCart)
+}
+
+func (cc *cartController) RemoveCoupon(c *fiber.Ctx) error {
+	cartId, ok := accountCart(c)
+	if !ok {
+		_, err := cc.guests.Update(c.Context(), cc.guestId(c), func(guest *models.Cart) error {
+			guest.Coupon = ""
+			return nil
+		})
+		if err != nil {
+			return cartError(c, err)
+		}
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	result := cc.db.UseGorm().Model(&models.Cart{}).Where("id = ?", cartId).Update("coupon", "")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	return c.SendStatus(fiber.StatusOK)
 }
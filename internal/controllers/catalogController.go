@@ -0,0 +1,506 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/catalog"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"github.com/kevinhartarto/market-be/internal/revisions"
+	"github.com/kevinhartarto/market-be/internal/tax"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CatalogController interface {
+
+	// Start an import job for products, brands or categories.
+	// returns the job, rows are processed in the background.
+	ImportCatalog(c *fiber.Ctx) error
+
+	// Get the status and row errors of an import job
+	GetImportJob(c *fiber.Ctx) error
+
+	// Stream the catalog as CSV or NDJSON
+	ExportCatalog(c *fiber.Ctx) error
+}
+
+var (
+	catalogInstance *catalogController
+
+	importBatchSize = 100
+	exportBatchSize = 500
+)
+
+const (
+	importPending   = "pending"
+	importRunning   = "running"
+	importCompleted = "completed"
+	importFailed    = "failed"
+)
+
+type catalogController struct {
+	db    database.Service
+	redis *redis.Client
+	cache *cache.Cache
+}
+
+func NewCatalogController(db database.Service, redis *redis.Client) *catalogController {
+
+	if catalogInstance != nil {
+		return catalogInstance
+	}
+
+	catalogInstance = &catalogController{
+		db:    db,
+		redis: redis,
+		cache: cache.NewCache(redis),
+	}
+
+	return catalogInstance
+}
+
+func (cc *catalogController) ImportCatalog(c *fiber.Ctx) error {
+	entity := c.Query("entity", catalog.EntityProduct)
+	if _, err := catalog.NewRecord(entity); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid entity",
+		})
+	}
+
+	format := c.Query("format", formatFromContentType(c.Get(fiber.HeaderContentType)))
+	if !catalog.ValidFormat(format) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid format, expected csv or ndjson",
+		})
+	}
+
+	if len(c.Body()) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Empty import file",
+		})
+	}
+
+	account, _ := middlewares.CurrentAccount(c)
+	job := models.ImportJob{
+		Entity:    entity,
+		Format:    format,
+		DryRun:    c.QueryBool("dry_run"),
+		Status:    importPending,
+		CreatedBy: account.Id,
+	}
+
+	if err := cc.db.UseGorm().Create(&job).Error; err != nil {
+		return err
+	}
+
+	// Fiber reuses the request buffer once the handler returns
+	body := append([]byte(nil), c.Body()...)
+	go cc.runImport(job, body)
+
+	result, _ := json.Marshal(&job)
+	return c.Status(fiber.StatusAccepted).SendString(string(result))
+}
+
+func (cc *catalogController) GetImportJob(c *fiber.Ctx) error {
+	jobId := c.Query("id")
+	if jobId == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	var job models.ImportJob
+	if err := cc.db.UseGorm().First(&job, "id = ?", jobId).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find import job",
+		})
+	}
+
+	result, _ := json.Marshal(&job)
+	return c.SendString(string(result))
+}
+
+func (cc *catalogController) ExportCatalog(c *fiber.Ctx) error {
+	entity := c.Query("entity", catalog.EntityProduct)
+	format := c.Query("format", catalog.FormatCSV)
+
+	if _, err := catalog.NewRecord(entity); err != nil || !catalog.ValidFormat(format) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid entity or format",
+		})
+	}
+
+	if format == catalog.FormatCSV {
+		c.Set(fiber.HeaderContentType, "text/csv")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s.%s\"", entity, format))
+
+	db := cc.db.UseGorm()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer, _ := catalog.NewWriter(w, entity, format)
+
+		var err error
+		switch entity {
+		case catalog.EntityProduct:
+			err = exportRecords[models.Product](db, writer, w)
+		case catalog.EntityBrand:
+			err = exportRecords[models.Brand](db, writer, w)
+		case catalog.EntityCategory:
+			err = exportRecords[models.Category](db, writer, w)
+		}
+		if err != nil {
+			log.Printf("Export of %s failed: %v", entity, err)
+		}
+
+		writer.Flush()
+		w.Flush()
+	})
+
+	return nil
+}
+
+func (cc *catalogController) runImport(job models.ImportJob, body []byte) {
+	db := cc.db.UseGorm()
+
+	job.Status = importRunning
+	db.Save(&job)
+
+	rows, err := catalog.ReadRows(bytes.NewReader(body), job.Entity, job.Format)
+	if err != nil {
+		job.Status = importFailed
+		job.Errors = []models.ImportRowError{{Message: err.Error()}}
+		db.Save(&job)
+		return
+	}
+	job.TotalRows = len(rows)
+
+	var brands []models.Brand
+	var categories []models.Category
+	db.Select("id").Find(&brands)
+	db.Select("id").Find(&categories)
+	taxClasses, err := tax.Classes(db)
+	if err != nil {
+		job.Status = importFailed
+		job.Errors = []models.ImportRowError{{Message: err.Error()}}
+		db.Save(&job)
+		return
+	}
+	refs := catalog.NewReferences(brands, categories, taxClasses)
+
+	switch job.Entity {
+	case catalog.EntityProduct:
+		err = mergeStored[models.Product](db, rows)
+	case catalog.EntityBrand:
+		err = mergeStored[models.Brand](db, rows)
+	case catalog.EntityCategory:
+		err = mergeStored[models.Category](db, rows)
+	}
+	if err != nil {
+		job.Status = importFailed
+		job.Errors = []models.ImportRowError{{Message: err.Error()}}
+		db.Save(&job)
+		return
+	}
+
+	var valid []*catalog.Row
+	for i := range rows {
+		row := &rows[i]
+		if len(row.Errors) == 0 {
+			prepareRecord(row.Record, job.CreatedBy)
+			refs.Validate(row)
+		}
+
+		if len(row.Errors) > 0 {
+			job.FailedRows++
+			job.Errors = append(job.Errors, row.Errors...)
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	if job.DryRun {
+		job.ProcessedRows = len(valid)
+		job.Status = importCompleted
+		db.Save(&job)
+		return
+	}
+
+	for start := 0; start < len(valid); start += importBatchSize {
+		end := min(start+importBatchSize, len(valid))
+		batch := valid[start:end]
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			switch job.Entity {
+			case catalog.EntityProduct:
+				return importProducts(tx, batch, job.CreatedBy)
+			case catalog.EntityBrand:
+				_, err := upsertRecords[models.Brand](tx, batch)
+				return err
+			default:
+				_, err := upsertRecords[models.Category](tx, batch)
+				return err
+			}
+		})
+
+		if err != nil {
+			job.FailedRows += len(batch)
+			for _, row := range batch {
+				job.Errors = append(job.Errors, models.ImportRowError{
+					Row:     row.Line,
+					Message: err.Error(),
+				})
+			}
+		} else {
+			job.ProcessedRows += len(batch)
+		}
+		db.Save(&job)
+	}
+
+	job.Status = importCompleted
+	db.Save(&job)
+
+	switch job.Entity {
+	case catalog.EntityProduct:
+		cc.cache.Drop(context.Background(), cache.TagProducts)
+	case catalog.EntityBrand:
+		cc.cache.Drop(context.Background(), cache.TagBrands)
+	case catalog.EntityCategory:
+		cc.cache.Drop(context.Background(), cache.TagCategories)
+	}
+}
+
+// Merge rows updating an existing record onto the stored one, so partial rows
+// are validated with the values they keep.
+func mergeStored[T any](db *gorm.DB, rows []catalog.Row) error {
+	var ids []uuid.UUID
+	for i := range rows {
+		if id := recordId(rows[i].Record); len(rows[i].Errors) == 0 && id != uuid.Nil {
+			ids = append(ids, id)
+		}
+	}
+
+	stored := make(map[uuid.UUID]T, len(ids))
+	for start := 0; start < len(ids); start += importBatchSize {
+		var batch []T
+		if err := db.Find(&batch, "id in ?", ids[start:min(start+importBatchSize, len(ids))]).Error; err != nil {
+			return err
+		}
+		for _, record := range batch {
+			stored[recordId(&record)] = record
+		}
+	}
+
+	for i := range rows {
+		if record, ok := stored[recordId(rows[i].Record)]; ok && len(rows[i].Errors) == 0 {
+			catalog.Merge(&rows[i], record)
+		}
+	}
+	return nil
+}
+
+// Give new rows an id so they can be referenced later in the same file,
+// and default ownership to the account running the import.
+func prepareRecord(record any, owner uuid.UUID) {
+	switch r := record.(type) {
+	case *models.Product:
+		if r.Id == uuid.Nil {
+			r.Id = uuid.New()
+		}
+		if r.Owner == uuid.Nil {
+			r.Owner = owner
+		}
+		r.UpdateBy = owner
+	case *models.Brand:
+		if r.Id == uuid.Nil {
+			r.Id = uuid.New()
+		}
+		if r.Owner == uuid.Nil {
+			r.Owner = owner
+		}
+		r.UpdateBy = owner
+	case *models.Category:
+		if r.Id == uuid.Nil {
+			r.Id = uuid.New()
+		}
+		if r.Owner == uuid.Nil {
+			r.Owner = owner
+		}
+		r.UpdateBy = owner
+	}
+}
+
+// Product content goes through the draft workflow like any other edit, rows
+// of new products and content columns become drafts of the import author.
+// Only stock, sales and activation are written to live products directly.
+func importProducts(tx *gorm.DB, rows []*catalog.Row, author uuid.UUID) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(&models.Product{}); err != nil {
+		return err
+	}
+
+	var live []*catalog.Row
+	for _, row := range rows {
+		product := row.Record.(*models.Product)
+
+		var content bool
+		var fields []string
+		for _, column := range row.Fields {
+			names := catalog.FieldNames(product, []string{column})
+			if len(names) == 1 {
+				if field := stmt.Schema.LookUpField(names[0]); field != nil && workflow.IsContent(field.DBName) {
+					content = true
+					continue
+				}
+			}
+			fields = append(fields, column)
+		}
+
+		if content || !row.Stored {
+			draft := models.ProductDraft{
+				Product: product.Id,
+				Data:    *product,
+				Status:  workflow.StatusDraft,
+				Author:  author,
+			}
+			if row.Stored {
+				draft.BaseVersion = product.Version
+			}
+			if err := tx.Create(&draft).Error; err != nil {
+				return err
+			}
+		}
+
+		// Rows giving nothing but their id are left out
+		given := slices.ContainsFunc(fields, func(column string) bool { return column != "id" })
+		if row.Stored && given {
+			row.Fields = fields
+			live = append(live, row)
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+
+	stored, err := upsertRecords[models.Product](tx, live)
+	if err != nil {
+		return err
+	}
+	for _, product := range stored {
+		if err := pricing.Record(tx, product, pricing.SourceImport); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert or update a batch of rows and return them as stored. Existing rows
+// keep their created_at and every column the file does not give a value for.
+func upsertRecords[T any](tx *gorm.DB, rows []*catalog.Row) ([]T, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	// Rows of a CSV file share their columns, NDJSON lines may differ
+	groups := map[string][]*catalog.Row{}
+	var order []string
+	for _, row := range rows {
+		key := strings.Join(row.Fields, ",")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		records := make([]*T, 0, len(group))
+		for _, row := range group {
+			records = append(records, row.Record.(*T))
+		}
+
+		columns := []string{"updated_by", "updated_at"}
+		for _, name := range catalog.FieldNames(group[0].Record, group[0].Fields) {
+			field := stmt.Schema.LookUpField(name)
+			if field == nil || field.DBName == "" || slices.Contains(columns, field.DBName) {
+				continue
+			}
+			if field.DBName != "id" && field.DBName != "created_at" && field.DBName != "version" {
+				columns = append(columns, field.DBName)
+			}
+		}
+
+		// Updated rows get a new version so pending edits on them become stale
+		updates := append(clause.AssignmentColumns(columns), clause.Assignment{
+			Column: clause.Column{Name: "version"},
+			Value:  gorm.Expr(stmt.Schema.Table + ".version + 1"),
+		})
+
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: updates,
+		}).Create(&records).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Revisions need the rows as stored, with their new version
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, recordId(row.Record))
+	}
+
+	var stored []T
+	if err := tx.Find(&stored, "id in ?", ids).Error; err != nil {
+		return nil, err
+	}
+	for i := range stored {
+		if err := revisions.Record(tx, &stored[i], revisions.SourceImport); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+func exportRecords[T any](db *gorm.DB, writer *catalog.Writer, w *bufio.Writer) error {
+	var batch []T
+	return db.FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := writer.Write(&batch[i]); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		return w.Flush()
+	}).Error
+}
+
+func formatFromContentType(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return catalog.FormatCSV
+	case strings.HasPrefix(contentType, "application/x-ndjson"),
+		strings.HasPrefix(contentType, "application/json"):
+		return catalog.FormatNDJSON
+	}
+	return ""
+}
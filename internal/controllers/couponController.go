@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+type CouponController interface {
+
+	// Retrieve all coupons, optionally filtered by batch
+	GetAllCoupons(c *fiber.Ctx) error
+
+	// Create a coupon with a chosen code.
+	// returns an error if the code already exists
+	CreateCoupon(c *fiber.Ctx) error
+
+	// Generate single-use coupons in bulk from a template
+	GenerateCoupons(c *fiber.Ctx) error
+
+	// Update a coupon
+	// returns an error if the coupon not found
+	UpdateCoupon(c *fiber.Ctx) error
+}
+
+var (
+	couponInstance *couponController
+
+	maxGeneratedCoupons = 10000
+)
+
+type couponController struct {
+	db    database.Service
+	redis *redis.Client
+}
+
+func NewCouponController(db database.Service, redis *redis.Client) *couponController {
+
+	if couponInstance != nil {
+		return couponInstance
+	}
+
+	couponInstance = &couponController{
+		db:    db,
+		redis: redis,
+	}
+
+	return couponInstance
+}
+
+func (cc *couponController) GetAllCoupons(c *fiber.Ctx) error {
+	var couponList []models.Coupon
+	query := cc.db.UseGorm().Order("created_at desc")
+	if batch := c.Query("batch"); batch != "" {
+		query = query.Where("batch = ?", batch)
+	}
+	query.Find(&couponList)
+
+	result, _ := json.Marshal(couponList)
+	return c.SendString(string(result))
+}
+
+func (cc *couponController) CreateCoupon(c *fiber.Ctx) error {
+	// New coupons are active unless the request says otherwise
+	coupon := models.Coupon{Active: true}
+	if err := c.BodyParser(&coupon); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	coupon.Code = coupons.NormaliseCode(coupon.Code)
+	coupon.UsedCount = 0
+	if err := coupons.ValidateDefinition(coupon); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if _, err := coupons.FindByCode(cc.db.UseGorm(), coupon.Code); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Coupon code already exists",
+		})
+	}
+
+	if err := cc.db.UseGorm().Create(&coupon).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&coupon)
+	return c.SendString(string(result))
+}
+
+func (cc *couponController) GenerateCoupons(c *fiber.Ctx) error {
+	var request struct {
+		Coupon models.Coupon `json:"coupon"`
+		Prefix string        `json:"prefix"`
+		Count  int           `json:"count"`
+	}
+	// Generated coupons are active unless the template says otherwise
+	request.Coupon.Active = true
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if request.Count <= 0 || request.Count > maxGeneratedCoupons {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid coupon count",
+		})
+	}
+
+	codes, err := coupons.GenerateCodes(request.Prefix, request.Count)
+	if err != nil {
+		return err
+	}
+
+	batch := uuid.NewString()
+	generated := make([]models.Coupon, 0, len(codes))
+	for _, code := range codes {
+		coupon := request.Coupon
+		coupon.Id = uuid.Nil
+		coupon.Code = code
+		coupon.Batch = batch
+		coupon.UsageLimit = 1
+		coupon.PerAccountLimit = 1
+		coupon.UsedCount = 0
+		generated = append(generated, coupon)
+	}
+
+	if err := coupons.ValidateDefinition(generated[0]); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := cc.db.UseGorm().CreateInBatches(&generated, 500).Error; err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"batch": batch,
+		"codes": codes,
+	})
+}
+
+func (cc *couponController) UpdateCoupon(c *fiber.Ctx) error {
+	var updateCoupon struct {
+		Coupon      models.Coupon `json:"coupon"`
+		UpdateType  string        `json:"update_type"`
+		UpdateValue bool          `json:"update_value"`
+	}
+
+	if err := c.BodyParser(&updateCoupon); err != nil {
+		return err
+	}
+
+	coupon := updateCoupon.Coupon
+	switch updateCoupon.UpdateType {
+	case "update":
+		db := cc.db.UseGorm()
+		var stored models.Coupon
+		if err := db.First(&stored, "id = ?", coupon.Id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unable to find coupon",
+			})
+		}
+
+		coupon.Code = coupons.NormaliseCode(coupon.Code)
+		if err := coupons.ValidateDefinition(coupon); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if existing, err := coupons.FindByCode(db, coupon.Code); err == nil && existing.Id != stored.Id {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Coupon code already exists",
+			})
+		}
+
+		// Redemptions and the batch are kept as stored
+		affectedRows = db.Model(&stored).Select("*").Omit("id", "used_count", "batch", "created_at").Updates(&coupon).RowsAffected
+		db.First(&coupon, "id = ?", stored.Id)
+	case "active":
+		affectedRows = cc.db.UseGorm().Model(&coupon).Update("active", updateCoupon.UpdateValue).RowsAffected
+	default:
+		affectedRows = 0
+	}
+
+	// This is not a batch updates
+	// Expect only 1 row changed
+	if affectedRows != 1 {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	result, _ := json.Marshal(&coupon)
+	return c.SendString(string(result))
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm/clause"
+)
+
+type CurrencyController interface {
+
+	// Load exchange rates from the rates file then from the database
+	LoadRates()
+
+	// Retrieve the exchange rates against the base currency
+	GetRates(c *fiber.Ctx) error
+
+	// Replace exchange rates.
+	// returns an error if a rate is invalid
+	UpdateRates(c *fiber.Ctx) error
+
+	// Retrieve the per currency prices of a product
+	GetProductPrices(c *fiber.Ctx) error
+
+	// Create or update per currency prices of products
+	UpdateProductPrices(c *fiber.Ctx) error
+}
+
+var currencyInstance *currencyController
+
+type currencyController struct {
+	db        database.Service
+	redis     *redis.Client
+	converter *currency.Converter
+	cache     *cache.Cache
+}
+
+func NewCurrencyController(db database.Service, redis *redis.Client) *currencyController {
+
+	if currencyInstance != nil {
+		return currencyInstance
+	}
+
+	currencyInstance = &currencyController{
+		db:        db,
+		redis:     redis,
+		converter: currency.NewConverter(),
+		cache:     cache.NewCache(redis),
+	}
+
+	return currencyInstance
+}
+
+func (cc *currencyController) LoadRates() {
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+		if err := cc.converter.LoadFile(path); err != nil {
+			log.Printf("Unable to load exchange rates from %s: %v", path, err)
+		}
+	}
+
+	var rates []models.ExchangeRate
+	if err := cc.db.UseGorm().Find(&rates).Error; err != nil {
+		log.Printf("Unable to load exchange rates: %v", err)
+		return
+	}
+
+	table := currency.RateTable{
+		Base:  cc.converter.Base(),
+		Rates: make(map[string]float64, len(rates)),
+	}
+	for _, rate := range rates {
+		table.Rates[rate.Currency] = rate.Rate
+	}
+
+	if err := cc.converter.Set(table); err != nil {
+		log.Printf("Unable to load exchange rates: %v", err)
+	}
+}
+
+func (cc *currencyController) GetRates(c *fiber.Ctx) error {
+	result, _ := json.Marshal(cc.converter.Table())
+	return c.SendString(string(result))
+}
+
+func (cc *currencyController) UpdateRates(c *fiber.Ctx) error {
+	var table currency.RateTable
+	if err := c.BodyParser(&table); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := cc.converter.Set(table); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Store the rebased rates so a restart gives the same conversions
+	stored := cc.converter.Table()
+	rates := make([]models.ExchangeRate, 0, len(stored.Rates))
+	for code, rate := range stored.Rates {
+		rates = append(rates, models.ExchangeRate{Currency: code, Rate: rate})
+	}
+
+	if err := cc.db.UseGorm().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).Create(&rates).Error; err != nil {
+		return err
+	}
+	cc.cache.Drop(c.Context(), cache.TagProducts)
+
+	result, _ := json.Marshal(stored)
+	return c.SendString(string(result))
+}
+
+func (cc *currencyController) GetProductPrices(c *fiber.Ctx) error {
+	productId, err := uuid.Parse(c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	var prices []models.ProductPrice
+	cc.db.UseGorm().Where("product = ?", productId).Order("currency asc").Find(&prices)
+
+	result, _ := json.Marshal(prices)
+	return c.SendString(string(result))
+}
+
+func (cc *currencyController) UpdateProductPrices(c *fiber.Ctx) error {
+	var prices []models.ProductPrice
+	if err := c.BodyParser(&prices); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	for i := range prices {
+		prices[i].Currency = currency.Normalise(prices[i].Currency)
+		if !cc.converter.Supports(prices[i].Currency) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Unsupported currency %s", prices[i].Currency),
+			})
+		}
+		if prices[i].Price <= 0 || prices[i].SalePrice < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid price",
+			})
+		}
+	}
+
+	if len(prices) == 0 {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := cc.db.UseGorm().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price", "sale_price", "updated_at"}),
+	}).Create(&prices).Error; err != nil {
+		return err
+	}
+	cc.cache.Drop(c.Context(), cache.TagProducts)
+
+	result, _ := json.Marshal(prices)
+	return c.SendString(string(result))
+}
@@ -0,0 +1,411 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/tax"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type DraftController interface {
+
+	// Retrieve drafts, optionally filtered by status or product
+	GetAllDrafts(c *fiber.Ctx) error
+
+	// Get draft by Id
+	GetDraft(c *fiber.Ctx) error
+
+	// Start a draft of a new or existing product.
+	// copies the live product when no data is sent
+	CreateDraft(c *fiber.Ctx) error
+
+	// Update the content of a draft
+	// only the author can, while the draft is not in review
+	UpdateDraft(c *fiber.Ctx) error
+
+	// Send a draft to a reviewer
+	SubmitDraft(c *fiber.Ctx) error
+
+	// Approve a draft in review, it is published now or at publish_at
+	ApproveDraft(c *fiber.Ctx) error
+
+	// Send a draft back to its author with notes
+	RejectDraft(c *fiber.Ctx) error
+
+	// Remove a live product from the public catalog
+	ArchiveProduct(c *fiber.Ctx) error
+}
+
+var draftInstance *draftController
+
+type draftController struct {
+	db        database.Service
+	redis     *redis.Client
+	cache     *cache.Cache
+	publisher *workflow.Publisher
+}
+
+type draftRequest struct {
+	Id        uuid.UUID       `json:"id"`
+	Product   uuid.UUID       `json:"product"`
+	Data      *models.Product `json:"data"`
+	Reviewer  uuid.UUID       `json:"reviewer"`
+	Notes     string          `json:"notes"`
+	PublishAt *time.Time      `json:"publish_at"`
+}
+
+func NewDraftController(db database.Service, redis *redis.Client, publisher *workflow.Publisher) *draftController {
+
+	if draftInstance != nil {
+		return draftInstance
+	}
+
+	draftInstance = &draftController{
+		db:        db,
+		redis:     redis,
+		cache:     cache.NewCache(redis),
+		publisher: publisher,
+	}
+
+	return draftInstance
+}
+
+func (dc *draftController) GetAllDrafts(c *fiber.Ctx) error {
+	query := dc.visibleDrafts(c).Order("updated_at desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if product := c.Query("product"); product != "" {
+		query = query.Where("product = ?", product)
+	}
+
+	var drafts []models.ProductDraft
+	if err := query.Find(&drafts).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(drafts)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) GetDraft(c *fiber.Ctx) error {
+	var draft models.ProductDraft
+	if err := dc.visibleDrafts(c).First(&draft, "id = ?", c.Query("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find draft",
+		})
+	}
+
+	result, _ := json.Marshal(&draft)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) CreateDraft(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request draftRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	draft := models.ProductDraft{
+		Product: request.Product,
+		Status:  workflow.StatusDraft,
+		Author:  account.Id,
+	}
+
+	// Drafts of a live product remember its version, publishing is refused
+	// once someone else changed the product
+	if request.Product != uuid.Nil {
+		if err := dc.db.UseGorm().First(&draft.Data, "id = ?", request.Product).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unable to find product",
+			})
+		}
+		draft.BaseVersion = draft.Data.Version
+	}
+
+	switch {
+	case request.Data != nil:
+		if err := validateDraftData(dc.db.UseGorm(), request.Data); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		draft.Data = *request.Data
+	case request.Product == uuid.Nil:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	// A new product gets its id now so every draft of it points to the same row
+	if draft.Product == uuid.Nil {
+		draft.Product = uuid.New()
+		draft.Data.Owner = account.Id
+	}
+
+	if err := dc.db.UseGorm().Create(&draft).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&draft)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) UpdateDraft(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request draftRequest
+	if err := c.BodyParser(&request); err != nil || request.Data == nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	draft, err := dc.findDraft(request.Id.String())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find draft",
+		})
+	}
+
+	if draft.Author != account.Id {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can edit this draft",
+		})
+	}
+	if draft.Status != workflow.StatusDraft {
+		return workflowError(c, workflow.ErrInvalidTransition)
+	}
+
+	if err := validateDraftData(dc.db.UseGorm(), request.Data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	draft.Data = *request.Data
+	if err := dc.db.UseGorm().Save(&draft).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&draft)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) SubmitDraft(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request draftRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	draft, err := dc.findDraft(request.Id.String())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find draft",
+		})
+	}
+
+	if draft.Author != account.Id {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can submit this draft",
+		})
+	}
+	if request.Reviewer == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing reviewer",
+		})
+	}
+	if request.Reviewer == draft.Author {
+		return workflowError(c, workflow.ErrSelfReview)
+	}
+	if !workflow.CanMove(draft.Status, workflow.StatusInReview) {
+		return workflowError(c, workflow.ErrInvalidTransition)
+	}
+
+	data := draft.Data
+	data.Id = draft.Product
+	if err := validateCatalogRecord(dc.db.UseGorm(), &data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	draft.Status = workflow.StatusInReview
+	draft.Reviewer = &request.Reviewer
+	draft.Notes = request.Notes
+	if err := dc.db.UseGorm().Save(&draft).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&draft)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) ApproveDraft(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+
+	var request draftRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	draft, err := dc.findDraft(request.Id.String())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find draft",
+		})
+	}
+
+	if draft.Status != workflow.StatusInReview {
+		return workflowError(c, workflow.ErrInvalidTransition)
+	}
+	if err := workflow.CheckReviewer(draft, account, role); err != nil {
+		return workflowError(c, err)
+	}
+
+	draft.ApprovedBy = &account.Id
+	draft.Notes = request.Notes
+
+	// Scheduled drafts are left to the publisher
+	if request.PublishAt != nil && request.PublishAt.After(time.Now()) {
+		draft.Status = workflow.StatusScheduled
+		draft.PublishAt = request.PublishAt
+		if err := dc.db.UseGorm().Save(&draft).Error; err != nil {
+			return err
+		}
+
+		result, _ := json.Marshal(&draft)
+		return c.SendString(string(result))
+	}
+
+	err = dc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		return workflow.Publish(tx, &draft)
+	})
+	if err != nil {
+		return workflowError(c, err)
+	}
+
+	dc.publisher.Published()
+	result, _ := json.Marshal(&draft)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) RejectDraft(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+
+	var request draftRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	draft, err := dc.findDraft(request.Id.String())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find draft",
+		})
+	}
+
+	if !workflow.CanMove(draft.Status, workflow.StatusDraft) {
+		return workflowError(c, workflow.ErrInvalidTransition)
+	}
+	if err := workflow.CheckReviewer(draft, account, role); err != nil {
+		return workflowError(c, err)
+	}
+
+	draft.Status = workflow.StatusDraft
+	draft.Notes = request.Notes
+	draft.ApprovedBy = nil
+	draft.PublishAt = nil
+	if err := dc.db.UseGorm().Save(&draft).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&draft)
+	return c.SendString(string(result))
+}
+
+func (dc *draftController) ArchiveProduct(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+	if !role.CanEdit {
+		return workflowError(c, workflow.ErrNotEditor)
+	}
+
+	var request draftRequest
+	if err := c.BodyParser(&request); err != nil || request.Product == uuid.Nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var product models.Product
+	err := dc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var err error
+		product, err = workflow.Archive(tx, request.Product, account.Id)
+		return err
+	})
+	if err != nil {
+		return workflowError(c, err)
+	}
+
+	dc.cache.Drop(c.Context(), cache.TagProducts)
+	result, _ := json.Marshal(&product)
+	return c.SendString(string(result))
+}
+
+// Admins see every draft, other accounts the drafts they wrote or review
+// and the drafts of the products they own
+func (dc *draftController) visibleDrafts(c *fiber.Ctx) *gorm.DB {
+	query := dc.db.UseGorm().Model(&models.ProductDraft{})
+	if role, _ := middlewares.CurrentRole(c); role.IsAdmin {
+		return query
+	}
+
+	account, _ := middlewares.CurrentAccount(c)
+	owned := dc.db.UseGorm().Model(&models.Product{}).Select("id").Where("owner = ?", account.Id)
+	return query.Where("author = ? or reviewer = ? or product in (?)", account.Id, account.Id, owned)
+}
+
+func (dc *draftController) findDraft(id string) (models.ProductDraft, error) {
+	var draft models.ProductDraft
+	err := dc.db.UseGorm().First(&draft, "id = ?", id).Error
+	return draft, err
+}
+
+func workflowError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, workflow.ErrInvalidTransition),
+		errors.Is(err, workflow.ErrStaleDraft):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, workflow.ErrNotReviewer),
+		errors.Is(err, workflow.ErrSelfReview),
+		errors.Is(err, workflow.ErrNotEditor):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return err
+}
+
+// Drafts may be incomplete, only what would break pricing is checked early
+func validateDraftData(db *gorm.DB, product *models.Product) error {
+	if product.Currency != "" && !currency.Known(product.Currency) {
+		return currency.ErrUnknownCurrency
+	}
+	return tax.ValidateClass(db, product.TaxClass)
+}
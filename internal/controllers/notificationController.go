@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/notifications"
+	"github.com/redis/go-redis/v9"
+)
+
+type NotificationController interface {
+
+	// Retrieve the notifications of the logged in account
+	GetNotifications(c *fiber.Ctx) error
+
+	// Mark notifications as read, all of them when no id is sent
+	ReadNotifications(c *fiber.Ctx) error
+}
+
+var notificationInstance *notificationController
+
+type notificationController struct {
+	db    database.Service
+	redis *redis.Client
+}
+
+func NewNotificationController(db database.Service, redis *redis.Client) *notificationController {
+
+	if notificationInstance != nil {
+		return notificationInstance
+	}
+
+	notificationInstance = &notificationController{
+		db:    db,
+		redis: redis,
+	}
+
+	return notificationInstance
+}
+
+func (nc *notificationController) GetNotifications(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	list, err := notifications.List(nc.db.UseGorm(), account.Id, c.QueryBool("unread"))
+	if err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(list)
+	return c.SendString(string(result))
+}
+
+func (nc *notificationController) ReadNotifications(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request struct {
+		Ids []uuid.UUID `json:"ids"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+	}
+
+	updated, err := notifications.MarkRead(nc.db.UseGorm(), account.Id, request.Ids)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"updated": updated})
+}
@@ -0,0 +1,376 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/documents"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/orders"
+	"github.com/kevinhartarto/market-be/internal/payments"
+	"github.com/kevinhartarto/market-be/internal/shipping"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type OrderController interface {
+
+	// Turn the cart of the logged in account into an order,
+	// shipped to the address with the chosen method
+	Checkout(c *fiber.Ctx) error
+
+	// Retrieve the orders of the logged in account, newest first
+	GetOrders(c *fiber.Ctx) error
+
+	// Get an order with its lines and history
+	GetOrder(c *fiber.Ctx) error
+
+	// Pay for a pending order of the logged in account
+	PayOrder(c *fiber.Ctx) error
+
+	// Cancel a pending order of the logged in account
+	CancelOrder(c *fiber.Ctx) error
+
+	// Move an order to another status, for admins
+	UpdateStatus(c *fiber.Ctx) error
+
+	// Download the invoice PDF of a paid order
+	GetInvoice(c *fiber.Ctx) error
+
+	// Download the packing slip PDF of a paid order
+	GetPackingSlip(c *fiber.Ctx) error
+}
+
+var orderInstance *orderController
+
+type orderController struct {
+	db        database.Service
+	redis     *redis.Client
+	converter *currency.Converter
+}
+
+func NewOrderController(db database.Service, redis *redis.Client) *orderController {
+
+	if orderInstance != nil {
+		return orderInstance
+	}
+
+	orderInstance = &orderController{
+		db:        db,
+		redis:     redis,
+		converter: currency.NewConverter(),
+	}
+
+	return orderInstance
+}
+
+func (oc *orderController) Checkout(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var delivery orders.Delivery
+	if err := c.BodyParser(&delivery); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	// Carriers are asked for the shipping price before the transaction
+	var order models.Order
+	quoted, err := orders.Quote(c.Context(), oc.db.UseGorm(), oc.converter, account, delivery)
+	if err == nil {
+		err = oc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+			var err error
+			order, err = orders.Checkout(tx, oc.converter, account, delivery, quoted)
+			return err
+		})
+	}
+	if errors.Is(err, orders.ErrPriceChanged) {
+		// The cart takes the new prices, the next checkout goes through
+		// once the customer has seen them
+		userCart, err := carts.Load(oc.db.UseGorm(), account.Id)
+		if err != nil {
+			return err
+		}
+		if err := carts.Reprice(oc.db.UseGorm(), oc.converter, &userCart); err != nil {
+			return err
+		}
+		return orderError(c, orders.ErrPriceChanged)
+	}
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	result, _ := json.Marshal(&order)
+	return c.Status(fiber.StatusCreated).SendString(string(result))
+}
+
+func (oc *orderController) GetOrders(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var orderList []models.Order
+	if err := oc.db.UseGorm().Where("account = ?", account.Id).Order("created_at desc").Find(&orderList).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(orderList)
+	return c.SendString(string(result))
+}
+
+func (oc *orderController) GetOrder(c *fiber.Ctx) error {
+	order, err := oc.findOrder(c, c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find order",
+		})
+	}
+
+	if err := orders.Load(oc.db.UseGorm(), &order); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&order)
+	return c.SendString(string(result))
+}
+
+func (oc *orderController) PayOrder(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request struct {
+		Id     uuid.UUID `json:"id"`
+		Method string    `json:"method"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var order models.Order
+	if err := oc.db.UseGorm().First(&order, "id = ? and account = ?", request.Id, account.Id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find order",
+		})
+	}
+
+	provider, err := payments.Default()
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	payment, err := payments.Pay(c.Context(), oc.db.UseGorm(), provider, &order, request.Method, account.Id)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	// Declines are kept, the shopper may try another payment method
+	status := fiber.StatusOK
+	if payment.Status == payments.StatusDeclined {
+		status = fiber.StatusPaymentRequired
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"order":   order,
+		"payment": payment,
+	})
+}
+
+func (oc *orderController) CancelOrder(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request struct {
+		Id   uuid.UUID `json:"id"`
+		Note string    `json:"note"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var order models.Order
+	if err := oc.db.UseGorm().First(&order, "id = ? and account = ?", request.Id, account.Id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find order",
+		})
+	}
+
+	// Shoppers can only cancel what has not been paid yet
+	if order.Status != orders.StatusPending {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": orders.ErrInvalidTransition.Error(),
+		})
+	}
+
+	return oc.transition(c, order, orders.StatusCancelled, account.Id, request.Note)
+}
+
+func (oc *orderController) UpdateStatus(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request struct {
+		Id     uuid.UUID `json:"id"`
+		Status string    `json:"status"`
+		Note   string    `json:"note"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var order models.Order
+	if err := oc.db.UseGorm().First(&order, "id = ?", request.Id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find order",
+		})
+	}
+
+	return oc.transition(c, order, request.Status, account.Id, request.Note)
+}
+
+func (oc *orderController) transition(c *fiber.Ctx, order models.Order, to string, by uuid.UUID, note string) error {
+	db := oc.db.UseGorm()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := orders.Load(tx, &order); err != nil {
+			return err
+		}
+
+		// Refunding the whole payment moves the order along
+		// once the provider gave it back
+		if to == orders.StatusRefunded {
+			if !orders.CanMove(order.Status, to) {
+				return orders.ErrInvalidTransition
+			}
+			_, err := payments.StartRefund(tx, &order, 0, &by)
+			return err
+		}
+		return orders.Transition(tx, &order, to, &by, note)
+	})
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	// The provider is only asked once the order change is committed
+	switch to {
+	case orders.StatusCancelled:
+		if err := payments.VoidPending(c.Context(), db, order); err != nil {
+			log.Printf("Unable to void the payments of order %s: %v", order.Id, err)
+		}
+	case orders.StatusRefunded:
+		if err := payments.CompleteRefunds(c.Context(), db, order.Id); err != nil {
+			return orderError(c, err)
+		}
+		if err := db.First(&order, "id = ?", order.Id).Error; err != nil {
+			return err
+		}
+		if err := orders.Load(db, &order); err != nil {
+			return err
+		}
+	}
+
+	result, _ := json.Marshal(&order)
+	return c.SendString(string(result))
+}
+
+func (oc *orderController) GetInvoice(c *fiber.Ctx) error {
+	return oc.sendDocument(c, func(invoice models.Invoice) (string, []byte, error) {
+		data, err := documents.InvoicePDF(c.Context(), invoice)
+		return invoice.Number + ".pdf", data, err
+	})
+}
+
+func (oc *orderController) GetPackingSlip(c *fiber.Ctx) error {
+	return oc.sendDocument(c, func(invoice models.Invoice) (string, []byte, error) {
+		data, err := documents.PackingSlipPDF(c.Context(), invoice)
+		return "packing-slip-" + invoice.Number + ".pdf", data, err
+	})
+}
+
+// Send a PDF of the invoice of the order, issuing it first when the order
+// was paid without one
+func (oc *orderController) sendDocument(c *fiber.Ctx, document func(invoice models.Invoice) (string, []byte, error)) error {
+	order, err := oc.findOrder(c, c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find order",
+		})
+	}
+
+	var invoice models.Invoice
+	err = oc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var err error
+		invoice, err = documents.Issue(c.Context(), tx, order)
+		return err
+	})
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	name, data, err := document(invoice)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+name+`"`)
+	return c.Send(data)
+}
+
+// Orders are only visible to their account and to admins
+func (oc *orderController) findOrder(c *fiber.Ctx, id string) (models.Order, error) {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+
+	query := oc.db.UseGorm().Where("id = ?", id)
+	if !role.IsAdmin {
+		query = query.Where("account = ?", account.Id)
+	}
+
+	var order models.Order
+	err := query.First(&order).Error
+	return order, err
+}
+
+func orderError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, orders.ErrEmptyCart),
+		errors.Is(err, shipping.ErrInvalidAddress),
+		errors.Is(err, shipping.ErrNoZone),
+		errors.Is(err, shipping.ErrUnknownMethod),
+		errors.Is(err, shipping.ErrTooHeavy),
+		errors.Is(err, shipping.ErrNoRate):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, orders.ErrUnavailable),
+		errors.Is(err, orders.ErrPriceChanged),
+		errors.Is(err, orders.ErrCartChanged),
+		errors.Is(err, orders.ErrInvalidTransition),
+		errors.Is(err, orders.ErrStaleOrder),
+		errors.Is(err, inventory.ErrInsufficientStock),
+		errors.Is(err, documents.ErrNotInvoiced),
+		errors.Is(err, payments.ErrNotPayable),
+		errors.Is(err, payments.ErrNotRefundable),
+		errors.Is(err, payments.ErrUnknownProvider):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, payments.ErrRefundFailed),
+		errors.Is(err, payments.ErrUnknownPayment):
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, coupons.ErrNotFound),
+		errors.Is(err, coupons.ErrInactive),
+		errors.Is(err, coupons.ErrExpired),
+		errors.Is(err, coupons.ErrUsageLimit),
+		errors.Is(err, coupons.ErrAccountLimit),
+		errors.Is(err, coupons.ErrRoleNotEligible),
+		errors.Is(err, coupons.ErrMinSpend),
+		errors.Is(err, coupons.ErrNoEligibleItems):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return err
+}
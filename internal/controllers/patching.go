@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/patch"
+	"gorm.io/gorm"
+)
+
+// How an entity can be patched
+type patchOptions[T any] struct {
+	// json names of the fields a patch may touch
+	allowed []string
+
+	// Checks the patched record before it is stored
+	validate func(db *gorm.DB, record *T) error
+
+	// Runs inside the update transaction
+	after func(tx *gorm.DB, record *T) error
+
+	// Runs once the update is committed
+	done func(record *T)
+
+	// Clears fields that must never be sent back
+	hide func(record *T)
+}
+
+// Apply a merge patch or JSON patch from the request to the record with the id
+// in the query. The update is rejected if the record changed since it was read,
+// or since the version given in If-Match.
+func patchRecord[T any](c *fiber.Ctx, db *gorm.DB, options patchOptions[T]) error {
+	id, err := uuid.Parse(c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	var current T
+	if err := db.First(&current, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find record",
+		})
+	}
+
+	version := recordVersion(&current)
+	if expected, ok := expectedVersion(c, 0); ok && expected != version {
+		return versionConflict(c, db, id, options.hide)
+	}
+
+	doc, err := json.Marshal(&current)
+	if err != nil {
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	var patched []byte
+	switch mediaType {
+	case patch.MergePatchType, fiber.MIMEApplicationJSON:
+		patched, err = patch.Merge(doc, c.Body(), options.allowed)
+	case patch.JSONPatchType:
+		patched, err = patch.Apply(doc, c.Body(), options.allowed)
+	default:
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Expected " + patch.MergePatchType + " or " + patch.JSONPatchType,
+		})
+	}
+
+	var forbidden *patch.ForbiddenFieldError
+	switch {
+	case errors.As(err, &forbidden):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, patch.ErrTestFailed):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var target T
+	if err := json.Unmarshal(patched, &target); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if options.validate != nil {
+		if err := options.validate(db, &target); err != nil {
+			status := fiber.StatusBadRequest
+			if errors.Is(err, errInUse) {
+				status = fiber.StatusConflict
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	setRecordVersion(&target, version+1)
+	columns := append(fieldNames(reflect.TypeOf(target), options.allowed), "Version")
+	if _, ok := reflect.TypeOf(target).FieldByName("UpdatedAt"); ok {
+		columns = append(columns, "UpdatedAt")
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&target).Where("version = ?", version).Select(columns).Updates(&target)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errStaleVersion
+		}
+
+		if err := tx.First(&target, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if options.after != nil {
+			return options.after(tx, &target)
+		}
+		return nil
+	})
+
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict(c, db, id, options.hide)
+	}
+	if err != nil {
+		return err
+	}
+
+	if options.done != nil {
+		options.done(&target)
+	}
+	if options.hide != nil {
+		options.hide(&target)
+	}
+
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
+}
+
+// Go field names of the given json fields, gorm selects accept either
+func fieldNames(t reflect.Type, jsonFields []string) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if slices.Contains(jsonFields, name) {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+func recordVersion(record any) int {
+	return int(reflect.ValueOf(record).Elem().FieldByName("Version").Int())
+}
+
+func recordId(record any) uuid.UUID {
+	return reflect.ValueOf(record).Elem().FieldByName("Id").Interface().(uuid.UUID)
+}
+
+func setRecordVersion(record any, version int) {
+	reflect.ValueOf(record).Elem().FieldByName("Version").SetInt(int64(version))
+}
+
+func setRecordUpdatedBy(record any, by uuid.UUID) {
+	reflect.ValueOf(record).Elem().FieldByName("UpdateBy").Set(reflect.ValueOf(by))
+}
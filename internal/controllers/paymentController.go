@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/payments"
+	"github.com/redis/go-redis/v9"
+)
+
+const signatureHeader = "X-Signature"
+
+type PaymentController interface {
+
+	// Handle a webhook of a payment provider
+	Webhook(c *fiber.Ctx) error
+
+	// Complete the challenge of a fake gateway payment, for local development
+	FakeChallenge(c *fiber.Ctx) error
+}
+
+var paymentInstance *paymentController
+
+type paymentController struct {
+	db    database.Service
+	redis *redis.Client
+	fake  *payments.FakeProvider
+}
+
+func NewPaymentController(db database.Service, redis *redis.Client, fake *payments.FakeProvider) *paymentController {
+
+	if paymentInstance != nil {
+		return paymentInstance
+	}
+
+	paymentInstance = &paymentController{
+		db:    db,
+		redis: redis,
+		fake:  fake,
+	}
+
+	return paymentInstance
+}
+
+func (pc *paymentController) Webhook(c *fiber.Ctx) error {
+	provider, err := payments.Lookup(c.Params("provider"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return pc.handle(c, provider, c.Body(), c.Get(signatureHeader))
+}
+
+func (pc *paymentController) FakeChallenge(c *fiber.Ctx) error {
+	payload, signature, err := pc.fake.Challenge(c.Query("reference"), c.Query("result") != "fail")
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The gateway would deliver this webhook on its own
+	return pc.handle(c, pc.fake, payload, signature)
+}
+
+func (pc *paymentController) handle(c *fiber.Ctx, provider payments.PaymentProvider, payload []byte, signature string) error {
+	err := payments.HandleWebhook(c.Context(), pc.db.UseGorm(), provider, payload, signature)
+	switch {
+	case errors.Is(err, payments.ErrInvalidSignature):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, payments.ErrInvalidPayload):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, payments.ErrUnknownPayment):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -1,13 +1,35 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/catalog"
+	"github.com/kevinhartarto/market-be/internal/currency"
 	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
 	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"github.com/kevinhartarto/market-be/internal/promotions"
+	"github.com/kevinhartarto/market-be/internal/recommendations"
+	"github.com/kevinhartarto/market-be/internal/reviews"
+	"github.com/kevinhartarto/market-be/internal/revisions"
+	"github.com/kevinhartarto/market-be/internal/tax"
+	"github.com/kevinhartarto/market-be/internal/workflow"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 )
 
 type ProductController interface {
@@ -34,23 +56,44 @@ type ProductController interface {
 	GetProductDetails(c *fiber.Ctx) error
 
 	UpdateProduct(c *fiber.Ctx) error
+
+	// Every recorded price of a product, newest first
+	GetPriceHistory(c *fiber.Ctx) error
+
+	// Related, bought together and also viewed products of a product
+	GetRecommendations(c *fiber.Ctx) error
+
+	// Partial updates with a JSON merge patch or JSON patch
+	PatchBrand(c *fiber.Ctx) error
+
+	PatchCategory(c *fiber.Ctx) error
+
+	PatchProduct(c *fiber.Ctx) error
 }
 
 var (
 	productInstance *productController
 
-	brands     []models.Brand
-	categories []models.Category
-	products   []models.Product
+	catalogCacheTTL = 5 * time.Minute
+
+	// Associations only change nightly, related products with the catalog
+	recommendationCacheTTL = time.Hour
+	recommendationLimit    = 10
 
-	brand    models.Brand
-	category models.Category
-	product  models.Product
+	// Fields a patch may change, ids, owners and timestamps are never writable.
+	// Product content goes through drafts, only the live state is patched.
+	brandPatchFields    = []string{"name", "logo", "on_sale", "active"}
+	categoryPatchFields = []string{"name", "description", "featured", "active"}
+	productPatchFields  = []string{"on_sale", "sale_price", "sale_percent", "stock", "active"}
 )
 
 type productController struct {
-	db    database.Service
-	redis *redis.Client
+	db        database.Service
+	redis     *redis.Client
+	converter *currency.Converter
+	cache     *cache.Cache
+	views     *recommendations.ViewRecorder
+	guests    *carts.GuestStore
 }
 
 type BrandToUpdate struct {
@@ -65,20 +108,35 @@ func NewProductController(db database.Service, redis *redis.Client) *productCont
 	}
 
 	productInstance = &productController{
-		db:    db,
-		redis: redis,
+		db:        db,
+		redis:     redis,
+		converter: currency.NewConverter(),
+		cache:     cache.NewCache(redis),
+		views:     recommendations.NewViewRecorder(db),
+		guests:    guestCarts(redis),
 	}
 
 	return productInstance
 }
 
 func (pc *productController) GetAllBrands(c *fiber.Ctx) error {
-	pc.db.UseGorm().Where("active").Find(&brands)
-	result, _ := json.Marshal(brands)
+	result, err := pc.cache.Fetch(c.Context(), "brands:all", catalogCacheTTL, []string{cache.TagBrands}, func() ([]byte, error) {
+		var items []models.Brand
+		if err := pc.db.UseGorm().Where("active").Find(&items).Error; err != nil {
+			return nil, err
+		}
+		return json.Marshal(items)
+	})
+	if err != nil {
+		return err
+	}
+
+	pc.setCatalogModified(c, latestUpdate(pc.db.UseGorm().Model(&models.Brand{})), cache.TagBrands)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) GetBrandDetails(c *fiber.Ctx) error {
+	var brand models.Brand
 	if err := c.BodyParser(&brand); err != nil {
 		return err
 	}
@@ -87,52 +145,89 @@ func (pc *productController) GetBrandDetails(c *fiber.Ctx) error {
 		return err
 	}
 
+	c.Locals(middlewares.VersionKey, brand.Version)
+	pc.setCatalogModified(c, brand.UpdatedAt, cache.TagBrands)
 	result, _ := json.Marshal(&brand)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) UpdateBrand(c *fiber.Ctx) error {
 	var updateBrand struct {
-		brand       models.Brand
-		updateType  string
-		updateValue bool
+		Brand       models.Brand `json:"brand"`
+		UpdateType  string       `json:"update_type"`
+		UpdateValue bool         `json:"update_value"`
 	}
-	success := false
 
 	if err := c.BodyParser(&updateBrand); err != nil {
 		return err
 	}
 
-	switch updateBrand.updateType {
-	case "update":
-		affectedRows = pc.db.UseGorm().Save(&updateBrand.brand).RowsAffected
-	case "sale":
-		affectedRows = pc.db.UseGorm().Model(&updateBrand.brand).Update("on_sale", updateBrand.updateValue).RowsAffected
-	case "active":
-		affectedRows = pc.db.UseGorm().Model(&updateBrand.brand).Update("active", updateBrand.updateValue).RowsAffected
+	target := updateBrand.Brand
+	version, checked := expectedVersion(c, target.Version)
+	if updateBrand.UpdateType == "update" && !checked {
+		return missingVersion(c)
 	}
 
-	// This is not a batch updates
-	// Expect only 1 row changed
-	if affectedRows == 1 {
-		success = true
+	err := pc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		switch updateBrand.UpdateType {
+		case "update":
+			target.Version = version + 1
+			result = updateVersioned(tx, &target, version, editorId(c))
+		case "sale":
+			result = updateColumnVersioned(tx, &target, "on_sale", updateBrand.UpdateValue, version, checked)
+		case "active":
+			result = updateColumnVersioned(tx, &target, "active", updateBrand.UpdateValue, version, checked)
+		default:
+			return errInvalidUpdate
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// This is not a batch updates
+		// Expect only 1 row changed
+		affectedRows = result.RowsAffected
+		if affectedRows != 1 {
+			return errStaleVersion
+		}
+
+		if err := tx.First(&target, "id = ?", target.Id).Error; err != nil {
+			return err
+		}
+		return revisions.Record(tx, &target, revisions.SourceUpdate)
+	})
+
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict[models.Brand](c, pc.db.UseGorm(), target.Id, nil)
 	}
-	result, _ := json.Marshal(&updateBrand.brand)
-
-	if success {
-		return c.SendString(string(result))
-	} else {
+	if err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+
+	pc.cache.Drop(c.Context(), cache.TagBrands)
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
 }
 
 func (pc *productController) GetAllCategories(c *fiber.Ctx) error {
-	pc.db.UseGorm().Where("active").Find(&categories)
-	result, _ := json.Marshal(categories)
+	result, err := pc.cache.Fetch(c.Context(), "categories:all", catalogCacheTTL, []string{cache.TagCategories}, func() ([]byte, error) {
+		var items []models.Category
+		if err := pc.db.UseGorm().Where("active").Find(&items).Error; err != nil {
+			return nil, err
+		}
+		return json.Marshal(items)
+	})
+	if err != nil {
+		return err
+	}
+
+	pc.setCatalogModified(c, latestUpdate(pc.db.UseGorm().Model(&models.Category{})), cache.TagCategories)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) GetCategoryDetails(c *fiber.Ctx) error {
+	var category models.Category
 	if err := c.BodyParser(&category); err != nil {
 		return err
 	}
@@ -141,107 +236,524 @@ func (pc *productController) GetCategoryDetails(c *fiber.Ctx) error {
 		return err
 	}
 
+	c.Locals(middlewares.VersionKey, category.Version)
+	pc.setCatalogModified(c, category.UpdatedAt, cache.TagCategories)
 	result, _ := json.Marshal(&category)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) UpdateCategory(c *fiber.Ctx) error {
 	var updateCategory struct {
-		category    models.Category
-		updateType  string
-		updateValue bool
+		Category    models.Category `json:"category"`
+		UpdateType  string          `json:"update_type"`
+		UpdateValue bool            `json:"update_value"`
 	}
-	success := false
 
 	if err := c.BodyParser(&updateCategory); err != nil {
 		return err
 	}
 
-	switch updateCategory.updateType {
-	case "update":
-		affectedRows = pc.db.UseGorm().Save(&updateCategory.category).RowsAffected
-	case "featured":
-		affectedRows = pc.db.UseGorm().Model(&updateCategory.category).Update("featured", updateCategory.updateValue).RowsAffected
-	case "active":
-		affectedRows = pc.db.UseGorm().Model(&updateCategory.category).Update("active", updateCategory.updateValue).RowsAffected
+	target := updateCategory.Category
+	version, checked := expectedVersion(c, target.Version)
+	if updateCategory.UpdateType == "update" && !checked {
+		return missingVersion(c)
 	}
 
-	// This is not a batch updates
-	// Expect only 1 row changed
-	if affectedRows == 1 {
-		success = true
+	err := pc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		switch updateCategory.UpdateType {
+		case "update":
+			target.Version = version + 1
+			result = updateVersioned(tx, &target, version, editorId(c))
+		case "featured":
+			result = updateColumnVersioned(tx, &target, "featured", updateCategory.UpdateValue, version, checked)
+		case "active":
+			result = updateColumnVersioned(tx, &target, "active", updateCategory.UpdateValue, version, checked)
+		default:
+			return errInvalidUpdate
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// This is not a batch updates
+		// Expect only 1 row changed
+		affectedRows = result.RowsAffected
+		if affectedRows != 1 {
+			return errStaleVersion
+		}
+
+		if err := tx.First(&target, "id = ?", target.Id).Error; err != nil {
+			return err
+		}
+		return revisions.Record(tx, &target, revisions.SourceUpdate)
+	})
+
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict[models.Category](c, pc.db.UseGorm(), target.Id, nil)
 	}
-	result, _ := json.Marshal(&updateCategory.category)
-
-	if success {
-		return c.SendString(string(result))
-	} else {
+	if err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+
+	pc.cache.Drop(c.Context(), cache.TagCategories)
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
 }
 
 func (pc *productController) GetAllProducts(c *fiber.Ctx) error {
-	pc.db.UseGorm().Where("active").Find(&products)
-	result, _ := json.Marshal(products)
+	code := requestCurrency(c)
+	if code != "" && !pc.converter.Supports(code) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": currency.ErrUnknownCurrency.Error(),
+		})
+	}
+
+	// Prices differ per currency, so does the cached response
+	key := "products:all:" + code
+	result, err := pc.cache.Fetch(c.Context(), key, catalogCacheTTL, []string{cache.TagProducts}, func() ([]byte, error) {
+		var items []models.Product
+		if err := pc.db.UseGorm().Where("active and status = ?", workflow.StatusPublished).Find(&items).Error; err != nil {
+			return nil, err
+		}
+		if err := pc.applyPricing(items, code); err != nil {
+			return nil, err
+		}
+		pc.applyRatings(items)
+		return json.Marshal(items)
+	})
+	if err != nil {
+		return err
+	}
+
+	pc.setCatalogModified(c, latestUpdate(pc.db.UseGorm().Model(&models.Product{})), cache.TagProducts)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) GetProductsByBrand(c *fiber.Ctx) error {
 	brand := c.Query("brand")
-	pc.db.UseGorm().Where("brand = ?", brand).Find(&products)
+	var products []models.Product
+	pc.db.UseGorm().Where("brand = ? and status = ?", brand, workflow.StatusPublished).Find(&products)
+	if err := pc.applyPricing(products, requestCurrency(c)); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	pc.applyRatings(products)
+	pc.setCatalogModified(c, latestProductUpdate(products), cache.TagProducts)
 	result, _ := json.Marshal(products)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) GetProductsByCategory(c *fiber.Ctx) error {
 	category := c.Query("category")
-	pc.db.UseGorm().Where("category = ?", category).Find(&products)
+	var products []models.Product
+	pc.db.UseGorm().Where("category = ? and status = ?", category, workflow.StatusPublished).Find(&products)
+	if err := pc.applyPricing(products, requestCurrency(c)); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	pc.applyRatings(products)
+	pc.setCatalogModified(c, latestProductUpdate(products), cache.TagProducts)
 	result, _ := json.Marshal(products)
 	return c.SendString(string(result))
 }
 
 func (pc *productController) GetProductDetails(c *fiber.Ctx) error {
+	var product models.Product
 	if err := c.BodyParser(&product); err != nil {
 		return err
 	}
 
-	if err := pc.db.UseGorm().First(&product).Error; err != nil {
+	// Drafts and archived products are never served
+	if err := pc.db.UseGorm().Where("status = ?", workflow.StatusPublished).First(&product).Error; err != nil {
 		return err
 	}
 
-	result, _ := json.Marshal(&product)
+	detail := []models.Product{product}
+	if err := pc.applyPricing(detail, requestCurrency(c)); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	pc.applyRatings(detail)
+
+	pc.views.Record(detail[0].Id, pc.viewerKey(c))
+
+	c.Locals(middlewares.VersionKey, detail[0].Version)
+	pc.setCatalogModified(c, detail[0].UpdatedAt, cache.TagProducts)
+	result, _ := json.Marshal(&detail[0])
 	return c.SendString(string(result))
 }
 
 func (pc *productController) UpdateProduct(c *fiber.Ctx) error {
 	var updateProduct struct {
-		product     models.Product
-		updateType  string
-		updateValue bool
+		Product     models.Product `json:"product"`
+		UpdateType  string         `json:"update_type"`
+		UpdateValue bool           `json:"update_value"`
 	}
-	success := false
 
 	if err := c.BodyParser(&updateProduct); err != nil {
 		return err
 	}
 
-	switch updateProduct.updateType {
-	case "update":
-		affectedRows = pc.db.UseGorm().Save(&updateProduct.product).RowsAffected
-	case "active":
-		affectedRows = pc.db.UseGorm().Model(&updateProduct.product).Update("active", updateProduct.updateValue).RowsAffected
+	// Content changes are drafted and reviewed before they go live
+	if updateProduct.UpdateType == "update" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Product content is changed through drafts",
+		})
 	}
 
-	// This is not a batch updates
-	// Expect only 1 row changed
-	if affectedRows == 1 {
-		success = true
+	target := updateProduct.Product
+	version, checked := expectedVersion(c, target.Version)
+
+	err := pc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		switch updateProduct.UpdateType {
+		case "active":
+			result = updateColumnVersioned(tx, &target, "active", updateProduct.UpdateValue, version, checked)
+		default:
+			return errInvalidUpdate
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// This is not a batch updates
+		// Expect only 1 row changed
+		affectedRows = result.RowsAffected
+		if affectedRows != 1 {
+			return errStaleVersion
+		}
+
+		if err := tx.First(&target, "id = ?", target.Id).Error; err != nil {
+			return err
+		}
+		if err := revisions.Record(tx, &target, revisions.SourceUpdate); err != nil {
+			return err
+		}
+		return pricing.Record(tx, target, pricing.SourceUpdate)
+	})
+
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict[models.Product](c, pc.db.UseGorm(), target.Id, nil)
 	}
-	result, _ := json.Marshal(&updateProduct.product)
-
-	if success {
-		return c.SendString(string(result))
-	} else {
+	if err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+
+	pc.cache.Drop(c.Context(), cache.TagProducts)
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
+}
+
+// Fill the effective price of each product from the active promotions,
+// the previous lowest price of products on sale, then convert every
+// amount into the requested currency.
+func (pc *productController) applyPricing(items []models.Product, code string) error {
+	if code != "" && !pc.converter.Supports(code) {
+		return currency.ErrUnknownCurrency
+	}
+
+	var active []models.Promotion
+	pc.db.UseGorm().Where("active").Find(&active)
+
+	for i := range items {
+		price := promotions.EffectivePrice(items[i], active)
+		items[i].EffectivePrice = price.Price
+		items[i].Promotions = price.Promotions
+	}
+
+	if err := pricing.ApplyLowestPrices(pc.db.UseGorm(), items); err != nil {
+		log.Printf("Unable to load price history: %v", err)
+	}
+
+	if code == "" {
+		return nil
+	}
+	return localizeProducts(pc.db.UseGorm(), pc.converter, items, code)
+}
+
+func (pc *productController) PatchBrand(c *fiber.Ctx) error {
+	return patchRecord(c, pc.db.UseGorm(), patchOptions[models.Brand]{
+		allowed: brandPatchFields,
+		validate: func(db *gorm.DB, record *models.Brand) error {
+			return validateCatalogRecord(db, record)
+		},
+		after: func(tx *gorm.DB, record *models.Brand) error {
+			return revisions.Record(tx, record, revisions.SourcePatch)
+		},
+		done: func(record *models.Brand) {
+			pc.cache.Drop(c.Context(), cache.TagBrands)
+		},
+	})
+}
+
+func (pc *productController) PatchCategory(c *fiber.Ctx) error {
+	return patchRecord(c, pc.db.UseGorm(), patchOptions[models.Category]{
+		allowed: categoryPatchFields,
+		validate: func(db *gorm.DB, record *models.Category) error {
+			return validateCatalogRecord(db, record)
+		},
+		after: func(tx *gorm.DB, record *models.Category) error {
+			return revisions.Record(tx, record, revisions.SourcePatch)
+		},
+		done: func(record *models.Category) {
+			pc.cache.Drop(c.Context(), cache.TagCategories)
+		},
+	})
+}
+
+func (pc *productController) PatchProduct(c *fiber.Ctx) error {
+	return patchRecord(c, pc.db.UseGorm(), patchOptions[models.Product]{
+		allowed: productPatchFields,
+		validate: func(db *gorm.DB, record *models.Product) error {
+			return validateCatalogRecord(db, record)
+		},
+		after: func(tx *gorm.DB, record *models.Product) error {
+			if err := revisions.Record(tx, record, revisions.SourcePatch); err != nil {
+				return err
+			}
+			return pricing.Record(tx, *record, pricing.SourceUpdate)
+		},
+		done: func(record *models.Product) {
+			pc.cache.Drop(c.Context(), cache.TagProducts)
+		},
+	})
+}
+
+// Fill the rating summary of each product, reads still work without it
+func (pc *productController) applyRatings(items []models.Product) {
+	if err := reviews.ApplyRatings(pc.db.UseGorm(), items); err != nil {
+		log.Printf("Unable to load ratings: %v", err)
+	}
+}
+
+func (pc *productController) GetRecommendations(c *fiber.Ctx) error {
+	productId, err := uuid.Parse(c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	limit := c.QueryInt("limit", recommendationLimit)
+	if limit <= 0 || limit > 50 {
+		limit = recommendationLimit
+	}
+
+	code := requestCurrency(c)
+	if code != "" && !pc.converter.Supports(code) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": currency.ErrUnknownCurrency.Error(),
+		})
+	}
+
+	var target models.Product
+	if err := pc.db.UseGorm().First(&target, "id = ? and status = ?", productId, workflow.StatusPublished).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find product",
+		})
+	}
+
+	key := fmt.Sprintf("recommendations:%s:%d:%s", productId, limit, code)
+	tags := []string{cache.TagProducts, cache.TagRecommendations}
+	result, err := pc.cache.Fetch(c.Context(), key, recommendationCacheTTL, tags, func() ([]byte, error) {
+		db := pc.db.UseGorm()
+		related, err := recommendations.Related(db, target, limit)
+		if err != nil {
+			return nil, err
+		}
+		boughtTogether, err := recommendations.Associated(db, productId, recommendations.KindBoughtTogether, limit)
+		if err != nil {
+			return nil, err
+		}
+		alsoViewed, err := recommendations.Associated(db, productId, recommendations.KindAlsoViewed, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, items := range [][]models.Product{related, boughtTogether, alsoViewed} {
+			if err := pc.applyPricing(items, code); err != nil {
+				return nil, err
+			}
+			pc.applyRatings(items)
+		}
+
+		return json.Marshal(fiber.Map{
+			"related":         related,
+			"bought_together": boughtTogether,
+			"also_viewed":     alsoViewed,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.SendString(string(result))
+}
+
+// Who is looking at the catalog. Shoppers with a cart are known by its signed
+// token, the others by their address and browser, never by a client chosen id.
+func (pc *productController) viewerKey(c *fiber.Ctx) string {
+	if id, err := pc.guests.Parse(guestToken(c)); err == nil {
+		return "guest:" + id.String()
+	}
+
+	sum := sha256.Sum256([]byte(c.IP() + "|" + c.Get(fiber.HeaderUserAgent)))
+	return "client:" + hex.EncodeToString(sum[:16])
+}
+
+// Run the import validation rules on a single catalog record
+func validateCatalogRecord(db *gorm.DB, record any) error {
+	var brandRefs []models.Brand
+	var categoryRefs []models.Category
+	var taxClasses []string
+	if _, ok := record.(*models.Product); ok {
+		db.Select("id").Find(&brandRefs)
+		db.Select("id").Find(&categoryRefs)
+
+		var err error
+		if taxClasses, err = tax.Classes(db); err != nil {
+			return err
+		}
+	}
+
+	row := catalog.Row{Record: record}
+	catalog.NewReferences(brandRefs, categoryRefs, taxClasses).Validate(&row)
+	if len(row.Errors) > 0 {
+		return errors.New(row.Errors[0].Message)
+	}
+	return nil
+}
+
+func (pc *productController) GetPriceHistory(c *fiber.Ctx) error {
+	productId, err := uuid.Parse(c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	history, err := pricing.History(pc.db.UseGorm(), productId)
+	if err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(history)
+	return c.SendString(string(result))
+}
+
+// Currency asked by the client, the query parameter wins over the header
+func requestCurrency(c *fiber.Ctx) string {
+	if code := c.Query("currency"); code != "" {
+		return currency.Normalise(code)
+	}
+	return currency.Normalise(c.Get("Accept-Currency"))
+}
+
+// Convert product amounts into the currency.
+// Prices from the product price list take precedence over exchange rates,
+// the other amounts then follow the same ratio as the listed price.
+func localizeProducts(db *gorm.DB, converter *currency.Converter, items []models.Product, code string) error {
+	ids := make([]uuid.UUID, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+
+	var listed []models.ProductPrice
+	if len(ids) > 0 {
+		if err := db.Where("product in ? and currency = ?", ids, code).Find(&listed).Error; err != nil {
+			return err
+		}
+	}
+	priceList := make(map[uuid.UUID]models.ProductPrice, len(listed))
+	for _, price := range listed {
+		priceList[price.Product] = price
+	}
+
+	for i := range items {
+		item := &items[i]
+		from := item.Currency
+		if from == "" {
+			from = converter.Base()
+		}
+		if from == code {
+			continue
+		}
+
+		convert := func(amount int) (int, error) {
+			return converter.Convert(amount, from, code)
+		}
+		if listPrice, ok := priceList[item.Id]; ok && item.Price > 0 {
+			basePrice := item.Price
+			convert = func(amount int) (int, error) {
+				return int(math.Round(float64(amount) * float64(listPrice.Price) / float64(basePrice))), nil
+			}
+		}
+
+		var err error
+		if item.Price, err = convert(item.Price); err != nil {
+			return err
+		}
+		if item.EffectivePrice, err = convert(item.EffectivePrice); err != nil {
+			return err
+		}
+		if item.SalePrice, err = convert(item.SalePrice); err != nil {
+			return err
+		}
+		if listPrice, ok := priceList[item.Id]; ok && listPrice.SalePrice > 0 {
+			item.SalePrice = listPrice.SalePrice
+		}
+		if item.LowestPrice30d != nil {
+			lowest, err := convert(*item.LowestPrice30d)
+			if err != nil {
+				return err
+			}
+			item.LowestPrice30d = &lowest
+		}
+		item.Currency = code
+	}
+
+	return nil
+}
+
+// Most recent update of the rows matched by the query
+func latestUpdate(query *gorm.DB) time.Time {
+	var latest sql.NullTime
+	query.Select("max(updated_at)").Scan(&latest)
+	return latest.Time
+}
+
+func latestProductUpdate(items []models.Product) time.Time {
+	var latest time.Time
+	for _, item := range items {
+		if item.UpdatedAt.After(latest) {
+			latest = item.UpdatedAt
+		}
+	}
+	return latest
+}
+
+// Row timestamps miss deactivated rows, ratings and promotions, which all
+// invalidate the cache tags, so the newest of both is sent. Without the
+// invalidation time only the ETag is left to validate the response.
+func (pc *productController) setCatalogModified(c *fiber.Ctx, latest time.Time, tags ...string) {
+	changed, err := pc.cache.Changed(c.Context(), tags...)
+	if err != nil {
+		log.Printf("Unable to read catalog change time: %v", err)
+		return
+	}
+	if changed.After(latest) {
+		latest = changed
+	}
+	setLastModified(c, latest)
+}
+
+// Used by the conditional GET middleware to answer If-Modified-Since
+func setLastModified(c *fiber.Ctx, t time.Time) {
+	if !t.IsZero() {
+		c.Set(fiber.HeaderLastModified, t.UTC().Format(http.TimeFormat))
+	}
 }
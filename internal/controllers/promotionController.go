@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/promotions"
+	"github.com/redis/go-redis/v9"
+)
+
+type PromotionController interface {
+
+	// Retrieve all promotions that are not deprecated
+	GetAllPromotions(c *fiber.Ctx) error
+
+	// Get promotion by Id
+	GetPromotion(c *fiber.Ctx) error
+
+	// Create a time-windowed sale rule.
+	// returns an error if the rule is invalid
+	CreatePromotion(c *fiber.Ctx) error
+
+	// Update a promotion
+	// returns an error if the promotion not found
+	UpdatePromotion(c *fiber.Ctx) error
+}
+
+var promotionInstance *promotionController
+
+type promotionController struct {
+	db        database.Service
+	redis     *redis.Client
+	scheduler *promotions.Scheduler
+}
+
+func NewPromotionController(db database.Service, redis *redis.Client, scheduler *promotions.Scheduler) *promotionController {
+
+	if promotionInstance != nil {
+		return promotionInstance
+	}
+
+	promotionInstance = &promotionController{
+		db:        db,
+		redis:     redis,
+		scheduler: scheduler,
+	}
+
+	return promotionInstance
+}
+
+func (pc *promotionController) GetAllPromotions(c *fiber.Ctx) error {
+	var promotionList []models.Promotion
+	pc.db.UseGorm().Where("deprecated is not true").Order("priority desc").Find(&promotionList)
+	result, _ := json.Marshal(promotionList)
+	return c.SendString(string(result))
+}
+
+func (pc *promotionController) GetPromotion(c *fiber.Ctx) error {
+	promotionId := c.Query("id")
+	if promotionId == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	var promotion models.Promotion
+	if err := pc.db.UseGorm().First(&promotion, "id = ?", promotionId).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find promotion",
+		})
+	}
+
+	result, _ := json.Marshal(&promotion)
+	return c.SendString(string(result))
+}
+
+func (pc *promotionController) CreatePromotion(c *fiber.Ctx) error {
+	var promotion models.Promotion
+	if err := c.BodyParser(&promotion); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := promotions.Validate(promotion); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The scheduler decides when the promotion goes live
+	promotion.Active = false
+
+	if err := pc.db.UseGorm().Create(&promotion).Error; err != nil {
+		return err
+	}
+
+	if err := pc.scheduler.Sync(); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&promotion)
+	return c.SendString(string(result))
+}
+
+func (pc *promotionController) UpdatePromotion(c *fiber.Ctx) error {
+	var updatePromotion struct {
+		Promotion   models.Promotion `json:"promotion"`
+		UpdateType  string           `json:"update_type"`
+		UpdateValue bool             `json:"update_value"`
+	}
+
+	if err := c.BodyParser(&updatePromotion); err != nil {
+		return err
+	}
+
+	promotion := updatePromotion.Promotion
+	switch updatePromotion.UpdateType {
+	case "update":
+		if err := promotions.Validate(promotion); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		affectedRows = pc.db.UseGorm().Omit("active", "created_at").Save(&promotion).RowsAffected
+	case "status":
+		affectedRows = pc.db.UseGorm().Model(&promotion).Update("deprecated", updatePromotion.UpdateValue).RowsAffected
+	default:
+		affectedRows = 0
+	}
+
+	// This is not a batch updates
+	// Expect only 1 row changed
+	if affectedRows != 1 {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := pc.scheduler.Sync(); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&promotion)
+	return c.SendString(string(result))
+}
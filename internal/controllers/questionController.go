@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/questions"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type QuestionController interface {
+
+	// Retrieve the questions of a product with their answers
+	GetQuestions(c *fiber.Ctx) error
+
+	// Ask a question about a product, the owner is notified.
+	// returns an error if the account is not verified
+	AskQuestion(c *fiber.Ctx) error
+
+	// Answer a question as the product owner or an admin
+	AnswerQuestion(c *fiber.Ctx) error
+
+	// Upvote an answer, once per account
+	UpvoteAnswer(c *fiber.Ctx) error
+}
+
+var questionInstance *questionController
+
+type questionController struct {
+	db    database.Service
+	redis *redis.Client
+}
+
+func NewQuestionController(db database.Service, redis *redis.Client) *questionController {
+
+	if questionInstance != nil {
+		return questionInstance
+	}
+
+	questionInstance = &questionController{
+		db:    db,
+		redis: redis,
+	}
+
+	return questionInstance
+}
+
+func (qc *questionController) GetQuestions(c *fiber.Ctx) error {
+	productId, err := uuid.Parse(c.Query("product"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	list, err := questions.ForProduct(qc.db.UseGorm(), productId)
+	if err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(list)
+	return c.SendString(string(result))
+}
+
+func (qc *questionController) AskQuestion(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	if !account.Verified {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": questions.ErrNotVerified.Error(),
+		})
+	}
+
+	var question models.Question
+	if err := c.BodyParser(&question); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	body, err := questions.ValidateBody(question.Body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var product models.Product
+	if err := qc.db.UseGorm().First(&product, "id = ? and status = ?", question.Product, workflow.StatusPublished).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find product",
+		})
+	}
+
+	question.Body = body
+	question.Account = account.Id
+	if err := qc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		return questions.Ask(tx, product, &question)
+	}); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&question)
+	return c.SendString(string(result))
+}
+
+func (qc *questionController) AnswerQuestion(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+
+	var answer models.Answer
+	if err := c.BodyParser(&answer); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	body, err := questions.ValidateBody(answer.Body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var question models.Question
+	if err := qc.db.UseGorm().First(&question, "id = ?", answer.Question).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find question",
+		})
+	}
+
+	var product models.Product
+	if err := qc.db.UseGorm().First(&product, "id = ?", question.Product).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find product",
+		})
+	}
+	var brand models.Brand
+	qc.db.UseGorm().Where("id = ?", product.Brand).Find(&brand)
+
+	if !questions.CanAnswer(product, brand, account, role) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": questions.ErrNotAnswerer.Error(),
+		})
+	}
+
+	answer.Body = body
+	answer.Account = account.Id
+	if err := qc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		return questions.Reply(tx, question, &answer)
+	}); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&answer)
+	return c.SendString(string(result))
+}
+
+func (qc *questionController) UpvoteAnswer(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request struct {
+		Answer uuid.UUID `json:"answer"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var answer models.Answer
+	if err := qc.db.UseGorm().First(&answer, "id = ?", request.Answer).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find answer",
+		})
+	}
+
+	err := qc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		if err := questions.Upvote(tx, answer, account.Id); err != nil {
+			return err
+		}
+		return tx.First(&answer, "id = ?", answer.Id).Error
+	})
+	switch {
+	case errors.Is(err, questions.ErrAlreadyVoted):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, questions.ErrOwnAnswer):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return err
+	}
+
+	result, _ := json.Marshal(&answer)
+	return c.SendString(string(result))
+}
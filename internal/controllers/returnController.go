@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/payments"
+	"github.com/kevinhartarto/market-be/internal/returns"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReturnController interface {
+
+	// Request a return of lines of an order of the logged in account
+	RequestReturn(c *fiber.Ctx) error
+
+	// Returns of the logged in account, every return for admins
+	GetReturns(c *fiber.Ctx) error
+
+	// Shipping label of an approved return
+	GetReturnLabel(c *fiber.Ctx) error
+
+	// Approve a return and issue its shipping label
+	ApproveReturn(c *fiber.Ctx) error
+
+	// Reject a return
+	RejectReturn(c *fiber.Ctx) error
+
+	// Restock the items of a return once they arrive and refund them
+	ReceiveReturn(c *fiber.Ctx) error
+}
+
+var returnInstance *returnController
+
+type returnController struct {
+	db    database.Service
+	redis *redis.Client
+}
+
+type returnDecision struct {
+	Id     uuid.UUID `json:"id"`
+	Notes  string    `json:"notes"`
+	Amount int       `json:"amount"`
+}
+
+func NewReturnController(db database.Service, redis *redis.Client) *returnController {
+
+	if returnInstance != nil {
+		return returnInstance
+	}
+
+	returnInstance = &returnController{
+		db:    db,
+		redis: redis,
+	}
+
+	return returnInstance
+}
+
+func (rc *returnController) RequestReturn(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request models.ReturnRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var order models.Order
+	if err := rc.db.UseGorm().First(&order, "id = ? and account = ?", request.Order, account.Id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find order",
+		})
+	}
+
+	err := rc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		return returns.Request(tx, order, &request)
+	})
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	result, _ := json.Marshal(&request)
+	return c.Status(fiber.StatusCreated).SendString(string(result))
+}
+
+func (rc *returnController) GetReturns(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+
+	query := rc.db.UseGorm().Order("created_at desc")
+	if !role.IsAdmin {
+		query = query.Where("account = ?", account.Id)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var list []models.ReturnRequest
+	if err := query.Find(&list).Error; err != nil {
+		return err
+	}
+	if err := returns.LoadLines(rc.db.UseGorm(), list); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(list)
+	return c.SendString(string(result))
+}
+
+func (rc *returnController) GetReturnLabel(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+
+	query := rc.db.UseGorm().Where("id = ? and status = ?", c.Query("id"), returns.StatusApproved)
+	if !role.IsAdmin {
+		query = query.Where("account = ?", account.Id)
+	}
+
+	var request models.ReturnRequest
+	if err := query.First(&request).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find return",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"return":   request.Id,
+		"order":    request.Order,
+		"carrier":  request.Carrier,
+		"tracking": request.Tracking,
+		"ship_to":  os.Getenv("RETURN_ADDRESS"),
+	})
+}
+
+func (rc *returnController) ApproveReturn(c *fiber.Ctx) error {
+	return rc.decide(c, func(tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, decision returnDecision) error {
+		return returns.Approve(c.Context(), tx, request, by, decision.Notes)
+	}, nil)
+}
+
+func (rc *returnController) RejectReturn(c *fiber.Ctx) error {
+	return rc.decide(c, func(tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, decision returnDecision) error {
+		return returns.Reject(tx, request, by, decision.Notes)
+	}, nil)
+}
+
+func (rc *returnController) ReceiveReturn(c *fiber.Ctx) error {
+	return rc.decide(c, func(tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, decision returnDecision) error {
+		return returns.Receive(tx, request, by, decision.Amount)
+	}, func(request *models.ReturnRequest) error {
+		// A refund the provider did not answer is retried by the reconciler,
+		// one it refused is reported
+		err := payments.CompleteRefunds(c.Context(), rc.db.UseGorm(), request.Order)
+		if err != nil && !errors.Is(err, payments.ErrRefundFailed) {
+			log.Printf("Refund of return %s is pending: %v", request.Id, err)
+			return nil
+		}
+		return err
+	})
+}
+
+// Load the return of the request body and apply an admin decision to it,
+// done runs once the decision is committed
+func (rc *returnController) decide(c *fiber.Ctx, apply func(tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, decision returnDecision) error, done func(request *models.ReturnRequest) error) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var decision returnDecision
+	if err := c.BodyParser(&decision); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var request models.ReturnRequest
+	err := rc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		// Locked so two decisions on the same return cannot both pass
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, "id = ?", decision.Id).Error; err != nil {
+			return err
+		}
+
+		list := []models.ReturnRequest{request}
+		if err := returns.LoadLines(tx, list); err != nil {
+			return err
+		}
+		request = list[0]
+		return apply(tx, &request, account.Id, decision)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find return",
+		})
+	}
+	if err != nil {
+		return returnError(c, err)
+	}
+	if done != nil {
+		if err := done(&request); err != nil {
+			return returnError(c, err)
+		}
+	}
+
+	result, _ := json.Marshal(&request)
+	return c.SendString(string(result))
+}
+
+func returnError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, returns.ErrNoLines),
+		errors.Is(err, returns.ErrUnknownLine),
+		errors.Is(err, returns.ErrInvalidQuantity),
+		errors.Is(err, returns.ErrInvalidReason),
+		errors.Is(err, returns.ErrInvalidPhoto),
+		errors.Is(err, returns.ErrTooManyPhotos),
+		errors.Is(err, returns.ErrInvalidRefund):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, returns.ErrNotReturnable),
+		errors.Is(err, returns.ErrWindowClosed),
+		errors.Is(err, returns.ErrInvalidStatus):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return orderError(c, err)
+}
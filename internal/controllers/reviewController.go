@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/reviews"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type ReviewController interface {
+
+	// Retrieve the approved reviews of a product
+	GetReviews(c *fiber.Ctx) error
+
+	// Review a product as the logged in account.
+	// returns an error if the account already reviewed it
+	CreateReview(c *fiber.Ctx) error
+
+	// Mark a review as helpful, once per account
+	VoteReview(c *fiber.Ctx) error
+
+	// Pending reviews, flagged ones first
+	GetModerationQueue(c *fiber.Ctx) error
+
+	// Approve or reject a pending review
+	ModerateReview(c *fiber.Ctx) error
+}
+
+var reviewInstance *reviewController
+
+type reviewController struct {
+	db    database.Service
+	redis *redis.Client
+	cache *cache.Cache
+}
+
+func NewReviewController(db database.Service, redis *redis.Client) *reviewController {
+
+	if reviewInstance != nil {
+		return reviewInstance
+	}
+
+	reviewInstance = &reviewController{
+		db:    db,
+		redis: redis,
+		cache: cache.NewCache(redis),
+	}
+
+	return reviewInstance
+}
+
+func (rc *reviewController) GetReviews(c *fiber.Ctx) error {
+	productId, err := uuid.Parse(c.Query("product"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	order := "created_at desc"
+	if c.Query("sort") == "helpful" {
+		order = "helpful desc, created_at desc"
+	}
+
+	var reviewList []models.Review
+	rc.db.UseGorm().Where("product = ? and status = ?", productId, reviews.StatusApproved).Order(order).Find(&reviewList)
+	result, _ := json.Marshal(reviewList)
+	return c.SendString(string(result))
+}
+
+func (rc *reviewController) CreateReview(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var review models.Review
+	if err := c.BodyParser(&review); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := reviews.Validate(review); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var count int64
+	rc.db.UseGorm().Model(&models.Product{}).Where("id = ? and status = ?", review.Product, workflow.StatusPublished).Count(&count)
+	if count == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find product",
+		})
+	}
+
+	review.Account = account.Id
+	err := reviews.Submit(rc.db.UseGorm(), &review)
+	switch {
+	case errors.Is(err, reviews.ErrAlreadyReviewed):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, reviews.ErrNotPurchased):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return err
+	}
+
+	result, _ := json.Marshal(&review)
+	return c.SendString(string(result))
+}
+
+func (rc *reviewController) VoteReview(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request struct {
+		Review uuid.UUID `json:"review"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var review models.Review
+	if err := rc.db.UseGorm().First(&review, "id = ? and status = ?", request.Review, reviews.StatusApproved).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find review",
+		})
+	}
+
+	err := rc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		if err := reviews.Vote(tx, review, account.Id); err != nil {
+			return err
+		}
+		return tx.First(&review, "id = ?", review.Id).Error
+	})
+	switch {
+	case errors.Is(err, reviews.ErrAlreadyVoted):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, reviews.ErrOwnReview):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return err
+	}
+
+	result, _ := json.Marshal(&review)
+	return c.SendString(string(result))
+}
+
+func (rc *reviewController) GetModerationQueue(c *fiber.Ctx) error {
+	if role, _ := middlewares.CurrentRole(c); !role.IsAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden: insufficient permissions",
+		})
+	}
+
+	var reviewList []models.Review
+	rc.db.UseGorm().Where("status = ?", reviews.StatusPending).Order("flagged desc, created_at").Find(&reviewList)
+	result, _ := json.Marshal(reviewList)
+	return c.SendString(string(result))
+}
+
+func (rc *reviewController) ModerateReview(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	if role, _ := middlewares.CurrentRole(c); !role.IsAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden: insufficient permissions",
+		})
+	}
+
+	var request struct {
+		Review  uuid.UUID `json:"review"`
+		Approve bool      `json:"approve"`
+		Note    string    `json:"note"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	status := reviews.StatusRejected
+	if request.Approve {
+		status = reviews.StatusApproved
+	}
+
+	var review models.Review
+	updated := rc.db.UseGorm().Model(&review).
+		Where("id = ? and status = ?", request.Review, reviews.StatusPending).
+		Updates(map[string]interface{}{
+			"status":          status,
+			"moderated_by":    account.Id,
+			"moderation_note": request.Note,
+		})
+	if updated.Error != nil {
+		return updated.Error
+	}
+
+	// This is not a batch updates
+	// Expect only 1 row changed
+	affectedRows = updated.RowsAffected
+	if affectedRows != 1 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Review is not pending moderation",
+		})
+	}
+
+	if err := rc.db.UseGorm().First(&review, "id = ?", request.Review).Error; err != nil {
+		return err
+	}
+	if request.Approve {
+		// Product reads carry the rating summary
+		rc.cache.Drop(c.Context(), cache.TagProducts)
+	}
+
+	result, _ := json.Marshal(&review)
+	return c.SendString(string(result))
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/catalog"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"github.com/kevinhartarto/market-be/internal/revisions"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type RevisionController interface {
+
+	// Retrieve the revisions of a product, brand or category, newest first
+	GetRevisions(c *fiber.Ctx) error
+
+	// Field level differences between two revisions of the same record
+	GetRevisionDiff(c *fiber.Ctx) error
+
+	// Restore a previous revision, saved as a new revision.
+	// returns a conflict if the record changed since the given version
+	RollbackRevision(c *fiber.Ctx) error
+}
+
+var revisionInstance *revisionController
+
+type revisionController struct {
+	db    database.Service
+	redis *redis.Client
+	cache *cache.Cache
+}
+
+func NewRevisionController(db database.Service, redis *redis.Client) *revisionController {
+
+	if revisionInstance != nil {
+		return revisionInstance
+	}
+
+	revisionInstance = &revisionController{
+		db:    db,
+		redis: redis,
+		cache: cache.NewCache(redis),
+	}
+
+	return revisionInstance
+}
+
+func (rc *revisionController) GetRevisions(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	list, err := revisions.List(rc.db.UseGorm(), c.Query("entity", catalog.EntityProduct), id)
+	if err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(list)
+	return c.SendString(string(result))
+}
+
+func (rc *revisionController) GetRevisionDiff(c *fiber.Ctx) error {
+	from, err := rc.findRevision(c.Query("from"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find revision",
+		})
+	}
+	to, err := rc.findRevision(c.Query("to"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find revision",
+		})
+	}
+
+	if from.Entity != to.Entity || from.Record != to.Record {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Revisions belong to different records",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"from":    from.Number,
+		"to":      to.Number,
+		"changes": revisions.Diff(from.Snapshot, to.Snapshot),
+	})
+}
+
+func (rc *revisionController) RollbackRevision(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	role, _ := middlewares.CurrentRole(c)
+	if !role.CanEdit {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden: insufficient permissions",
+		})
+	}
+
+	var request struct {
+		Revision uuid.UUID `json:"revision"`
+		Version  int       `json:"version"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	revision, err := rc.findRevision(request.Revision.String())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find revision",
+		})
+	}
+
+	version, checked := expectedVersion(c, request.Version)
+	if !checked {
+		return missingVersion(c)
+	}
+
+	db := rc.db.UseGorm()
+	switch revision.Entity {
+	case catalog.EntityProduct:
+		// The lifecycle only moves through the draft workflow, stock follows
+		// the orders and sales are managed on the live product
+		return rollback(c, db, revision, version, account.Id, func(tx *gorm.DB, record *models.Product) error {
+			return pricing.Record(tx, *record, pricing.SourceUpdate)
+		}, func() {
+			rc.cache.Drop(c.Context(), cache.TagProducts)
+		}, "status", "active", "stock", "on_sale", "sale_price", "sale_percent", "promo_sale")
+	case catalog.EntityBrand:
+		return rollback[models.Brand](c, db, revision, version, account.Id, nil, func() {
+			rc.cache.Drop(c.Context(), cache.TagBrands)
+		}, "on_sale", "promo_sale")
+	case catalog.EntityCategory:
+		return rollback[models.Category](c, db, revision, version, account.Id, nil, func() {
+			rc.cache.Drop(c.Context(), cache.TagCategories)
+		})
+	}
+
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error": revisions.ErrUnknownRecord.Error(),
+	})
+}
+
+func (rc *revisionController) findRevision(id string) (models.Revision, error) {
+	var revision models.Revision
+	err := rc.db.UseGorm().First(&revision, "id = ?", id).Error
+	return revision, err
+}
+
+// Save the snapshot of the revision over the current row
+func rollback[T any](c *fiber.Ctx, db *gorm.DB, revision models.Revision, version int, by uuid.UUID, after func(tx *gorm.DB, record *T) error, done func(), omit ...string) error {
+	var target T
+	if err := revisions.Restore(revision, &target); err != nil {
+		return err
+	}
+
+	setRecordVersion(&target, version+1)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := updateVersioned(tx, &target, version, by, omit...)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// This is not a batch updates
+		// Expect only 1 row changed
+		affectedRows = result.RowsAffected
+		if affectedRows != 1 {
+			return errStaleVersion
+		}
+
+		if err := tx.First(&target, "id = ?", revision.Record).Error; err != nil {
+			return err
+		}
+		if err := revisions.Record(tx, &target, revisions.SourceRollback); err != nil {
+			return err
+		}
+		if after != nil {
+			return after(tx, &target)
+		}
+		return nil
+	})
+
+	if errors.Is(err, errStaleVersion) {
+		return versionConflict[T](c, db, revision.Record, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	done()
+	result, _ := json.Marshal(&target)
+	return c.SendString(string(result))
+}
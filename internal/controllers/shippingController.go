@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/shipping"
+	"github.com/redis/go-redis/v9"
+)
+
+type ShippingController interface {
+
+	// Retrieve all shipping zones with their methods
+	GetZones(c *fiber.Ctx) error
+
+	// Create a shipping zone
+	CreateZone(c *fiber.Ctx) error
+
+	// Update a shipping zone
+	// returns an error if the zone not found
+	UpdateZone(c *fiber.Ctx) error
+
+	// Delete a shipping zone and its methods
+	DeleteZone(c *fiber.Ctx) error
+
+	// Add a shipping method to a zone
+	CreateMethod(c *fiber.Ctx) error
+
+	// Update a shipping method
+	// returns an error if the method not found
+	UpdateMethod(c *fiber.Ctx) error
+
+	// Delete a shipping method
+	DeleteMethod(c *fiber.Ctx) error
+}
+
+var shippingInstance *shippingController
+
+type shippingController struct {
+	db    database.Service
+	redis *redis.Client
+}
+
+func NewShippingController(db database.Service, redis *redis.Client) *shippingController {
+
+	if shippingInstance != nil {
+		return shippingInstance
+	}
+
+	shippingInstance = &shippingController{
+		db:    db,
+		redis: redis,
+	}
+
+	return shippingInstance
+}
+
+func (sc *shippingController) GetZones(c *fiber.Ctx) error {
+	var zones []models.ShippingZone
+	if err := sc.db.UseGorm().Order("name").Find(&zones).Error; err != nil {
+		return err
+	}
+	if err := shipping.LoadMethods(sc.db.UseGorm(), zones); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(zones)
+	return c.SendString(string(result))
+}
+
+func (sc *shippingController) CreateZone(c *fiber.Ctx) error {
+	var zone models.ShippingZone
+	if err := c.BodyParser(&zone); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := shipping.ValidateZone(&zone); err != nil {
+		return shippingError(c, err)
+	}
+
+	zone.Methods = nil
+	if err := sc.db.UseGorm().Create(&zone).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&zone)
+	return c.Status(fiber.StatusCreated).SendString(string(result))
+}
+
+func (sc *shippingController) UpdateZone(c *fiber.Ctx) error {
+	var zone models.ShippingZone
+	if err := c.BodyParser(&zone); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := shipping.ValidateZone(&zone); err != nil {
+		return shippingError(c, err)
+	}
+
+	affected := sc.db.UseGorm().Model(&zone).
+		Select("name", "countries", "postcodes", "active", "updated_at").
+		Updates(&zone).RowsAffected
+	if affected != 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find shipping zone",
+		})
+	}
+
+	result, _ := json.Marshal(&zone)
+	return c.SendString(string(result))
+}
+
+func (sc *shippingController) DeleteZone(c *fiber.Ctx) error {
+	if sc.db.UseGorm().Delete(&models.ShippingZone{}, "id = ?", c.Query("id")).RowsAffected != 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find shipping zone",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (sc *shippingController) CreateMethod(c *fiber.Ctx) error {
+	var method models.ShippingMethod
+	if err := c.BodyParser(&method); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := shipping.ValidateMethod(method); err != nil {
+		return shippingError(c, err)
+	}
+
+	var zone models.ShippingZone
+	if err := sc.db.UseGorm().First(&zone, "id = ?", method.Zone).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find shipping zone",
+		})
+	}
+
+	if err := sc.db.UseGorm().Create(&method).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&method)
+	return c.Status(fiber.StatusCreated).SendString(string(result))
+}
+
+func (sc *shippingController) UpdateMethod(c *fiber.Ctx) error {
+	var method models.ShippingMethod
+	if err := c.BodyParser(&method); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := shipping.ValidateMethod(method); err != nil {
+		return shippingError(c, err)
+	}
+
+	// Methods stay in their zone
+	affected := sc.db.UseGorm().Model(&method).
+		Select("name", "kind", "price", "per_kg", "free_over", "max_weight", "carrier", "service",
+			"min_days", "max_days", "active", "updated_at").
+		Updates(&method).RowsAffected
+	if affected != 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find shipping method",
+		})
+	}
+
+	result, _ := json.Marshal(&method)
+	return c.SendString(string(result))
+}
+
+func (sc *shippingController) DeleteMethod(c *fiber.Ctx) error {
+	if sc.db.UseGorm().Delete(&models.ShippingMethod{}, "id = ?", c.Query("id")).RowsAffected != 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find shipping method",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func shippingError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, shipping.ErrInvalidZone),
+		errors.Is(err, shipping.ErrInvalidMethod),
+		errors.Is(err, shipping.ErrUnknownCarrier):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/tax"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm/clause"
+)
+
+type TaxController interface {
+
+	// Retrieve the tax rates, optionally of a country
+	GetRates(c *fiber.Ctx) error
+
+	// Create a tax rate or replace the one of the same jurisdiction and class
+	SaveRate(c *fiber.Ctx) error
+
+	// Delete a tax rate
+	DeleteRate(c *fiber.Ctx) error
+}
+
+var taxInstance *taxController
+
+type taxController struct {
+	db    database.Service
+	redis *redis.Client
+}
+
+func NewTaxController(db database.Service, redis *redis.Client) *taxController {
+
+	if taxInstance != nil {
+		return taxInstance
+	}
+
+	taxInstance = &taxController{
+		db:    db,
+		redis: redis,
+	}
+
+	return taxInstance
+}
+
+func (tc *taxController) GetRates(c *fiber.Ctx) error {
+	query := tc.db.UseGorm().Order("country, postcode, class")
+	if country := c.Query("country"); country != "" {
+		query = query.Where("country = ?", strings.ToUpper(country))
+	}
+
+	var rates []models.TaxRate
+	if err := query.Find(&rates).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(rates)
+	return c.SendString(string(result))
+}
+
+func (tc *taxController) SaveRate(c *fiber.Ctx) error {
+	var rate models.TaxRate
+	if err := c.BodyParser(&rate); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if err := tax.ValidateRate(&rate); err != nil {
+		if errors.Is(err, tax.ErrInvalidRate) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return err
+	}
+
+	rate.Id = uuid.Nil
+	if err := tc.db.UseGorm().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "country"}, {Name: "postcode"}, {Name: "class"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "rate", "updated_at"}),
+	}).Create(&rate).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&rate)
+	return c.SendString(string(result))
+}
+
+func (tc *taxController) DeleteRate(c *fiber.Ctx) error {
+	if tc.db.UseGorm().Delete(&models.TaxRate{}, "id = ?", c.Query("id")).RowsAffected != 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find tax rate",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
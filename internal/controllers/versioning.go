@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"gorm.io/gorm"
+)
+
+var (
+	errStaleVersion  = errors.New("stale version")
+	errInvalidUpdate = errors.New("invalid update type")
+	errMissingId     = errors.New("missing record id")
+	errInUse         = errors.New("already in use")
+)
+
+// Version the client based its change on.
+// The If-Match header, the ETag of the record detail, wins over the
+// version sent in the body, returns false when the client did not send any.
+func expectedVersion(c *fiber.Ctx, bodyVersion int) (int, bool) {
+	if match := c.Get(fiber.HeaderIfMatch); match != "" {
+		// An If-Match without a version can never match the record
+		version, _ := middlewares.ETagVersion(match)
+		return version, true
+	}
+	return bodyVersion, bodyVersion > 0
+}
+
+// Save every column of the record if the row of its id still has the
+// expected version. The caller sets the record version to the next one
+// beforehand. Owners and promotion sales have their own flows and are
+// never saved, records that track their editor are updated by the account.
+func updateVersioned(tx *gorm.DB, record any, version int, by uuid.UUID, omit ...string) *gorm.DB {
+	id := recordId(record)
+	if id == uuid.Nil {
+		return missingId(tx)
+	}
+
+	if _, ok := reflect.TypeOf(record).Elem().FieldByName("UpdateBy"); ok {
+		setRecordUpdatedBy(record, by)
+	}
+
+	omit = append(omit, "id", "created_at", "owner", "promo_sale")
+	return tx.Model(record).Where("id = ? and version = ?", id, version).Select("*").Omit(omit...).Updates(record)
+}
+
+// Account making the change, saved as the editor of the record
+func editorId(c *fiber.Ctx) uuid.UUID {
+	account, _ := middlewares.CurrentAccount(c)
+	return account.Id
+}
+
+// Update a single column and bump the version,
+// the version is only checked when the client sent one.
+func updateColumnVersioned(tx *gorm.DB, record any, column string, value any, version int, checked bool) *gorm.DB {
+	id := recordId(record)
+	if id == uuid.Nil {
+		return missingId(tx)
+	}
+
+	query := tx.Model(record).Where("id = ?", id)
+	if checked {
+		query = query.Where("version = ?", version)
+	}
+	return query.Updates(map[string]interface{}{
+		column:    value,
+		"version": gorm.Expr("version + 1"),
+	})
+}
+
+// Result of an update refused before it reached the database
+func missingId(tx *gorm.DB) *gorm.DB {
+	result := tx.Session(&gorm.Session{NewDB: true})
+	result.AddError(errMissingId)
+	return result
+}
+
+// Answer a stale update with the current state of the row
+func versionConflict[T any](c *fiber.Ctx, db *gorm.DB, id uuid.UUID, hide func(*T)) error {
+	var current T
+	if err := db.First(&current, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find record",
+		})
+	}
+
+	if hide != nil {
+		hide(&current)
+	}
+
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":   "Record was modified by someone else",
+		"current": current,
+	})
+}
+
+func missingVersion(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+		"error": "Missing version, send If-Match or the version of the record",
+	})
+}
@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/wishlists"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type WishlistController interface {
+
+	// Retrieve the wishlists of the logged in account with their items
+	GetWishlists(c *fiber.Ctx) error
+
+	// Get a public wishlist from its share token
+	GetSharedWishlist(c *fiber.Ctx) error
+
+	// Create a named wishlist
+	CreateWishlist(c *fiber.Ctx) error
+
+	// Rename a wishlist or change whether it can be shared
+	UpdateWishlist(c *fiber.Ctx) error
+
+	// Delete a wishlist and its items
+	DeleteWishlist(c *fiber.Ctx) error
+
+	// Add a product, optionally a colour and size, to a wishlist
+	AddItem(c *fiber.Ctx) error
+
+	// Remove an item from its wishlist
+	RemoveItem(c *fiber.Ctx) error
+
+	// Move an item from its wishlist to the cart
+	MoveToCart(c *fiber.Ctx) error
+}
+
+var wishlistInstance *wishlistController
+
+type wishlistController struct {
+	db        database.Service
+	redis     *redis.Client
+	converter *currency.Converter
+}
+
+func NewWishlistController(db database.Service, redis *redis.Client) *wishlistController {
+
+	if wishlistInstance != nil {
+		return wishlistInstance
+	}
+
+	wishlistInstance = &wishlistController{
+		db:        db,
+		redis:     redis,
+		converter: currency.NewConverter(),
+	}
+
+	return wishlistInstance
+}
+
+func (wc *wishlistController) GetWishlists(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var lists []models.Wishlist
+	if err := wc.db.UseGorm().Where("account = ?", account.Id).Order("created_at").Find(&lists).Error; err != nil {
+		return err
+	}
+	if err := wishlists.LoadItems(wc.db.UseGorm(), lists); err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(lists)
+	return c.SendString(string(result))
+}
+
+func (wc *wishlistController) GetSharedWishlist(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	var list models.Wishlist
+	if err := wc.db.UseGorm().First(&list, "share_token = ? and public", token).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find wishlist",
+		})
+	}
+
+	lists := []models.Wishlist{list}
+	if err := wishlists.LoadItems(wc.db.UseGorm(), lists); err != nil {
+		return err
+	}
+
+	// The token is only for the owner to hand out
+	lists[0].ShareToken = ""
+	result, _ := json.Marshal(&lists[0])
+	return c.SendString(string(result))
+}
+
+func (wc *wishlistController) CreateWishlist(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var list models.Wishlist
+	if err := c.BodyParser(&list); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	name, err := wishlists.NormaliseName(list.Name)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	token, err := wishlists.NewShareToken()
+	if err != nil {
+		return err
+	}
+
+	list.Id = uuid.Nil
+	list.Account = account.Id
+	list.Name = name
+	list.ShareToken = token
+	if err := wc.db.UseGorm().Create(&list).Error; err != nil {
+		return err
+	}
+
+	list.Items = []models.WishlistItem{}
+	result, _ := json.Marshal(&list)
+	return c.SendString(string(result))
+}
+
+func (wc *wishlistController) UpdateWishlist(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var request models.Wishlist
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	name, err := wishlists.NormaliseName(request.Name)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	list, err := wc.findWishlist(request.Id, account.Id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find wishlist",
+		})
+	}
+
+	list.Name = name
+	list.Public = request.Public
+	if err := wc.db.UseGorm().Model(&list).Select("name", "public").Updates(&list).Error; err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&list)
+	return c.SendString(string(result))
+}
+
+func (wc *wishlistController) DeleteWishlist(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	id, err := uuid.Parse(c.Query("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Request",
+		})
+	}
+
+	// Items go with the wishlist
+	result := wc.db.UseGorm().Where("id = ? and account = ?", id, account.Id).Delete(&models.Wishlist{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// This is not a batch updates
+	// Expect only 1 row changed
+	affectedRows = result.RowsAffected
+	if affectedRows != 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find wishlist",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func (wc *wishlistController) AddItem(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	var item models.WishlistItem
+	if err := c.BodyParser(&item); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if _, err := wc.findWishlist(item.Wishlist, account.Id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find wishlist",
+		})
+	}
+
+	var product models.Product
+	if err := wc.db.UseGorm().First(&product, "id = ? and status = ?", item.Product, workflow.StatusPublished).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find product",
+		})
+	}
+
+	if err := carts.ValidateVariant(product, item.Colour, item.Size); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err := wishlists.AddItem(wc.db.UseGorm(), &item, product)
+	if errors.Is(err, wishlists.ErrAlreadyListed) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&item)
+	return c.SendString(string(result))
+}
+
+func (wc *wishlistController) RemoveItem(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+
+	item, err := wc.findItem(c.Query("id"), account.Id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find item",
+		})
+	}
+
+	if err := wc.db.UseGorm().Delete(&item).Error; err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func (wc *wishlistController) MoveToCart(c *fiber.Ctx) error {
+	account, _ := middlewares.CurrentAccount(c)
+	if role, _ := middlewares.CurrentRole(c); !role.CanBuy {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden: insufficient permissions",
+		})
+	}
+
+	var request struct {
+		Item uuid.UUID `json:"item"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	item, err := wc.findItem(request.Item.String(), account.Id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unable to find item",
+		})
+	}
+
+	var userCart models.Cart
+	err = wc.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var err error
+		userCart, err = wishlists.MoveToCart(tx, wc.converter, item, account.Id)
+		return err
+	})
+	if errors.Is(err, carts.ErrUnknownProduct) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, carts.ErrInvalidQuantity) || errors.Is(err, carts.ErrUnknownVariant) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, inventory.ErrInsufficientStock) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	result, _ := json.Marshal(&userCart)
+	return c.SendString(string(result))
+}
+
+func (wc *wishlistController) findWishlist(id uuid.UUID, account uuid.UUID) (models.Wishlist, error) {
+	var list models.Wishlist
+	err := wc.db.UseGorm().First(&list, "id = ? and account = ?", id, account).Error
+	return list, err
+}
+
+// Items can only be reached through a wishlist of the account
+func (wc *wishlistController) findItem(id string, account uuid.UUID) (models.WishlistItem, error) {
+	var item models.WishlistItem
+	err := wc.db.UseGorm().
+		Where("id = ?", id).
+		Where("wishlist in (?)", wc.db.UseGorm().Model(&models.Wishlist{}).Select("id").Where("account = ?", account)).
+		First(&item).Error
+	return item, err
+}
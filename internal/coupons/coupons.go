@@ -0,0 +1,223 @@
+package coupons
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+
+	// No 0/O or 1/I so codes can be read out loud
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength   = 10
+)
+
+var (
+	ErrNotFound        = errors.New("coupon not found")
+	ErrInactive        = errors.New("coupon is not active")
+	ErrExpired         = errors.New("coupon has expired")
+	ErrUsageLimit      = errors.New("coupon usage limit reached")
+	ErrAccountLimit    = errors.New("coupon already used by this account")
+	ErrRoleNotEligible = errors.New("account is not eligible for this coupon")
+	ErrMinSpend        = errors.New("cart does not reach the minimum spend")
+	ErrNoEligibleItems = errors.New("no items in the cart are eligible for this coupon")
+)
+
+// A cart or order line the coupon can apply to
+type Line struct {
+	Product   models.Product
+	Quantity  int
+	UnitPrice int
+}
+
+// Check the coupon definition before it is stored
+func ValidateDefinition(coupon models.Coupon) error {
+	if strings.TrimSpace(coupon.Code) == "" {
+		return errors.New("code is required")
+	}
+
+	switch coupon.Type {
+	case TypePercentage:
+		if coupon.Value <= 0 || coupon.Value > 100 {
+			return errors.New("percentage must be between 1 and 100")
+		}
+	case TypeFixed:
+		if coupon.Value <= 0 {
+			return errors.New("fixed amount must be positive")
+		}
+	default:
+		return errors.New("unknown coupon type")
+	}
+
+	if coupon.MinSpend < 0 || coupon.UsageLimit < 0 || coupon.PerAccountLimit < 0 {
+		return errors.New("limits cannot be negative")
+	}
+
+	return nil
+}
+
+// Normalise a code entered by a customer
+func NormaliseCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Check that the account can still use the coupon.
+// accountUses is the number of times the account already redeemed it.
+func Validate(coupon models.Coupon, account models.Account, accountUses int, now time.Time) error {
+	if !coupon.Active {
+		return ErrInactive
+	}
+	if !coupon.ExpiresAt.IsZero() && now.After(coupon.ExpiresAt) {
+		return ErrExpired
+	}
+	if coupon.UsageLimit > 0 && coupon.UsedCount >= coupon.UsageLimit {
+		return ErrUsageLimit
+	}
+	if coupon.PerAccountLimit > 0 && accountUses >= coupon.PerAccountLimit {
+		return ErrAccountLimit
+	}
+	if len(coupon.Roles) > 0 && !slices.Contains(coupon.Roles, account.Role.String()) {
+		return ErrRoleNotEligible
+	}
+	return nil
+}
+
+// Check if the coupon can be used on the product
+func Eligible(coupon models.Coupon, product models.Product) bool {
+	if len(coupon.Brands) > 0 && !slices.Contains(coupon.Brands, product.Brand.String()) {
+		return false
+	}
+	if len(coupon.Categories) == 0 {
+		return true
+	}
+	for _, category := range product.Categories {
+		if slices.Contains(coupon.Categories, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute the discount of the coupon on the given lines.
+// The minimum spend is checked against the whole cart,
+// the discount only against the eligible lines.
+func Discount(coupon models.Coupon, lines []Line) (int, error) {
+	subtotal, eligible := 0, 0
+	for _, line := range lines {
+		amount := line.UnitPrice * line.Quantity
+		subtotal += amount
+		if Eligible(coupon, line.Product) {
+			eligible += amount
+		}
+	}
+
+	if subtotal < coupon.MinSpend {
+		return 0, ErrMinSpend
+	}
+	if eligible == 0 {
+		return 0, ErrNoEligibleItems
+	}
+
+	switch coupon.Type {
+	case TypePercentage:
+		return (eligible*coupon.Value + 50) / 100, nil
+	case TypeFixed:
+		return min(coupon.Value, eligible), nil
+	}
+	return 0, nil
+}
+
+// Find a coupon from the code entered by a customer
+func FindByCode(db *gorm.DB, code string) (models.Coupon, error) {
+	var coupon models.Coupon
+	if err := db.Where("code = ?", NormaliseCode(code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return coupon, ErrNotFound
+		}
+		return coupon, err
+	}
+	return coupon, nil
+}
+
+// Number of redemptions of the coupon by the account
+func AccountUses(db *gorm.DB, coupon models.Coupon, account models.Account) (int, error) {
+	var count int64
+	err := db.Model(&models.CouponRedemption{}).
+		Where("coupon = ? and account = ?", coupon.Id, account.Id).
+		Count(&count).Error
+	return int(count), err
+}
+
+// Record a redemption, to be called inside the checkout transaction.
+// The coupon row is locked while the limits are checked again, so two
+// concurrent checkouts cannot both use the last redemption of the coupon
+// or of the account.
+func Redeem(tx *gorm.DB, coupon models.Coupon, account models.Account, amount int) error {
+	var locked models.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, "id = ?", coupon.Id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if locked.UsageLimit > 0 && locked.UsedCount >= locked.UsageLimit {
+		return ErrUsageLimit
+	}
+	if locked.PerAccountLimit > 0 {
+		uses, err := AccountUses(tx, locked, account)
+		if err != nil {
+			return err
+		}
+		if uses >= locked.PerAccountLimit {
+			return ErrAccountLimit
+		}
+	}
+
+	if err := tx.Model(&locked).Update("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.CouponRedemption{
+		Coupon:  locked.Id,
+		Account: account.Id,
+		Amount:  amount,
+	}).Error
+}
+
+// Generate unique random codes starting with the normalised prefix
+func GenerateCodes(prefix string, count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+	alphabetSize := big.NewInt(int64(len(codeAlphabet)))
+
+	for len(codes) < count {
+		var builder strings.Builder
+		builder.WriteString(NormaliseCode(prefix))
+		for i := 0; i < codeLength; i++ {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, err
+			}
+			builder.WriteByte(codeAlphabet[n.Int64()])
+		}
+
+		code := builder.String()
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+
+	return codes, nil
+}
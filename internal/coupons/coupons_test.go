@@ -0,0 +1,100 @@
+package coupons
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+func TestDiscount(t *testing.T) {
+	shoes := uuid.New()
+	shirt := models.Product{Brand: uuid.New(), Categories: []string{"tops"}}
+	boot := models.Product{Brand: shoes, Categories: []string{"footwear"}}
+
+	lines := []Line{
+		{Product: shirt, Quantity: 2, UnitPrice: 1000},
+		{Product: boot, Quantity: 1, UnitPrice: 4999},
+	}
+
+	tests := []struct {
+		name   string
+		coupon models.Coupon
+		lines  []Line
+		want   int
+		err    error
+	}{
+		{
+			name:   "percentage of the whole cart",
+			coupon: models.Coupon{Type: TypePercentage, Value: 10},
+			lines:  lines,
+			want:   700,
+		},
+		{
+			name:   "percentage rounds half up",
+			coupon: models.Coupon{Type: TypePercentage, Value: 10},
+			lines:  []Line{{Product: boot, Quantity: 1, UnitPrice: 4995}},
+			want:   500,
+		},
+		{
+			name:   "percentage of the eligible brand only",
+			coupon: models.Coupon{Type: TypePercentage, Value: 20, Brands: []string{shoes.String()}},
+			lines:  lines,
+			want:   1000,
+		},
+		{
+			name:   "percentage of the eligible category only",
+			coupon: models.Coupon{Type: TypePercentage, Value: 50, Categories: []string{"tops"}},
+			lines:  lines,
+			want:   1000,
+		},
+		{
+			name:   "fixed amount",
+			coupon: models.Coupon{Type: TypeFixed, Value: 1500},
+			lines:  lines,
+			want:   1500,
+		},
+		{
+			name:   "fixed amount capped at the eligible lines",
+			coupon: models.Coupon{Type: TypeFixed, Value: 5000, Categories: []string{"tops"}},
+			lines:  lines,
+			want:   2000,
+		},
+		{
+			name:   "minimum spend counts the whole cart",
+			coupon: models.Coupon{Type: TypeFixed, Value: 500, MinSpend: 6000, Categories: []string{"tops"}},
+			lines:  lines,
+			want:   500,
+		},
+		{
+			name:   "minimum spend not reached",
+			coupon: models.Coupon{Type: TypeFixed, Value: 500, MinSpend: 7000},
+			lines:  lines,
+			err:    ErrMinSpend,
+		},
+		{
+			name:   "no eligible lines",
+			coupon: models.Coupon{Type: TypePercentage, Value: 10, Categories: []string{"hats"}},
+			lines:  lines,
+			err:    ErrNoEligibleItems,
+		},
+		{
+			name:   "empty cart",
+			coupon: models.Coupon{Type: TypeFixed, Value: 500},
+			err:    ErrNoEligibleItems,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Discount(test.coupon, test.lines)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Discount() error = %v, want %v", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("Discount() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package currency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+
+	converterInstance *Converter
+
+	// ISO 4217 currencies that do not use 2 decimal places
+	exponents = map[string]int{
+		"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+		"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+		"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+		"XOF": 0, "XPF": 0,
+	}
+)
+
+// Converter holds the exchange rates against the base currency of the store.
+// A rate is the amount of the currency one unit of the base currency buys.
+type Converter struct {
+	mu    sync.RWMutex
+	base  string
+	rates map[string]float64
+}
+
+// Rates file layout, also used by the admin endpoint
+type RateTable struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+func NewConverter() *Converter {
+
+	if converterInstance != nil {
+		return converterInstance
+	}
+
+	base := Normalise(os.Getenv("BASE_CURRENCY"))
+	if base == "" {
+		base = "EUR"
+	}
+
+	converterInstance = &Converter{
+		base:  base,
+		rates: map[string]float64{base: 1},
+	}
+
+	return converterInstance
+}
+
+func Normalise(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Number of minor units digits of the currency
+func Exponent(code string) int {
+	if exponent, ok := exponents[code]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Check the store has a rate for the currency, prices can only be set in those
+func Known(code string) bool {
+	return NewConverter().Supports(code)
+}
+
+func (cv *Converter) Base() string {
+	return cv.base
+}
+
+func (cv *Converter) Supports(code string) bool {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	_, ok := cv.rates[Normalise(code)]
+	return ok
+}
+
+// Copy of the current rates
+func (cv *Converter) Table() RateTable {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	rates := make(map[string]float64, len(cv.rates))
+	for code, rate := range cv.rates {
+		rates[code] = rate
+	}
+	return RateTable{Base: cv.base, Rates: rates}
+}
+
+// Load rates from a JSON file shaped like RateTable
+func (cv *Converter) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var table RateTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return fmt.Errorf("invalid rates file: %w", err)
+	}
+
+	return cv.Set(table)
+}
+
+// Merge rates into the converter.
+// Tables quoted against another base are rebased on the store currency.
+func (cv *Converter) Set(table RateTable) error {
+	base := Normalise(table.Base)
+	if base == "" {
+		base = cv.base
+	}
+
+	rates := make(map[string]float64, len(table.Rates)+1)
+	for code, rate := range table.Rates {
+		if rate <= 0 {
+			return fmt.Errorf("invalid rate for %s", code)
+		}
+		rates[Normalise(code)] = rate
+	}
+	rates[base] = 1
+
+	if base != cv.base {
+		storeRate, ok := rates[cv.base]
+		if !ok {
+			return fmt.Errorf("rates quoted in %s do not include %s", base, cv.base)
+		}
+		for code, rate := range rates {
+			rates[code] = rate / storeRate
+		}
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	for code, rate := range rates {
+		cv.rates[code] = rate
+	}
+	cv.rates[cv.base] = 1
+
+	return nil
+}
+
+// Convert an amount in minor units between two currencies.
+// The result is rounded half away from zero to the minor unit of the target.
+func (cv *Converter) Convert(amount int, from, to string) (int, error) {
+	from, to = Normalise(from), Normalise(to)
+	if from == "" {
+		from = cv.base
+	}
+	if from == to {
+		return amount, nil
+	}
+
+	cv.mu.RLock()
+	fromRate, okFrom := cv.rates[from]
+	toRate, okTo := cv.rates[to]
+	cv.mu.RUnlock()
+
+	if !okFrom || !okTo {
+		return 0, ErrUnknownCurrency
+	}
+
+	major := float64(amount) / math.Pow10(Exponent(from))
+	converted := major / fromRate * toRate
+	return int(math.Round(converted * math.Pow10(Exponent(to)))), nil
+}
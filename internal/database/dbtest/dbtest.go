@@ -0,0 +1,245 @@
+// Package dbtest answers the statements of a gorm database from a test, for
+// code whose behaviour depends on what postgres returns, without a server.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// Rows returned by a query, or the rows changed by a statement
+type Result struct {
+	Columns  []string
+	Rows     [][]driver.Value
+	Affected int64
+}
+
+// Answers a statement run on conn, a nil result has no rows.
+// Handlers are called from every connection at once and guard their state.
+type Handler func(conn *Conn, query string, args []driver.Value) (*Result, error)
+
+// Open a database answered by handler, configured like the one of the server
+func Open(t testing.TB, handler Handler) *gorm.DB {
+	sqlDB := sql.OpenDB(connector{handler: handler})
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{
+			SingularTable: true,
+		},
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type connector struct {
+	handler Handler
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &Conn{handler: c.handler}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: open a database with dbtest.Open")
+}
+
+// A connection, and the transaction running on it
+type Conn struct {
+	handler Handler
+
+	mu         sync.Mutex
+	inTx       bool
+	log        []entry
+	savepoints []savepoint
+}
+
+// What a statement did, to be undone on rollback and released once final
+type entry struct {
+	undo    func()
+	release func()
+}
+
+type savepoint struct {
+	name string
+	at   int
+}
+
+// Run fn if the statement is rolled back, with its transaction or to a
+// savepoint taken before it
+func (c *Conn) Undo(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, entry{undo: fn})
+}
+
+// Run fn once the statement is committed or rolled back, as postgres
+// releases the row locks it took
+func (c *Conn) Release(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, entry{release: fn})
+}
+
+func (c *Conn) end(from int, rollback bool) {
+	c.mu.Lock()
+	entries := c.log[from:]
+	c.log = c.log[:from]
+	c.mu.Unlock()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if rollback && entries[i].undo != nil {
+			entries[i].undo()
+		}
+		if entries[i].release != nil {
+			entries[i].release()
+		}
+	}
+}
+
+func (c *Conn) run(query string, named []driver.NamedValue) (*Result, error) {
+	if name, ok := strings.CutPrefix(query, "SAVEPOINT "); ok {
+		c.savepoints = append(c.savepoints, savepoint{name: name, at: len(c.log)})
+		return &Result{}, nil
+	}
+	if name, ok := strings.CutPrefix(query, "ROLLBACK TO SAVEPOINT "); ok {
+		for i := len(c.savepoints) - 1; i >= 0; i-- {
+			if c.savepoints[i].name == name {
+				c.end(c.savepoints[i].at, true)
+				c.savepoints = c.savepoints[:i+1]
+				return &Result{}, nil
+			}
+		}
+		return nil, errors.New("dbtest: no savepoint " + name)
+	}
+
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	result, err := c.handler(c, query, args)
+	if result == nil {
+		result = &Result{}
+	}
+
+	// Outside a transaction every statement commits on its own
+	if !c.inTx {
+		c.end(0, err != nil)
+	}
+	return result, err
+}
+
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.Affected), nil
+}
+
+func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.inTx {
+		return nil, errors.New("dbtest: transaction already running")
+	}
+	c.inTx = true
+	return tx{conn: c}, nil
+}
+
+func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("dbtest: prepared statements are not supported")
+}
+
+func (c *Conn) Close() error {
+	return nil
+}
+
+type tx struct {
+	conn *Conn
+}
+
+func (t tx) Commit() error {
+	t.conn.finish(false)
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.conn.finish(true)
+	return nil
+}
+
+func (c *Conn) finish(rollback bool) {
+	c.end(0, rollback)
+	c.inTx = false
+	c.savepoints = nil
+}
+
+type rows struct {
+	result *Result
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
+
+// The value an INSERT of one row gives its column, nil when not given
+func Inserted(query string, args []driver.Value, column string) driver.Value {
+	start := strings.Index(query, "(")
+	end := strings.Index(query, ") VALUES")
+	if start < 0 || end < start {
+		return nil
+	}
+	for i, name := range strings.Split(query[start+1:end], ",") {
+		if strings.Trim(name, `" `) == column && i < len(args) {
+			return args[i]
+		}
+	}
+	return nil
+}
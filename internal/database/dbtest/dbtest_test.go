@@ -0,0 +1,81 @@
+package dbtest
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestRollback(t *testing.T) {
+	var kept, released []string
+	db := Open(t, func(conn *Conn, query string, args []driver.Value) (*Result, error) {
+		name := query
+		kept = append(kept, name)
+		conn.Undo(func() {
+			for i := range kept {
+				if kept[i] == name {
+					kept = append(kept[:i], kept[i+1:]...)
+					break
+				}
+			}
+		})
+		conn.Release(func() { released = append(released, name) })
+		return &Result{Affected: 1}, nil
+	})
+
+	failed := errors.New("failed")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		tx.Exec("outer")
+		tx.Transaction(func(tx *gorm.DB) error {
+			tx.Exec("inner")
+			return failed
+		})
+		tx.Exec("after")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "after"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if want := []string{"inner", "after", "outer"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("released %v, want %v", released, want)
+	}
+
+	kept, released = nil, nil
+	db.Transaction(func(tx *gorm.DB) error {
+		tx.Exec("rolled back")
+		return failed
+	})
+	if len(kept) != 0 {
+		t.Errorf("kept %v after rollback", kept)
+	}
+	if want := []string{"rolled back"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("released %v, want %v", released, want)
+	}
+}
+
+func TestInserted(t *testing.T) {
+	query := `INSERT INTO "invoice" ("order","number","year") VALUES ($1,$2,$3) ON CONFLICT DO NOTHING RETURNING "id"`
+	args := []driver.Value{"order id", "INV-2026-000001", int64(2026)}
+
+	tests := []struct {
+		column string
+		want   driver.Value
+	}{
+		{"order", "order id"},
+		{"number", "INV-2026-000001"},
+		{"year", int64(2026)},
+		{"id", nil},
+	}
+
+	for _, test := range tests {
+		if got := Inserted(query, args, test.column); got != test.want {
+			t.Errorf("Inserted(%q) = %v, want %v", test.column, got, test.want)
+		}
+	}
+}
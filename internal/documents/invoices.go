@@ -0,0 +1,185 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/media"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/orders"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotInvoiced = errors.New("order is not paid, it has no invoice")
+
+	errIssued = errors.New("invoice was issued meanwhile")
+)
+
+// Statuses of orders that were paid, and so are invoiced
+var invoiced = map[string]bool{
+	orders.StatusPaid:       true,
+	orders.StatusFulfilling: true,
+	orders.StatusShipped:    true,
+	orders.StatusDelivered:  true,
+	orders.StatusRefunded:   true,
+}
+
+// Splits the tax of an order by rate for its invoice
+type TaxBreakdown func(db *gorm.DB, order models.Order) ([]models.TaxLine, error)
+
+// Without a tax engine all of the tax is at the flat TAX_ESTIMATE_RATE
+var breakdown TaxBreakdown = func(db *gorm.DB, order models.Order) ([]models.TaxLine, error) {
+	if order.Tax == 0 {
+		return []models.TaxLine{}, nil
+	}
+	rate, _ := strconv.ParseFloat(os.Getenv("TAX_ESTIMATE_RATE"), 64)
+	return []models.TaxLine{{
+		Name:    "Tax",
+		Rate:    rate,
+		Taxable: max(order.Subtotal-order.Discount, 0) + order.Shipping,
+		Amount:  order.Tax,
+	}}, nil
+}
+
+func SetTaxBreakdown(fn TaxBreakdown) {
+	breakdown = fn
+}
+
+// Invoice an order once it is paid. Failing to do so does not hold the
+// payment back, the invoice is issued when it is first downloaded instead.
+func IssueOnPaid(tx *gorm.DB, order models.Order, from string) error {
+	if order.Status != orders.StatusPaid {
+		return nil
+	}
+
+	// A savepoint, so a failure leaves the transaction of the payment usable
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		_, err := Issue(tx.Statement.Context, tx, order)
+		return err
+	})
+	if err != nil {
+		log.Printf("Invoice of order %s failed: %v", order.Id, err)
+	}
+	return nil
+}
+
+// Issue the invoice and packing slip of a paid order, or return the ones
+// issued before. Numbers are taken from a per year counter locked until tx
+// commits, so a rolled back invoice gives its number back and none are skipped.
+// The invoice row is stored before its documents, a failed upload rolls it back.
+func Issue(ctx context.Context, tx *gorm.DB, order models.Order) (models.Invoice, error) {
+	var invoice models.Invoice
+	err := tx.Where(`"order" = ?`, order.Id).First(&invoice).Error
+	if err == nil {
+		return invoice, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return invoice, err
+	}
+	if !invoiced[order.Status] {
+		return invoice, ErrNotInvoiced
+	}
+
+	if err := orders.Load(tx, &order); err != nil {
+		return invoice, err
+	}
+	var account models.Account
+	if err := tx.First(&account, "id = ?", order.Account).Error; err != nil {
+		return invoice, err
+	}
+	taxes, err := breakdown(tx, order)
+	if err != nil {
+		return invoice, err
+	}
+
+	// A concurrent download may issue the invoice first, its number is then
+	// given back by rolling back to the savepoint and its invoice is returned
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		invoice, err = nextInvoice(tx, order, account, taxes)
+		if err != nil {
+			return err
+		}
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&invoice)
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected == 0 {
+			return errIssued
+		}
+		return nil
+	})
+	if errors.Is(err, errIssued) {
+		err = tx.Where(`"order" = ?`, order.Id).First(&invoice).Error
+		return invoice, err
+	}
+	if err != nil {
+		return invoice, err
+	}
+
+	if err := media.Put(ctx, invoice.InvoiceKey, renderInvoice(invoice, order)); err != nil {
+		return invoice, err
+	}
+	if err := media.Put(ctx, invoice.SlipKey, renderPackingSlip(invoice, order)); err != nil {
+		return invoice, err
+	}
+	return invoice, nil
+}
+
+// The invoice of the order under the next number of the year
+func nextInvoice(tx *gorm.DB, order models.Order, account models.Account, taxes []models.TaxLine) (models.Invoice, error) {
+	var invoice models.Invoice
+	issued := time.Now()
+	var sequence int
+	if err := tx.Raw(`insert into invoice_sequence (year, last) values (?, 1)
+		on conflict (year) do update set last = invoice_sequence.last + 1
+		returning last`, issued.Year()).Scan(&sequence).Error; err != nil {
+		return invoice, err
+	}
+
+	invoice = models.Invoice{
+		Order:         order.Id,
+		Number:        invoiceNumber(issued.Year(), sequence),
+		Year:          issued.Year(),
+		Sequence:      sequence,
+		SellerName:    os.Getenv("SELLER_NAME"),
+		SellerAddress: os.Getenv("SELLER_ADDRESS"),
+		SellerTaxId:   os.Getenv("SELLER_TAX_ID"),
+		BuyerName:     account.Username,
+		BuyerEmail:    account.Email,
+		ShipTo:        order.ShipTo,
+		Subtotal:      order.Subtotal,
+		Discount:      order.Discount,
+		Tax:           order.Tax,
+		Shipping:      order.Shipping,
+		Total:         order.Total,
+		Currency:      order.Currency,
+		Taxes:         taxes,
+		TaxInclusive:  order.TaxInclusive,
+		IssuedAt:      issued,
+	}
+	invoice.InvoiceKey = fmt.Sprintf("invoices/%d/%s.pdf", invoice.Year, invoice.Number)
+	invoice.SlipKey = fmt.Sprintf("packing-slips/%s.pdf", order.Id)
+	return invoice, nil
+}
+
+// Invoice numbers run per year, INV-2024-000042
+func invoiceNumber(year int, sequence int) string {
+	return fmt.Sprintf("INV-%d-%06d", year, sequence)
+}
+
+// The stored invoice PDF of the invoice
+func InvoicePDF(ctx context.Context, invoice models.Invoice) ([]byte, error) {
+	return media.Get(ctx, invoice.InvoiceKey)
+}
+
+// The stored packing slip PDF of the invoice
+func PackingSlipPDF(ctx context.Context, invoice models.Invoice) ([]byte, error) {
+	return media.Get(ctx, invoice.SlipKey)
+}
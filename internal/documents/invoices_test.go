@@ -0,0 +1,214 @@
+package documents
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database/dbtest"
+	"github.com/kevinhartarto/market-be/internal/media"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/orders"
+	"gorm.io/gorm"
+)
+
+func TestInvoiceNumber(t *testing.T) {
+	tests := []struct {
+		year     int
+		sequence int
+		want     string
+	}{
+		{2024, 1, "INV-2024-000001"},
+		{2024, 42, "INV-2024-000042"},
+		{2025, 999999, "INV-2025-999999"},
+		{2025, 1000000, "INV-2025-1000000"},
+	}
+
+	for _, test := range tests {
+		if got := invoiceNumber(test.year, test.sequence); got != test.want {
+			t.Errorf("invoiceNumber(%d, %d) = %q, want %q", test.year, test.sequence, got, test.want)
+		}
+	}
+}
+
+// Numbers of a year sort in the order they were issued
+func TestInvoiceNumberOrder(t *testing.T) {
+	tests := []struct {
+		earlier int
+		later   int
+	}{
+		{1, 2},
+		{9, 10},
+		{99, 100},
+		{999, 1000},
+		{99999, 100000},
+	}
+
+	for _, test := range tests {
+		earlier, later := invoiceNumber(2024, test.earlier), invoiceNumber(2024, test.later)
+		if earlier >= later {
+			t.Errorf("%q sorts after %q", earlier, later)
+		}
+	}
+}
+
+// Invoices of the server, visible to others once committed
+type invoiceTable struct {
+	mu       sync.Mutex
+	last     int
+	year     sync.Mutex // the row of the year in invoice_sequence
+	invoices map[string]*storedInvoice
+
+	// Issuers of an order wait on each other after finding no invoice
+	arrived map[string]*sync.WaitGroup
+}
+
+type storedInvoice struct {
+	conn      *dbtest.Conn
+	committed bool
+	id        string
+	number    string
+	sequence  int64
+}
+
+func (table *invoiceTable) handle(conn *dbtest.Conn, query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "insert into invoice_sequence"):
+		// The upsert locks the row of the year until the transaction ends
+		table.year.Lock()
+		conn.Release(table.year.Unlock)
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		table.last++
+		conn.Undo(func() {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			table.last--
+		})
+		return &dbtest.Result{Columns: []string{"last"}, Rows: [][]driver.Value{{int64(table.last)}}}, nil
+
+	case strings.HasPrefix(query, `INSERT INTO "invoice"`):
+		order := dbtest.Inserted(query, args, "order").(string)
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		if _, ok := table.invoices[order]; ok {
+			return nil, nil
+		}
+		invoice := &storedInvoice{
+			conn:     conn,
+			id:       uuid.NewString(),
+			number:   dbtest.Inserted(query, args, "number").(string),
+			sequence: dbtest.Inserted(query, args, "sequence").(int64),
+		}
+		table.invoices[order] = invoice
+		conn.Undo(func() {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			delete(table.invoices, order)
+		})
+		conn.Release(func() {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			invoice.committed = table.invoices[order] == invoice
+		})
+		return &dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{invoice.id}}}, nil
+
+	case strings.HasPrefix(query, `SELECT * FROM "invoice"`):
+		order := args[0].(string)
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		invoice, ok := table.invoices[order]
+		if !ok || !invoice.committed && invoice.conn != conn {
+			return nil, nil
+		}
+		return &dbtest.Result{
+			Columns: []string{"id", "order", "number", "sequence"},
+			Rows:    [][]driver.Value{{invoice.id, order, invoice.number, invoice.sequence}},
+		}, nil
+
+	case strings.HasPrefix(query, `SELECT * FROM "account"`):
+		table.arrived[args[0].(string)].Done()
+		table.arrived[args[0].(string)].Wait()
+		return &dbtest.Result{
+			Columns: []string{"id", "username", "email"},
+			Rows:    [][]driver.Value{{args[0], "buyer", "buyer@example.com"}},
+		}, nil
+	}
+	return nil, nil
+}
+
+// Orders issued at once, twice each and some by payments that then roll
+// back, are numbered from one without gaps or duplicates
+func TestIssueNumbersWithoutGaps(t *testing.T) {
+	media.SetStorage(media.Disk{Root: t.TempDir()})
+	table := &invoiceTable{invoices: map[string]*storedInvoice{}, arrived: map[string]*sync.WaitGroup{}}
+	db := dbtest.Open(t, table.handle)
+	failed := errors.New("payment failed")
+
+	paid := []models.Order{}
+	for range 12 {
+		order := models.Order{Id: uuid.New(), Account: uuid.New(), Status: orders.StatusPaid, Currency: "USD"}
+		table.arrived[order.Account.String()] = &sync.WaitGroup{}
+		table.arrived[order.Account.String()].Add(2)
+		paid = append(paid, order)
+	}
+
+	var wg sync.WaitGroup
+	for i, order := range paid {
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := db.Transaction(func(tx *gorm.DB) error {
+					if _, err := Issue(context.Background(), tx, order); err != nil {
+						return err
+					}
+					if i%3 == 0 {
+						return failed
+					}
+					return nil
+				})
+				if err != nil && !errors.Is(err, failed) {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	sequences := []int64{}
+	numbers := map[string]bool{}
+	for i, order := range paid {
+		invoice, ok := table.invoices[order.Id.String()]
+		if i%3 == 0 {
+			if ok {
+				t.Errorf("order %d rolled back but kept invoice %s", i, invoice.number)
+			}
+			continue
+		}
+		if !ok || !invoice.committed {
+			t.Errorf("order %d has no invoice", i)
+			continue
+		}
+		if numbers[invoice.number] {
+			t.Errorf("number %s issued twice", invoice.number)
+		}
+		numbers[invoice.number] = true
+		sequences = append(sequences, invoice.sequence)
+	}
+
+	slices.Sort(sequences)
+	for i, sequence := range sequences {
+		if sequence != int64(i+1) {
+			t.Fatalf("sequences = %v, want 1 to %d", sequences, len(sequences))
+		}
+	}
+	if table.last != len(sequences) {
+		t.Errorf("sequence of the year = %d, want %d", table.last, len(sequences))
+	}
+}
@@ -0,0 +1,179 @@
+package documents
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+// Right edges of the columns of the line tables
+const (
+	columnQuantity = 380.0
+	columnPrice    = 465.0
+	columnTotal    = pageWidth - margin
+)
+
+func renderInvoice(invoice models.Invoice, order models.Order) []byte {
+	p := newPDF()
+	p.text(margin, 20, true, "INVOICE")
+	p.textRight(columnTotal, 10, true, invoice.Number)
+	p.down(16)
+	p.textRight(columnTotal, 9, false, "Issued "+invoice.IssuedAt.Format("2 January 2006"))
+	p.down(12)
+	p.textRight(columnTotal, 9, false, "Order "+order.Id.String())
+	p.down(30)
+
+	// Seller on the left, buyer on the right
+	p.text(margin, 10, true, "From")
+	p.text(320, 10, true, "Bill to")
+	p.down(14)
+	p.text(margin, 9, false, invoice.SellerName)
+	p.text(320, 9, false, invoice.BuyerName)
+	p.down(12)
+	p.text(margin, 9, false, invoice.SellerAddress)
+	p.text(320, 9, false, invoice.BuyerEmail)
+	if invoice.SellerTaxId != "" {
+		p.down(12)
+		p.text(margin, 9, false, "Tax ID "+invoice.SellerTaxId)
+	}
+	p.down(30)
+
+	p.text(margin, 9, true, "Item")
+	p.textRight(columnQuantity, 9, true, "Qty")
+	p.textRight(columnPrice, 9, true, "Unit price")
+	p.textRight(columnTotal, 9, true, "Amount")
+	p.rule()
+	for _, line := range order.Lines {
+		p.down(16)
+		p.text(margin, 9, false, describe(line))
+		p.textRight(columnQuantity, 9, false, strconv.Itoa(line.Quantity))
+		p.textRight(columnPrice, 9, false, money(line.UnitPrice, invoice.Currency))
+		p.textRight(columnTotal, 9, false, money(line.Total, invoice.Currency))
+	}
+	p.rule()
+	p.down(24)
+
+	total := func(label string, amount int, bold bool) {
+		p.textRight(columnPrice, 9, bold, label)
+		p.textRight(columnTotal, 9, bold, money(amount, invoice.Currency))
+		p.down(14)
+	}
+	total("Subtotal", invoice.Subtotal, false)
+	if invoice.Discount > 0 {
+		total("Discount", -invoice.Discount, false)
+	}
+	if order.ShippingName != "" {
+		total("Shipping, "+order.ShippingName, invoice.Shipping, false)
+	}
+	if !invoice.TaxInclusive {
+		taxes(invoice, total)
+	}
+	total("Total "+invoice.Currency, invoice.Total, true)
+
+	// Tax already in the prices is shown under the total it is part of
+	if invoice.TaxInclusive && len(invoice.Taxes) > 0 {
+		p.down(6)
+		p.textRight(columnTotal, 8, false, "Prices include tax")
+		p.down(14)
+		taxes(invoice, total)
+	}
+	return p.bytes()
+}
+
+func taxes(invoice models.Invoice, total func(label string, amount int, bold bool)) {
+	for _, tax := range invoice.Taxes {
+		label := fmt.Sprintf("%s %s%% of %s", tax.Name, strconv.FormatFloat(tax.Rate, 'f', -1, 64), money(tax.Taxable, invoice.Currency))
+		total(label, tax.Amount, false)
+	}
+}
+
+// A packing slip lists what goes in the parcel, without prices
+func renderPackingSlip(invoice models.Invoice, order models.Order) []byte {
+	p := newPDF()
+	p.text(margin, 20, true, "PACKING SLIP")
+	p.textRight(columnTotal, 10, true, invoice.Number)
+	p.down(16)
+	p.textRight(columnTotal, 9, false, "Order "+order.Id.String())
+	p.down(30)
+
+	p.text(margin, 10, true, "Ship to")
+	p.down(14)
+	for _, line := range addressLines(invoice) {
+		p.text(margin, 9, false, line)
+		p.down(12)
+	}
+	if order.ShippingName != "" {
+		p.down(6)
+		p.text(margin, 9, false, "Ship with "+order.ShippingName)
+	}
+	p.down(24)
+
+	p.text(margin, 9, true, "Item")
+	p.textRight(columnTotal, 9, true, "Qty")
+	p.rule()
+	items := 0
+	for _, line := range order.Lines {
+		p.down(16)
+		p.text(margin, 9, false, describe(line))
+		p.textRight(columnTotal, 9, false, strconv.Itoa(line.Quantity))
+		items += line.Quantity
+	}
+	p.rule()
+	p.down(24)
+	p.textRight(columnTotal, 9, true, fmt.Sprintf("%d items", items))
+	return p.bytes()
+}
+
+// Lines of the address the order ships to, the buyer when it has none
+func addressLines(invoice models.Invoice) []string {
+	address := invoice.ShipTo
+	if address.Line1 == "" {
+		return []string{invoice.BuyerName, invoice.BuyerEmail}
+	}
+
+	lines := []string{address.Name, address.Line1}
+	if address.Line2 != "" {
+		lines = append(lines, address.Line2)
+	}
+	return append(lines, address.Postcode+" "+address.City, address.Country)
+}
+
+func describe(line models.OrderLine) string {
+	variant := []string{}
+	for _, value := range []string{line.Colour, line.Size} {
+		if value != "" {
+			variant = append(variant, value)
+		}
+	}
+	if len(variant) == 0 {
+		return line.Name
+	}
+	return line.Name + " (" + strings.Join(variant, ", ") + ")"
+}
+
+// Amount in minor units as 1,234.56 for the digits of its currency
+func money(amount int, code string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	exponent := currency.Exponent(code)
+	unit := 1
+	for i := 0; i < exponent; i++ {
+		unit *= 10
+	}
+
+	whole := strconv.Itoa(amount / unit)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	if exponent == 0 {
+		return sign + whole
+	}
+	return fmt.Sprintf("%s%s.%0*d", sign, whole, exponent, amount%unit)
+}
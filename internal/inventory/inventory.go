@@ -0,0 +1,145 @@
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultHold = 15 * time.Minute
+
+var (
+	ErrInsufficientStock = errors.New("not enough stock")
+	ErrUnknownProduct    = errors.New("product not found")
+)
+
+// How long units stay held for a cart, STOCK_HOLD_MINUTES or 15 minutes
+func Hold() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("STOCK_HOLD_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return DefaultHold
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Units of the product not held by other carts
+func Available(db *gorm.DB, product models.Product, cart uuid.UUID) (int, error) {
+	var held int
+	if err := db.Model(&models.StockReservation{}).
+		Select("coalesce(sum(quantity), 0)").
+		Where("product = ? and cart <> ? and expires_at > ?", product.Id, cart, time.Now()).
+		Scan(&held).Error; err != nil {
+		return 0, err
+	}
+	return max(product.Stock-held, 0), nil
+}
+
+// Hold units of the product for the cart, replacing what it held before.
+// The hold is renewed for another Hold on every change.
+// The product row is locked so concurrent carts cannot oversell it.
+func Reserve(db *gorm.DB, cart uuid.UUID, productId uuid.UUID, quantity int) error {
+	if quantity <= 0 {
+		return Release(db, cart, productId)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, "id = ?", productId).Error; err != nil {
+			return ErrUnknownProduct
+		}
+
+		available, err := Available(tx, product, cart)
+		if err != nil {
+			return err
+		}
+
+		// Holding less than before is always allowed
+		var held int
+		if err := tx.Model(&models.StockReservation{}).
+			Select("coalesce(sum(quantity), 0)").
+			Where("cart = ? and product = ? and expires_at > ?", cart, productId, time.Now()).
+			Scan(&held).Error; err != nil {
+			return err
+		}
+		if quantity > available && quantity > held {
+			return ErrInsufficientStock
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "cart"}, {Name: "product"}},
+			DoUpdates: clause.AssignmentColumns([]string{"quantity", "expires_at", "updated_at"}),
+		}).Create(&models.StockReservation{
+			Cart:      cart,
+			Product:   productId,
+			Quantity:  quantity,
+			ExpiresAt: time.Now().Add(Hold()),
+		}).Error
+	})
+}
+
+func Release(db *gorm.DB, cart uuid.UUID, product uuid.UUID) error {
+	return db.Where("cart = ? and product = ?", cart, product).Delete(&models.StockReservation{}).Error
+}
+
+// Drop every hold of the cart
+func ReleaseCart(db *gorm.DB, cart uuid.UUID) error {
+	return db.Where("cart = ?", cart).Delete(&models.StockReservation{}).Error
+}
+
+// Take sold units out of stock and drop the holds of the cart.
+// Fails without changing anything if a product no longer has the units.
+func Take(tx *gorm.DB, cart uuid.UUID, quantities map[uuid.UUID]int) error {
+	for _, productId := range lockOrder(quantities) {
+		quantity := quantities[productId]
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, "id = ?", productId).Error; err != nil {
+			return ErrUnknownProduct
+		}
+
+		available, err := Available(tx, product, cart)
+		if err != nil {
+			return err
+		}
+		if quantity > available {
+			return ErrInsufficientStock
+		}
+
+		if err := tx.Model(&product).Update("stock", gorm.Expr("stock - ?", quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return ReleaseCart(tx, cart)
+}
+
+// Put units back in stock, when a sale is cancelled or returned
+func Restock(tx *gorm.DB, quantities map[uuid.UUID]int) error {
+	for _, productId := range lockOrder(quantities) {
+		if err := tx.Model(&models.Product{}).
+			Where("id = ?", productId).
+			Update("stock", gorm.Expr("stock + ?", quantities[productId])).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Products are locked in id order, so transactions changing the stock
+// of the same products wait on each other instead of deadlocking
+func lockOrder(quantities map[uuid.UUID]int) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(quantities))
+	for productId := range quantities {
+		ids = append(ids, productId)
+	}
+	slices.SortFunc(ids, func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return ids
+}
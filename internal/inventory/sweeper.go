@@ -0,0 +1,61 @@
+package inventory
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+// Sweeper deletes expired reservations, reads already ignore them
+type Sweeper struct {
+	db       database.Service
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+var sweeperInstance *Sweeper
+
+func NewSweeper(db database.Service, interval time.Duration) *Sweeper {
+
+	if sweeperInstance != nil {
+		return sweeperInstance
+	}
+
+	sweeperInstance = &Sweeper{
+		db:       db,
+		interval: interval,
+	}
+
+	return sweeperInstance
+}
+
+// Run the sweeper in the background until the context is cancelled
+func (s *Sweeper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := s.Run(); err != nil {
+				log.Printf("Reservation sweeper failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *Sweeper) Run() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.UseGorm().Where("expires_at <= ?", time.Now()).Delete(&models.StockReservation{}).Error
+}
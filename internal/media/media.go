@@ -0,0 +1,99 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("media not found")
+	ErrInvalidKey = errors.New("invalid media key")
+)
+
+// Storage keeps generated files such as invoices under a key
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Until an object store is integrated files are kept on disk under MEDIA_DIR
+var storage Storage = Disk{Root: mediaDir()}
+
+func SetStorage(s Storage) {
+	storage = s
+}
+
+func Put(ctx context.Context, key string, data []byte) error {
+	return storage.Put(ctx, key, data)
+}
+
+func Get(ctx context.Context, key string) ([]byte, error) {
+	return storage.Get(ctx, key)
+}
+
+func mediaDir() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return "media"
+}
+
+// Disk keeps files in a directory, keys are slash separated paths under it
+type Disk struct {
+	Root string
+}
+
+func (d Disk) Put(ctx context.Context, key string, data []byte) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed so a reader never sees half a file, each
+	// writer under its own name so concurrent writes of a key do not mix
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (d Disk) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d Disk) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || !fs.ValidPath(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(d.Root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,76 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDiskPutConcurrently(t *testing.T) {
+	disk := Disk{Root: t.TempDir()}
+	ctx := context.Background()
+
+	writes := [][]byte{
+		bytes.Repeat([]byte("a"), 1<<16),
+		bytes.Repeat([]byte("b"), 1<<16),
+		bytes.Repeat([]byte("c"), 1<<16),
+		bytes.Repeat([]byte("d"), 1<<16),
+	}
+	var wg sync.WaitGroup
+	for _, data := range writes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if err := disk.Put(ctx, "invoices/2026/INV-2026-000001.pdf", data); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := disk.Get(ctx, "invoices/2026/INV-2026-000001.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole := false
+	for _, data := range writes {
+		whole = whole || bytes.Equal(got, data)
+	}
+	if !whole {
+		t.Error("stored file mixes concurrent writes")
+	}
+
+	entries, err := os.ReadDir(disk.Root + "/invoices/2026")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the stored one", len(entries))
+	}
+}
+
+func TestDiskPath(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"invoices/2026/INV-2026-000001.pdf", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../secret", false},
+		{"invoices/../../secret", false},
+	}
+
+	disk := Disk{Root: t.TempDir()}
+	for _, test := range tests {
+		_, err := disk.path(test.key)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("path(%q) error = %v, want valid %v", test.key, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	ReplayedHeader    = "Idempotent-Replayed"
+
+	idempotencyPrefix = "idempotency:"
+
+	// How long a request may run before its key can be used again
+	idempotencyLock = time.Minute
+)
+
+// What is kept of a request under its key
+type idempotentRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status"`
+	Headers     [][2]string `json:"headers"`
+	Body        []byte      `json:"body"`
+}
+
+// Response headers the server sets on every response, they are not replayed
+var transportHeaders = map[string]bool{
+	fiber.HeaderContentLength:    true,
+	fiber.HeaderDate:             true,
+	fiber.HeaderServer:           true,
+	fiber.HeaderConnection:       true,
+	fiber.HeaderTransferEncoding: true,
+}
+
+// Idempotency honours the Idempotency-Key header on POST and PUT requests.
+// The first response under a key is kept for ttl and replayed to retries of
+// the same request with its headers, cookies included. A retry still running
+// gets 409 Conflict and a key reused
+// for another request gets 422. Server errors are not kept so they can be
+// retried, and Redis failures let the request through.
+func Idempotency(redisClient *redis.Client, ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyHeader)
+		if key == "" || (c.Method() != fiber.MethodPost && c.Method() != fiber.MethodPut) {
+			return c.Next()
+		}
+
+		// Keys are scoped to the caller, two clients may pick the same one
+		scope := sha256.Sum256([]byte(idempotencyScope(c) + "\n" + key))
+		redisKey := idempotencyPrefix + hex.EncodeToString(scope[:])
+
+		fingerprint := sha256.New()
+		fingerprint.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+		fingerprint.Write(c.Body())
+		record := idempotentRecord{Fingerprint: hex.EncodeToString(fingerprint.Sum(nil))}
+
+		pending, _ := json.Marshal(&record)
+		acquired, err := redisClient.SetNX(c.Context(), redisKey, pending, idempotencyLock).Result()
+		if err != nil {
+			log.Printf("Idempotency lock of %s failed: %v", c.OriginalURL(), err)
+			return c.Next()
+		}
+
+		if !acquired {
+			return replay(c, redisClient, redisKey, record.Fingerprint)
+		}
+
+		if err := c.Next(); err != nil {
+			redisClient.Del(c.Context(), redisKey)
+			return err
+		}
+
+		record.Status = c.Response().StatusCode()
+		if record.Status >= fiber.StatusInternalServerError {
+			redisClient.Del(c.Context(), redisKey)
+			return nil
+		}
+
+		record.Done = true
+		c.Response().Header.VisitAll(func(name []byte, value []byte) {
+			if !transportHeaders[string(name)] {
+				record.Headers = append(record.Headers, [2]string{string(name), string(value)})
+			}
+		})
+		record.Body = c.Response().Body()
+		done, _ := json.Marshal(&record)
+		if err := redisClient.Set(c.Context(), redisKey, done, ttl).Err(); err != nil {
+			log.Printf("Idempotency record of %s failed: %v", c.OriginalURL(), err)
+		}
+		return nil
+	}
+}
+
+func replay(c *fiber.Ctx, redisClient *redis.Client, redisKey string, fingerprint string) error {
+	value, err := redisClient.Get(c.Context(), redisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The first request failed meanwhile and released the key
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Request with this idempotency key was not completed, retry",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	var record idempotentRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return err
+	}
+
+	if record.Fingerprint != fingerprint {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Idempotency key was used for a different request",
+		})
+	}
+	if !record.Done {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Request with this idempotency key is in progress",
+		})
+	}
+
+	for _, header := range record.Headers {
+		c.Response().Header.Add(header[0], header[1])
+	}
+	c.Set(ReplayedHeader, "true")
+	return c.Status(record.Status).Send(record.Body)
+}
+
+// Who sent the request: the signed in account, else the guest cart,
+// else the client address and agent
+func idempotencyScope(c *fiber.Ctx) string {
+	if authorization := c.Get(fiber.HeaderAuthorization); authorization != "" {
+		return "account:" + authorization
+	}
+	if token := c.Cookies(carts.GuestCookie); token != "" {
+		return "guest:" + token
+	}
+	if token := c.Get(carts.GuestHeader); token != "" {
+		return "guest:" + token
+	}
+	return "client:" + c.IP() + "|" + c.Get(fiber.HeaderUserAgent)
+}
@@ -0,0 +1,271 @@
+package middlewares
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers the few commands the middleware sends, over RESP2
+type fakeRedis struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func startFakeRedis(t *testing.T) *redis.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeRedis{values: map[string]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:             listener.Addr().String(),
+		Protocol:         2,
+		DisableIndentity: true,
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, fr.run(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (fr *fakeRedis) run(args []string) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		value, ok := fr.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		// Expirations are accepted and ignored
+		for _, option := range args[3:] {
+			if strings.EqualFold(option, "NX") {
+				if _, ok := fr.values[args[1]]; ok {
+					return "$-1\r\n"
+				}
+			}
+		}
+		fr.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := fr.values[key]; ok {
+				delete(fr.values, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		args[i] = string(value[:size])
+	}
+	return args, nil
+}
+
+type idempotentRequest struct {
+	method        string
+	path          string
+	key           string
+	authorization string
+	body          string
+
+	status   int
+	replayed bool
+}
+
+func TestIdempotency(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []idempotentRequest
+		calls    int
+	}{
+		{
+			name: "retry gets the first response",
+			requests: []idempotentRequest{
+				{method: fiber.MethodPost, path: "/cart", key: "a", body: `{"quantity":1}`, status: fiber.StatusCreated},
+				{method: fiber.MethodPost, path: "/cart", key: "a", body: `{"quantity":1}`, status: fiber.StatusCreated, replayed: true},
+			},
+			calls: 1,
+		},
+		{
+			name: "key reused for another request",
+			requests: []idempotentRequest{
+				{method: fiber.MethodPost, path: "/cart", key: "a", body: `{"quantity":1}`, status: fiber.StatusCreated},
+				{method: fiber.MethodPost, path: "/cart", key: "a", body: `{"quantity":2}`, status: fiber.StatusUnprocessableEntity},
+			},
+			calls: 1,
+		},
+		{
+			name: "keys are scoped to the caller",
+			requests: []idempotentRequest{
+				{method: fiber.MethodPost, path: "/cart", key: "a", authorization: "Bearer one", status: fiber.StatusCreated},
+				{method: fiber.MethodPost, path: "/cart", key: "a", authorization: "Bearer two", status: fiber.StatusCreated},
+				{method: fiber.MethodPost, path: "/cart", key: "a", authorization: "Bearer one", status: fiber.StatusCreated, replayed: true},
+			},
+			calls: 2,
+		},
+		{
+			name: "server errors are not kept",
+			requests: []idempotentRequest{
+				{method: fiber.MethodPut, path: "/fail", key: "b", status: fiber.StatusInternalServerError},
+				{method: fiber.MethodPut, path: "/fail", key: "b", status: fiber.StatusInternalServerError},
+			},
+			calls: 2,
+		},
+		{
+			name: "requests without a key run every time",
+			requests: []idempotentRequest{
+				{method: fiber.MethodPost, path: "/cart", status: fiber.StatusCreated},
+				{method: fiber.MethodPost, path: "/cart", status: fiber.StatusCreated},
+			},
+			calls: 2,
+		},
+		{
+			name: "other methods ignore the key",
+			requests: []idempotentRequest{
+				{method: fiber.MethodDelete, path: "/cart", key: "c", status: fiber.StatusCreated},
+				{method: fiber.MethodDelete, path: "/cart", key: "c", status: fiber.StatusCreated},
+			},
+			calls: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			app := fiber.New()
+			app.Use(Idempotency(startFakeRedis(t), time.Hour))
+			app.All("/cart", func(c *fiber.Ctx) error {
+				calls++
+				c.Cookie(&fiber.Cookie{Name: "cart_token", Value: "token-" + strconv.Itoa(calls)})
+				c.Set("X-Cart-Token", "token-"+strconv.Itoa(calls))
+				return c.Status(fiber.StatusCreated).JSON(fiber.Map{"call": calls})
+			})
+			app.Put("/fail", func(c *fiber.Ctx) error {
+				calls++
+				return c.SendStatus(fiber.StatusInternalServerError)
+			})
+
+			var first map[string]string
+			var firstBody string
+			for i, request := range test.requests {
+				req := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body))
+				req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+				if request.key != "" {
+					req.Header.Set(IdempotencyHeader, request.key)
+				}
+				if request.authorization != "" {
+					req.Header.Set(fiber.HeaderAuthorization, request.authorization)
+				}
+
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if resp.StatusCode != request.status {
+					t.Fatalf("request %d: status = %d, want %d", i, resp.StatusCode, request.status)
+				}
+				if replayed := resp.Header.Get(ReplayedHeader) == "true"; replayed != request.replayed {
+					t.Fatalf("request %d: replayed = %v, want %v", i, replayed, request.replayed)
+				}
+
+				headers := map[string]string{
+					fiber.HeaderContentType: resp.Header.Get(fiber.HeaderContentType),
+					fiber.HeaderSetCookie:   resp.Header.Get(fiber.HeaderSetCookie),
+					"X-Cart-Token":          resp.Header.Get("X-Cart-Token"),
+				}
+				if i == 0 {
+					first, firstBody = headers, string(body)
+					continue
+				}
+				if !request.replayed {
+					continue
+				}
+
+				// A replay is the first response, its cookie and headers included
+				if string(body) != firstBody {
+					t.Errorf("request %d: body = %s, want %s", i, body, firstBody)
+				}
+				for name, value := range first {
+					if headers[name] != value {
+						t.Errorf("request %d: %s = %q, want %q", i, name, headers[name], value)
+					}
+				}
+			}
+
+			if calls != test.calls {
+				t.Errorf("handler ran %d times, want %d", calls, test.calls)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,6 +17,14 @@ import (
 var (
 	SecretKey = []byte("e7185081-044a-4b23-ae05-95e18110607d")
 	Roles     = "roles"
+
+	errInvalidToken = errors.New("Invalid token")
+)
+
+// Request locals set by Authenticate
+const (
+	AccountKey = "account"
+	RoleKey    = "role"
 )
 
 type UserMiddleware struct {
@@ -38,61 +47,132 @@ type UserClaims struct {
 
 func (um *UserMiddleware) Authorize(allowedRoles []string, db database.Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		tokenString := c.Get("Authorization")
-		if tokenString == "" {
+		claims, err := um.verifyToken(c)
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Missing token",
+				"error": err.Error(),
 			})
 		}
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
-		token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(t *jwt.Token) (interface{}, error) {
-			return SecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token",
-			})
+		var roles []models.Role
+		if err := um.redis.HGetAll(um.ctx, Roles).Scan(&roles); err != nil {
+			return err
 		}
 
-		claims, ok := token.Claims.(*UserClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token",
+		hasRole := false
+		for _, role := range roles {
+			if claims.Role == role.Id {
+				hasRole = true
+				break
+			}
+		}
+
+		if !hasRole {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Forbidden: insufficient permissions",
 			})
 		}
 
-		key := utils.HashString(claims.Email)
-		value, err := um.redis.Get(um.ctx, key).Result()
+		return c.Next()
+	}
+}
+
+// Authenticate validates the token and stores the active account
+// and its role in the request locals for the handlers.
+func (um *UserMiddleware) Authenticate(db database.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := um.verifyToken(c)
 		if err != nil {
-			return err
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": err.Error(),
+			})
 		}
 
-		if value != tokenString {
+		var account models.Account
+		if err := db.UseGorm().Where("email = ? and active", claims.Email).First(&account).Error; err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid token",
 			})
 		}
 
-		var roles []models.Role
-		if err := um.redis.HGetAll(um.ctx, Roles).Scan(&roles); err != nil {
-			return err
+		var role models.Role
+		if err := db.UseGorm().Where("id = ? and deprecated is not true", account.Role).First(&role).Error; err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Forbidden: insufficient permissions",
+			})
 		}
 
-		hasRole := false
-		for _, role := range roles {
-			if claims.Role == role.Id {
-				hasRole = true
-				break
-			}
+		account.Password = ""
+		c.Locals(AccountKey, account)
+		c.Locals(RoleKey, role)
+
+		return c.Next()
+	}
+}
+
+// Identify authenticates requests that carry a token like Authenticate,
+// requests without one go through as guests.
+func (um *UserMiddleware) Identify(db database.Service) fiber.Handler {
+	authenticate := um.Authenticate(db)
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
 		}
+		return authenticate(c)
+	}
+}
 
-		if !hasRole {
+// Permission only lets through accounts whose role passes the check,
+// it runs after Authenticate.
+func Permission(allowed func(role models.Role) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, ok := CurrentRole(c)
+		if !ok || !allowed(role) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Forbidden: insufficient permissions",
 			})
 		}
-
 		return c.Next()
 	}
 }
+
+// Account stored by Authenticate
+func CurrentAccount(c *fiber.Ctx) (models.Account, bool) {
+	account, ok := c.Locals(AccountKey).(models.Account)
+	return account, ok
+}
+
+// Role stored by Authenticate
+func CurrentRole(c *fiber.Ctx) (models.Role, bool) {
+	role, ok := c.Locals(RoleKey).(models.Role)
+	return role, ok
+}
+
+func (um *UserMiddleware) verifyToken(c *fiber.Ctx) (*UserClaims, error) {
+	tokenString := c.Get("Authorization")
+	if tokenString == "" {
+		return nil, errors.New("Missing token")
+	}
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return SecretKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok {
+		return nil, errInvalidToken
+	}
+
+	// Only the last token issued at login is accepted
+	key := utils.HashString(claims.Email)
+	value, err := um.redis.Get(um.ctx, key).Result()
+	if err != nil || value != tokenString {
+		return nil, errInvalidToken
+	}
+
+	return claims, nil
+}
@@ -14,6 +14,7 @@ type Account struct {
 	Role      uuid.UUID `json:"role"`
 	Verified  bool      `json:"verified" gorm:"default:false"`
 	Active    bool      `json:"active" gorm:"default:true"`
+	Version   int       `json:"version" gorm:"default:1"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -30,4 +31,5 @@ type Role struct {
 	IsAdmin     bool      `json:"is_admin" gorm:"default:false"`
 	IsOwner     bool      `json:"is_owner" gorm:"default:false"`
 	Deprecated  bool      `json:"deprecated" gorm:"default:false"`
+	Version     int       `json:"version" gorm:"default:1"`
 }
@@ -8,6 +8,30 @@ import (
 
 type Cart struct {
 	Id        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
-	Content   []string  `json:"content"`
+	Coupon    string    `json:"coupon"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Loaded with the cart, oldest first
+	Lines []CartLine `json:"lines" gorm:"-"`
+}
+
+type CartLine struct {
+	Id       uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Cart     uuid.UUID `json:"cart"`
+	Product  uuid.UUID `json:"product"`
+	Colour   string    `json:"colour"`
+	Size     string    `json:"size"`
+	Quantity int       `json:"quantity"`
+
+	// Price of one unit when the line was added, in the base currency
+	UnitPrice int    `json:"unit_price"`
+	Currency  string `json:"currency"`
+
+	AddedAt   time.Time `json:"added_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Checked when the cart is served
+	Unavailable  bool `json:"unavailable" gorm:"-"`
+	PriceChanged bool `json:"price_changed" gorm:"-"`
+	CurrentPrice int  `json:"current_price" gorm:"-"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ImportJob struct {
+	Id            uuid.UUID        `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Entity        string           `json:"entity"`
+	Format        string           `json:"format"`
+	DryRun        bool             `json:"dry_run"`
+	Status        string           `json:"status"`
+	TotalRows     int              `json:"total_rows"`
+	ProcessedRows int              `json:"processed_rows"`
+	FailedRows    int              `json:"failed_rows"`
+	Errors        []ImportRowError `json:"errors" gorm:"serializer:json"`
+	CreatedBy     uuid.UUID        `json:"created_by"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
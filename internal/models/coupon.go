@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Coupon struct {
+	Id              uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Code            string    `json:"code"`
+	Type            string    `json:"type"`
+	Value           int       `json:"value"`
+	MinSpend        int       `json:"min_spend"`
+	UsageLimit      int       `json:"usage_limit"`
+	PerAccountLimit int       `json:"per_account_limit"`
+	UsedCount       int       `json:"used_count"`
+	Brands          []string  `json:"brands" gorm:"serializer:json"`
+	Categories      []string  `json:"categories" gorm:"serializer:json"`
+	Roles           []string  `json:"roles" gorm:"serializer:json"`
+	Batch           string    `json:"batch"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Active          bool      `json:"active"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type CouponRedemption struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Coupon    uuid.UUID `json:"coupon"`
+	Account   uuid.UUID `json:"account"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
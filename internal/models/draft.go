@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Pending changes of a product, the live row is only
+// replaced once the draft is approved and published
+type ProductDraft struct {
+	Id          uuid.UUID  `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Product     uuid.UUID  `json:"product"`
+	Data        Product    `json:"data" gorm:"serializer:json"`
+	Status      string     `json:"status" gorm:"default:draft"`
+	BaseVersion int        `json:"base_version"` // live version the draft started from, 0 for new products
+	Author      uuid.UUID  `json:"author"`
+	Reviewer    *uuid.UUID `json:"reviewer"`
+	ApprovedBy  *uuid.UUID `json:"approved_by"`
+	Notes       string     `json:"notes"`
+	PublishAt   *time.Time `json:"publish_at"`
+	PublishedAt *time.Time `json:"published_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
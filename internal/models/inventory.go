@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Units of a product held for a cart, an account or a guest cart,
+// the hold is ignored once it expires
+type StockReservation struct {
+	Cart      uuid.UUID `json:"cart" gorm:"type:uuid;primaryKey"`
+	Product   uuid.UUID `json:"product" gorm:"type:uuid;primaryKey"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The invoice of an order, numbered without gaps within its year.
+// Seller and buyer are copied in so the invoice never changes afterwards.
+type Invoice struct {
+	Id            uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Order         uuid.UUID `json:"order"`
+	Number        string    `json:"number"`
+	Year          int       `json:"year"`
+	Sequence      int       `json:"sequence"`
+	SellerName    string    `json:"seller_name"`
+	SellerAddress string    `json:"seller_address"`
+	SellerTaxId   string    `json:"seller_tax_id"`
+	BuyerName     string    `json:"buyer_name"`
+	BuyerEmail    string    `json:"buyer_email"`
+	ShipTo        Address   `json:"ship_to" gorm:"serializer:json"`
+	Subtotal      int       `json:"subtotal"`
+	Discount      int       `json:"discount"`
+	Tax           int       `json:"tax"`
+	Shipping      int       `json:"shipping"`
+	Total         int       `json:"total"`
+	Currency      string    `json:"currency"`
+	Taxes         []TaxLine `json:"taxes" gorm:"serializer:json"`
+	TaxInclusive  bool      `json:"tax_inclusive"`
+	InvoiceKey    string    `json:"-"`
+	SlipKey       string    `json:"-"`
+	IssuedAt      time.Time `json:"issued_at"`
+}
+
+// Last invoice number given out in a year
+type InvoiceSequence struct {
+	Year int `json:"year" gorm:"primaryKey"`
+	Last int `json:"last"`
+}
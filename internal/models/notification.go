@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Notification struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Account   uuid.UUID `json:"account"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	Reference uuid.UUID `json:"reference"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Amounts are in the currency of the order, the base currency at checkout
+type Order struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Account   uuid.UUID `json:"account"`
+	Status    string    `json:"status"`
+	Coupon    string    `json:"coupon"`
+	Subtotal  int       `json:"subtotal"`
+	Discount  int       `json:"discount"`
+	Tax       int       `json:"tax"`
+	Shipping  int       `json:"shipping"`
+	Total     int       `json:"total"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Tax by rate, already in the prices when inclusive
+	Taxes        []TaxLine `json:"taxes" gorm:"serializer:json"`
+	TaxInclusive bool      `json:"tax_inclusive"`
+
+	// Shipping method as it was chosen at checkout
+	ShippingMethod *uuid.UUID `json:"shipping_method"`
+	ShippingName   string     `json:"shipping_name"`
+	ShipTo         Address    `json:"ship_to" gorm:"serializer:json"`
+
+	// Loaded with the order
+	Lines   []OrderLine  `json:"lines,omitempty" gorm:"-"`
+	History []OrderEvent `json:"history,omitempty" gorm:"-"`
+}
+
+// Snapshot of a cart line at checkout, never changed afterwards
+type OrderLine struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Order     uuid.UUID `json:"order"`
+	Product   uuid.UUID `json:"product"`
+	Name      string    `json:"name"`
+	Brand     uuid.UUID `json:"brand"`
+	Colour    string    `json:"colour"`
+	Size      string    `json:"size"`
+	Quantity  int       `json:"quantity"`
+	UnitPrice int       `json:"unit_price"`
+	Total     int       `json:"total"`
+}
+
+// A status change of an order
+type OrderEvent struct {
+	Id        uuid.UUID  `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Order     uuid.UUID  `json:"order"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Note      string     `json:"note"`
+	ChangedBy *uuid.UUID `json:"changed_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A payment of an order at a provider, amounts in the order currency
+type Payment struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Order     uuid.UUID `json:"order"`
+	Provider  string    `json:"provider"`
+	Reference string    `json:"reference"`
+	Status    string    `json:"status"`
+	Amount    int       `json:"amount"`
+	Refunded  int       `json:"refunded"`
+	Currency  string    `json:"currency"`
+	ActionURL string    `json:"action_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// A refund asked of the provider. Pending refunds hold their amount so
+// it cannot be refunded twice while the provider has not answered.
+type PaymentRefund struct {
+	Id        uuid.UUID  `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Payment   uuid.UUID  `json:"payment"`
+	Order     uuid.UUID  `json:"order"`
+	Amount    int        `json:"amount"`
+	Status    string     `json:"status"`
+	CreatedBy *uuid.UUID `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// A webhook event already handled, providers may deliver one more than once
+type PaymentEvent struct {
+	Provider   string    `json:"provider" gorm:"primaryKey"`
+	Event      string    `json:"event" gorm:"primaryKey"`
+	Type       string    `json:"type"`
+	Reference  string    `json:"reference"`
+	ReceivedAt time.Time `json:"received_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PriceHistory struct {
+	Id          uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Product     uuid.UUID `json:"product"`
+	Price       int       `json:"price"`
+	OnSale      bool      `json:"on_sale"`
+	SalePrice   int       `json:"sale_price"`
+	SalePercent int       `json:"sale_percent"`
+	Source      string    `json:"source"`
+	ChangedBy   uuid.UUID `json:"changed_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Price of a product in a currency other than its own, overrides conversion
+type ProductPrice struct {
+	Product   uuid.UUID `json:"product" gorm:"type:uuid;primaryKey"`
+	Currency  string    `json:"currency" gorm:"primaryKey"`
+	Price     int       `json:"price"`
+	SalePrice int       `json:"sale_price"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ExchangeRate struct {
+	Currency  string    `json:"currency" gorm:"primaryKey"`
+	Rate      float64   `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
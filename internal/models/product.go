@@ -11,10 +11,12 @@ type Brand struct {
 	Name      string    `json:"name"`
 	Logo      string    `json:"logo"`
 	OnSale    bool      `json:"on_sale"`
+	PromoSale bool      `json:"promo_sale"` // on sale because of a running promotion
 	Active    bool      `json:"active"`
 	Owner     uuid.UUID `json:"owner"`
+	Version   int       `json:"version" gorm:"default:1"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdateBy  uuid.UUID `json:"updated_by"`
+	UpdateBy  uuid.UUID `json:"updated_by" gorm:"column:updated_by"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
@@ -25,29 +27,47 @@ type Category struct {
 	Featured    bool      `json:"featured"`
 	Active      bool      `json:"active"`
 	Owner       uuid.UUID `json:"owner"`
+	Version     int       `json:"version" gorm:"default:1"`
 	CreatedAt   time.Time `json:"created_at"`
-	UpdateBy    uuid.UUID `json:"updated_by"`
+	UpdateBy    uuid.UUID `json:"updated_by" gorm:"column:updated_by"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 type Product struct {
 	Id          uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	Name        string    `json:"name"`
-	Image       []string  `json:"images"`
-	Price       int       `json:"price"`
-	Colour      []string  `json:"colours"`
+	Image       []string  `json:"images" gorm:"serializer:json"`
+	Price       int       `json:"price"` // minor units of Currency
+	Currency    string    `json:"currency"`
+	Colour      []string  `json:"colours" gorm:"serializer:json"`
 	Brand       uuid.UUID `json:"brand"`
-	Categories  []string  `json:"categories"`
-	Size        []string  `json:"size"`
+	Categories  []string  `json:"categories" gorm:"serializer:json"`
+	Size        []string  `json:"size" gorm:"serializer:json"`
 	OnSale      bool      `json:"on_sale"`
 	SalePrice   int       `json:"sale_price"`
 	SalePercent int       `json:"sale_percent"`
+	PromoSale   bool      `json:"promo_sale"` // sale fields set by a running promotion
 	Stock       int       `json:"stock"`
+	TaxClass    string    `json:"tax_class" gorm:"default:standard"`
+	Weight      int       `json:"weight"` // grams
+	Length      int       `json:"length"` // millimetres, as are width and height
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
 	IsNew       bool      `json:"is_new"`
 	Description string    `json:"description"`
 	Owner       uuid.UUID `json:"owner"`
 	Active      bool      `json:"active"`
+	Status      string    `json:"status" gorm:"default:published"`
+	Version     int       `json:"version" gorm:"default:1"`
 	CreatedAt   time.Time `json:"created_at"`
-	UpdateBy    uuid.UUID `json:"updated_by"`
+	UpdateBy    uuid.UUID `json:"updated_by" gorm:"column:updated_by"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Computed on read from the active promotions and price history
+	EffectivePrice int         `json:"effective_price" gorm:"-"`
+	Promotions     []uuid.UUID `json:"promotions" gorm:"-"`
+	LowestPrice30d *int        `json:"lowest_price_30d,omitempty" gorm:"-"`
+
+	// Computed on read from the approved reviews
+	Rating *RatingSummary `json:"rating,omitempty" gorm:"-"`
 }
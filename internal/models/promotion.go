@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Promotion struct {
+	Id          uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Value       int       `json:"value"`
+	BuyQuantity int       `json:"buy_quantity"`
+	GetQuantity int       `json:"get_quantity"`
+	TargetType  string    `json:"target_type"`
+	Targets     []string  `json:"targets" gorm:"serializer:json"`
+	Priority    int       `json:"priority"`
+	Stackable   bool      `json:"stackable"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	Active      bool      `json:"active"`
+	Deprecated  bool      `json:"deprecated" gorm:"default:false"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Question struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Product   uuid.UUID `json:"product"`
+	Account   uuid.UUID `json:"account"`
+	Body      string    `json:"body"`
+	Answered  bool      `json:"answered"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Loaded with the question, most upvoted first
+	Answers []Answer `json:"answers" gorm:"-"`
+}
+
+type Answer struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Question  uuid.UUID `json:"question"`
+	Account   uuid.UUID `json:"account"`
+	Body      string    `json:"body"`
+	Upvotes   int       `json:"upvotes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type AnswerVote struct {
+	Answer    uuid.UUID `json:"answer" gorm:"primaryKey"`
+	Account   uuid.UUID `json:"account" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+}
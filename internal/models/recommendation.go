@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A product detail view, used to find products viewed together
+type ProductView struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Product   uuid.UUID `json:"product"`
+	Viewer    string    `json:"viewer"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Products bought or viewed together, mined from orders and views
+type ProductAssociation struct {
+	Product   uuid.UUID `json:"product" gorm:"primaryKey"`
+	Related   uuid.UUID `json:"related" gorm:"primaryKey"`
+	Kind      string    `json:"kind" gorm:"primaryKey"`
+	Score     float64   `json:"score"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A request to send lines of an order back, the refund is in the order currency
+type ReturnRequest struct {
+	Id         uuid.UUID  `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Order      uuid.UUID  `json:"order"`
+	Account    uuid.UUID  `json:"account"`
+	Status     string     `json:"status"`
+	Notes      string     `json:"notes"`
+	Carrier    string     `json:"carrier"`
+	Tracking   string     `json:"tracking"`
+	LabelURL   string     `json:"label_url"`
+	Refund     int        `json:"refund"`
+	ReviewedBy *uuid.UUID `json:"reviewed_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// Loaded with the return
+	Lines []ReturnLine `json:"lines" gorm:"-"`
+}
+
+type ReturnLine struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Return    uuid.UUID `json:"return"`
+	OrderLine uuid.UUID `json:"order_line"`
+	Product   uuid.UUID `json:"product"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason"`
+	Comment   string    `json:"comment"`
+	Photos    []string  `json:"photos" gorm:"serializer:json"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Review struct {
+	Id               uuid.UUID  `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Product          uuid.UUID  `json:"product"`
+	Account          uuid.UUID  `json:"account"`
+	Rating           int        `json:"rating"`
+	Title            string     `json:"title"`
+	Body             string     `json:"body"`
+	Photos           []string   `json:"photos" gorm:"serializer:json"`
+	VerifiedPurchase bool       `json:"verified_purchase"`
+	Status           string     `json:"status" gorm:"default:pending"`
+	Flagged          bool       `json:"flagged"`
+	Helpful          int        `json:"helpful"`
+	ModeratedBy      *uuid.UUID `json:"moderated_by"`
+	ModerationNote   string     `json:"moderation_note"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+type ReviewVote struct {
+	Review    uuid.UUID `json:"review" gorm:"primaryKey"`
+	Account   uuid.UUID `json:"account" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Aggregated approved ratings of a product
+type RatingSummary struct {
+	Average      float64     `json:"average"`
+	Count        int         `json:"count"`
+	Distribution map[int]int `json:"distribution"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Full snapshot of a product, brand or category every time it is saved
+type Revision struct {
+	Id        uuid.UUID              `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Entity    string                 `json:"entity"`
+	Record    uuid.UUID              `json:"record"`
+	Number    int                    `json:"number"`
+	Snapshot  map[string]interface{} `json:"snapshot" gorm:"serializer:json"`
+	Source    string                 `json:"source"`
+	ChangedBy uuid.UUID              `json:"changed_by"`
+	CreatedAt time.Time              `json:"created_at"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Where a set of shipping methods is offered, countries are ISO 3166 codes.
+// Without postcode ranges the zone covers the whole of its countries.
+type ShippingZone struct {
+	Id        uuid.UUID       `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Name      string          `json:"name"`
+	Countries []string        `json:"countries" gorm:"serializer:json"`
+	Postcodes []PostcodeRange `json:"postcodes" gorm:"serializer:json"`
+	Active    bool            `json:"active" gorm:"default:true"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	// Loaded with the zone
+	Methods []ShippingMethod `json:"methods,omitempty" gorm:"-"`
+}
+
+// Postcodes from From to To, both included
+type PostcodeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// A way of shipping to a zone, amounts are in the base currency.
+// Carrier methods are priced by the carrier, the others by Price and PerKg.
+// Orders worth FreeOver or more ship for free when it is set.
+type ShippingMethod struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Zone      uuid.UUID `json:"zone"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Price     int       `json:"price"`
+	PerKg     int       `json:"per_kg"`
+	FreeOver  int       `json:"free_over"`
+	MaxWeight int       `json:"max_weight"` // grams, zero for no limit
+	Carrier   string    `json:"carrier"`
+	Service   string    `json:"service"`
+	MinDays   int       `json:"min_days"`
+	MaxDays   int       `json:"max_days"`
+	Active    bool      `json:"active" gorm:"default:true"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Where an order is shipped to
+type Address struct {
+	Name     string `json:"name"`
+	Line1    string `json:"line1"`
+	Line2    string `json:"line2"`
+	City     string `json:"city"`
+	Postcode string `json:"postcode"`
+	Country  string `json:"country"`
+}
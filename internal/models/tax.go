@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rate of a tax class in a jurisdiction, a country or the postcodes of it
+// starting with Postcode. Rate is a percentage.
+type TaxRate struct {
+	Id        uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Country   string    `json:"country"`
+	Postcode  string    `json:"postcode"`
+	Class     string    `json:"class"`
+	Name      string    `json:"name"`
+	Rate      float64   `json:"rate"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Tax charged at one rate
+type TaxLine struct {
+	Name    string  `json:"name"`
+	Rate    float64 `json:"rate"`
+	Taxable int     `json:"taxable"`
+	Amount  int     `json:"amount"`
+}
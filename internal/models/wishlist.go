@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Wishlist struct {
+	Id         uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Account    uuid.UUID `json:"account"`
+	Name       string    `json:"name"`
+	Public     bool      `json:"public"`
+	ShareToken string    `json:"share_token,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Loaded with the wishlist
+	Items []WishlistItem `json:"items" gorm:"-"`
+}
+
+type WishlistItem struct {
+	Id       uuid.UUID `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Wishlist uuid.UUID `json:"wishlist"`
+	Product  uuid.UUID `json:"product"`
+	Colour   string    `json:"colour"`
+	Size     string    `json:"size"`
+
+	// Price and stock last seen, notifications are sent when they improve
+	LastPrice int  `json:"last_price"`
+	InStock   bool `json:"in_stock"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,47 @@
+package notifications
+
+import (
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	TypeQuestion    = "question"
+	TypePriceDrop   = "price_drop"
+	TypeBackInStock = "back_in_stock"
+	TypeReturn      = "return"
+)
+
+// Leave a notification for the account
+func Notify(tx *gorm.DB, account uuid.UUID, kind string, message string, reference uuid.UUID) error {
+	return tx.Create(&models.Notification{
+		Account:   account,
+		Type:      kind,
+		Message:   message,
+		Reference: reference,
+	}).Error
+}
+
+// Notifications of the account, newest first
+func List(db *gorm.DB, account uuid.UUID, unreadOnly bool) ([]models.Notification, error) {
+	query := db.Where("account = ?", account)
+	if unreadOnly {
+		query = query.Where("read is not true")
+	}
+
+	var list []models.Notification
+	err := query.Order("created_at desc").Find(&list).Error
+	return list, err
+}
+
+// Mark notifications of the account as read, all of them when no id is given
+func MarkRead(db *gorm.DB, account uuid.UUID, ids []uuid.UUID) (int64, error) {
+	query := db.Model(&models.Notification{}).Where("account = ? and read is not true", account)
+	if len(ids) > 0 {
+		query = query.Where("id in ?", ids)
+	}
+
+	result := query.Update("read", true)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,331 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/recommendations"
+	"github.com/kevinhartarto/market-be/internal/shipping"
+	"gorm.io/gorm"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusPaid       = "paid"
+	StatusFulfilling = "fulfilling"
+	StatusShipped    = "shipped"
+	StatusDelivered  = "delivered"
+	StatusCancelled  = "cancelled"
+	StatusRefunded   = "refunded"
+)
+
+var (
+	ErrEmptyCart         = errors.New("cart is empty")
+	ErrUnavailable       = errors.New("cart has unavailable lines")
+	ErrPriceChanged      = errors.New("cart prices changed, review the cart")
+	ErrCartChanged       = errors.New("cart changed since its shipping was quoted")
+	ErrInvalidTransition = errors.New("order cannot move to that status")
+	ErrStaleOrder        = errors.New("order was changed meanwhile")
+
+	// Statuses an order may move to from each status,
+	// once paid it can only be refunded instead of cancelled
+	transitions = map[string][]string{
+		StatusPending:    {StatusPaid, StatusCancelled},
+		StatusPaid:       {StatusFulfilling, StatusRefunded},
+		StatusFulfilling: {StatusShipped, StatusRefunded},
+		StatusShipped:    {StatusDelivered},
+		StatusDelivered:  {StatusRefunded},
+	}
+)
+
+// Called in the transaction of every status change, after it was recorded
+type TransitionHook func(tx *gorm.DB, order models.Order, from string) error
+
+var hooks []TransitionHook
+
+func AfterTransition(hook TransitionHook) {
+	hooks = append(hooks, hook)
+}
+
+func CanMove(from string, to string) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Where and how an order is shipped, chosen at checkout
+type Delivery struct {
+	Method  uuid.UUID      `json:"method"`
+	Address models.Address `json:"address"`
+}
+
+// Shipping of the cart priced before checkout, carriers are not asked
+// while the checkout transaction holds its locks
+type ShippingQuote struct {
+	Quote  shipping.Quote
+	Parcel shipping.Parcel
+}
+
+// The cart of the account as it is checked out
+type checkoutCart struct {
+	cart     models.Cart
+	coupon   models.Coupon
+	discount int
+	priced   []coupons.Line
+}
+
+// Price the shipping of the cart of the account as chosen, before Checkout
+func Quote(ctx context.Context, db *gorm.DB, converter *currency.Converter, account models.Account, delivery Delivery) (ShippingQuote, error) {
+	var quoted ShippingQuote
+	if err := shipping.ValidateAddress(&delivery.Address); err != nil {
+		return quoted, err
+	}
+
+	checkout, err := loadCheckout(db, converter, account)
+	if err != nil {
+		return quoted, err
+	}
+
+	quoted.Parcel = shipping.ParcelOf(checkout.priced, checkout.discount)
+	quoted.Quote, err = shipping.QuoteMethod(ctx, db, converter, delivery.Address, quoted.Parcel, delivery.Method)
+	return quoted, err
+}
+
+// Turn the cart of the account into a pending order shipped as quoted.
+// Lines keep the price shown in the cart, a price changed since fails the
+// checkout, as does a cart changed since its shipping was quoted. The coupon
+// is redeemed, stock is taken and the cart is emptied, all in the
+// transaction of tx.
+func Checkout(tx *gorm.DB, converter *currency.Converter, account models.Account, delivery Delivery, quoted ShippingQuote) (models.Order, error) {
+	var order models.Order
+	if err := shipping.ValidateAddress(&delivery.Address); err != nil {
+		return order, err
+	}
+
+	checkout, err := loadCheckout(tx, converter, account)
+	if err != nil {
+		return order, err
+	}
+	cart, priced, discount := checkout.cart, checkout.priced, checkout.discount
+
+	if shipping.ParcelOf(priced, discount) != quoted.Parcel || quoted.Quote.Method != delivery.Method {
+		return order, ErrCartChanged
+	}
+	if cart.Coupon != "" {
+		if err := coupons.Redeem(tx, checkout.coupon, account, discount); err != nil {
+			return order, err
+		}
+	}
+
+	totals, err := carts.Compute(tx, priced, discount, quoted.Quote.Amount, delivery.Address, converter.Base())
+	if err != nil {
+		return order, err
+	}
+
+	quantities := map[uuid.UUID]int{}
+	for _, line := range cart.Lines {
+		quantities[line.Product] += line.Quantity
+	}
+	if err := inventory.Take(tx, cart.Id, quantities); err != nil {
+		return order, err
+	}
+
+	order = models.Order{
+		Account:        account.Id,
+		Status:         StatusPending,
+		Coupon:         cart.Coupon,
+		Subtotal:       totals.Subtotal,
+		Discount:       totals.Discount,
+		Tax:            totals.TaxEstimate,
+		Taxes:          totals.Taxes,
+		TaxInclusive:   totals.TaxInclusive,
+		Shipping:       totals.Shipping,
+		Total:          totals.Total,
+		Currency:       totals.Currency,
+		ShippingMethod: &quoted.Quote.Method,
+		ShippingName:   quoted.Quote.Name,
+		ShipTo:         delivery.Address,
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return order, err
+	}
+
+	products := make(map[uuid.UUID]models.Product, len(priced))
+	for _, line := range priced {
+		products[line.Product.Id] = line.Product
+	}
+	for _, line := range cart.Lines {
+		product := products[line.Product]
+		order.Lines = append(order.Lines, models.OrderLine{
+			Order:     order.Id,
+			Product:   line.Product,
+			Name:      product.Name,
+			Brand:     product.Brand,
+			Colour:    line.Colour,
+			Size:      line.Size,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			Total:     line.UnitPrice * line.Quantity,
+		})
+	}
+	if err := tx.Create(&order.Lines).Error; err != nil {
+		return order, err
+	}
+
+	event := models.OrderEvent{Order: order.Id, To: StatusPending, ChangedBy: &account.Id}
+	if err := tx.Create(&event).Error; err != nil {
+		return order, err
+	}
+	order.History = []models.OrderEvent{event}
+
+	if err := tx.Where("cart = ?", cart.Id).Delete(&models.CartLine{}).Error; err != nil {
+		return order, err
+	}
+	return order, tx.Model(&models.Cart{}).Where("id = ?", cart.Id).Update("coupon", "").Error
+}
+
+// Load the cart of the account with its coupon discount, every line must
+// still be available at the price it was added for
+func loadCheckout(db *gorm.DB, converter *currency.Converter, account models.Account) (checkoutCart, error) {
+	var checkout checkoutCart
+	cart, err := carts.Load(db, account.Id)
+	if err != nil {
+		return checkout, err
+	}
+	if len(cart.Lines) == 0 {
+		return checkout, ErrEmptyCart
+	}
+
+	if err := carts.Check(db, converter, &cart); err != nil {
+		return checkout, err
+	}
+	for i := range cart.Lines {
+		if cart.Lines[i].Unavailable {
+			return checkout, ErrUnavailable
+		}
+		if cart.Lines[i].PriceChanged {
+			return checkout, ErrPriceChanged
+		}
+	}
+	checkout.cart = cart
+
+	if cart.Coupon != "" {
+		checkout.coupon, checkout.discount, err = carts.CheckCoupon(db, cart, cart.Coupon)
+		if err != nil {
+			return checkout, err
+		}
+	}
+
+	checkout.priced, err = carts.CouponLines(db, cart)
+	return checkout, err
+}
+
+// Move the order to another status and record it in its history.
+// Units of orders cancelled or refunded before shipping go back in stock.
+func Transition(tx *gorm.DB, order *models.Order, to string, by *uuid.UUID, note string) error {
+	from := order.Status
+	if !CanMove(from, to) {
+		return ErrInvalidTransition
+	}
+
+	result := tx.Model(&models.Order{}).
+		Where("id = ? and status = ?", order.Id, from).
+		Updates(map[string]interface{}{"status": to, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrStaleOrder
+	}
+
+	event := models.OrderEvent{Order: order.Id, From: from, To: to, Note: note, ChangedBy: by}
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+
+	if (to == StatusCancelled || to == StatusRefunded) && from != StatusShipped && from != StatusDelivered {
+		lines, err := loadLines(tx, order.Id)
+		if err != nil {
+			return err
+		}
+		quantities := map[uuid.UUID]int{}
+		for _, line := range lines {
+			quantities[line.Product] += line.Quantity
+		}
+		if err := inventory.Restock(tx, quantities); err != nil {
+			return err
+		}
+	}
+
+	order.Status = to
+	order.History = append(order.History, event)
+	for _, hook := range hooks {
+		if err := hook(tx, *order, from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load the lines and history of the order
+func Load(db *gorm.DB, order *models.Order) error {
+	lines, err := loadLines(db, order.Id)
+	if err != nil {
+		return err
+	}
+	order.Lines = lines
+
+	order.History = []models.OrderEvent{}
+	return db.Where(`"order" = ?`, order.Id).Order("created_at").Find(&order.History).Error
+}
+
+func loadLines(db *gorm.DB, id uuid.UUID) ([]models.OrderLine, error) {
+	lines := []models.OrderLine{}
+	err := db.Where(`"order" = ?`, id).Find(&lines).Error
+	return lines, err
+}
+
+// Whether the account bought the product in an order that was not
+// cancelled or refunded, used to verify reviews
+func Purchased(db *gorm.DB, account uuid.UUID, product uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.OrderLine{}).
+		Joins(`join "order" on "order".id = order_line."order"`).
+		Where(`"order".account = ? and order_line.product = ?`, account, product).
+		Where(`"order".status not in ?`, []string{StatusPending, StatusCancelled, StatusRefunded}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Products of each order placed since the given time, for the recommendations
+func Baskets(db *gorm.DB, since time.Time) ([]recommendations.Basket, error) {
+	var rows []models.OrderLine
+	if err := db.Model(&models.OrderLine{}).
+		Select(`order_line."order", order_line.product`).
+		Joins(`join "order" on "order".id = order_line."order"`).
+		Where(`"order".created_at >= ? and "order".status <> ?`, since, StatusCancelled).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byOrder := map[uuid.UUID]recommendations.Basket{}
+	for _, row := range rows {
+		byOrder[row.Order] = append(byOrder[row.Order], row.Product)
+	}
+
+	baskets := make([]recommendations.Basket, 0, len(byOrder))
+	for _, basket := range byOrder {
+		baskets = append(baskets, basket)
+	}
+	return baskets, nil
+}
@@ -0,0 +1,152 @@
+package orders
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database/dbtest"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestCanMove(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{StatusPending, StatusPaid, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusPending, StatusRefunded, false},
+		{StatusPending, StatusShipped, false},
+		{StatusPaid, StatusFulfilling, true},
+		{StatusPaid, StatusRefunded, true},
+		{StatusPaid, StatusCancelled, false},
+		{StatusFulfilling, StatusShipped, true},
+		{StatusFulfilling, StatusRefunded, true},
+		{StatusFulfilling, StatusPaid, false},
+		{StatusShipped, StatusDelivered, true},
+		{StatusShipped, StatusRefunded, false},
+		{StatusDelivered, StatusRefunded, true},
+		{StatusDelivered, StatusShipped, false},
+		{StatusCancelled, StatusPending, false},
+		{StatusCancelled, StatusPaid, false},
+		{StatusRefunded, StatusPaid, false},
+		{StatusPending, StatusPending, false},
+		{"unknown", StatusPaid, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.from+" to "+test.to, func(t *testing.T) {
+			if got := CanMove(test.from, test.to); got != test.want {
+				t.Errorf("CanMove(%q, %q) = %v, want %v", test.from, test.to, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTransition(t *testing.T) {
+	shirt, socks := uuid.New(), uuid.New()
+	lines := [][]driver.Value{
+		{uuid.NewString(), shirt.String(), int64(2)},
+		{uuid.NewString(), socks.String(), int64(1)},
+		{uuid.NewString(), shirt.String(), int64(1)},
+	}
+	failed := errors.New("hook failed")
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		changed int64 // rows the status update finds
+		hook    error
+		want    error
+		restock map[string]int64
+	}{
+		{name: "paid", from: StatusPending, to: StatusPaid, changed: 1},
+		{name: "cancelled before shipping", from: StatusPending, to: StatusCancelled, changed: 1,
+			restock: map[string]int64{shirt.String(): 3, socks.String(): 1}},
+		{name: "refunded before shipping", from: StatusFulfilling, to: StatusRefunded, changed: 1,
+			restock: map[string]int64{shirt.String(): 3, socks.String(): 1}},
+		{name: "refunded after delivery", from: StatusDelivered, to: StatusRefunded, changed: 1},
+		{name: "changed meanwhile", from: StatusPending, to: StatusPaid, changed: 0, want: ErrStaleOrder},
+		{name: "invalid", from: StatusShipped, to: StatusPending, want: ErrInvalidTransition},
+		{name: "hook fails", from: StatusPaid, to: StatusFulfilling, changed: 1, hook: failed, want: failed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var events int
+			restocked := map[string]int64{}
+			db := dbtest.Open(t, func(conn *dbtest.Conn, query string, args []driver.Value) (*dbtest.Result, error) {
+				switch {
+				case strings.HasPrefix(query, `UPDATE "order" SET`):
+					if !reflect.DeepEqual(args[len(args)-1], test.from) {
+						t.Errorf("status updated from %v, want %q", args[len(args)-1], test.from)
+					}
+					return &dbtest.Result{Affected: test.changed}, nil
+				case strings.HasPrefix(query, `INSERT INTO "order_event"`):
+					events++
+					return &dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{uuid.NewString()}}}, nil
+				case strings.HasPrefix(query, `SELECT * FROM "order_line"`):
+					return &dbtest.Result{Columns: []string{"id", "product", "quantity"}, Rows: lines}, nil
+				case strings.HasPrefix(query, `UPDATE "product" SET`):
+					restocked[args[len(args)-1].(string)] += args[0].(int64)
+					return &dbtest.Result{Affected: 1}, nil
+				}
+				t.Errorf("unexpected query %s", query)
+				return nil, nil
+			})
+
+			var called []string
+			defer func(registered []TransitionHook) { hooks = registered }(hooks)
+			hooks = nil
+			AfterTransition(func(tx *gorm.DB, order models.Order, from string) error {
+				called = append(called, from+" to "+order.Status)
+				return test.hook
+			})
+
+			by := uuid.New()
+			order := models.Order{Id: uuid.New(), Status: test.from}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return Transition(tx, &order, test.to, &by, "")
+			})
+			if !errors.Is(err, test.want) {
+				t.Fatalf("Transition() error = %v, want %v", err, test.want)
+			}
+
+			if test.want == ErrStaleOrder || test.want == ErrInvalidTransition {
+				if order.Status != test.from || len(order.History) > 0 {
+					t.Errorf("order moved to %q with history %v", order.Status, order.History)
+				}
+				if events > 0 || len(called) > 0 {
+					t.Errorf("recorded %d events and ran hooks %v", events, called)
+				}
+				return
+			}
+
+			if order.Status != test.to {
+				t.Errorf("status = %q, want %q", order.Status, test.to)
+			}
+			if len(order.History) != 1 || order.History[0].From != test.from || order.History[0].To != test.to || *order.History[0].ChangedBy != by {
+				t.Errorf("history = %+v", order.History)
+			}
+			if events != 1 {
+				t.Errorf("recorded %d events, want 1", events)
+			}
+			if want := []string{test.from + " to " + test.to}; !reflect.DeepEqual(called, want) {
+				t.Errorf("hooks ran for %v, want %v", called, want)
+			}
+			if test.restock == nil {
+				test.restock = map[string]int64{}
+			}
+			if !reflect.DeepEqual(restocked, test.restock) {
+				t.Errorf("restocked %v, want %v", restocked, test.restock)
+			}
+		})
+	}
+}
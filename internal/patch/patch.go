@@ -0,0 +1,351 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch document")
+	ErrTestFailed   = errors.New("patch test operation failed")
+)
+
+// A single RFC 6902 operation
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Error returned when a patch touches a field outside the allow-list
+type ForbiddenFieldError struct {
+	Field string
+}
+
+func (e *ForbiddenFieldError) Error() string {
+	return fmt.Sprintf("field %q cannot be modified", e.Field)
+}
+
+// Apply an RFC 7396 merge patch to a JSON document
+func Merge(doc, patch []byte, allowed []string) ([]byte, error) {
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	patchObject, ok := patchValue.(map[string]interface{})
+	if !ok {
+		// A non object patch replaces the whole entity, which is never allowed
+		return nil, ErrInvalidPatch
+	}
+	for field := range patchObject {
+		if !slices.Contains(allowed, field) {
+			return nil, &ForbiddenFieldError{Field: field}
+		}
+	}
+
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeValue(target, patchObject))
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// Apply an RFC 6902 JSON patch to a JSON document
+func Apply(doc, patch []byte, allowed []string) ([]byte, error) {
+	var operations []Operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	// Reads count too, copying a hidden field would leak it
+	for _, operation := range operations {
+		paths := []string{operation.Path}
+		if operation.Op == "move" || operation.Op == "copy" {
+			paths = append(paths, operation.From)
+		}
+
+		for _, path := range paths {
+			field, err := rootField(path)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(allowed, field) {
+				return nil, &ForbiddenFieldError{Field: field}
+			}
+		}
+	}
+
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	for _, operation := range operations {
+		var err error
+		if target, err = applyOperation(target, operation); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, operation Operation) (interface{}, error) {
+	var value interface{}
+	if len(operation.Value) > 0 {
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		return add(doc, operation.Path, value)
+	case "remove":
+		doc, _, err := remove(doc, operation.Path)
+		return doc, err
+	case "replace":
+		doc, _, err := remove(doc, operation.Path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, operation.Path, value)
+	case "move":
+		doc, moved, err := remove(doc, operation.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, operation.Path, moved)
+	case "copy":
+		copied, err := get(doc, operation.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, operation.Path, deepCopy(copied))
+	case "test":
+		current, err := get(doc, operation.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, operation.Op)
+}
+
+// First segment of a JSON pointer, the top level field it touches
+func rootField(path string) (string, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 {
+		// The whole document, which covers every field
+		return "/", nil
+	}
+	return tokens[0], nil
+}
+
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("%w: invalid path %q", ErrInvalidPatch, path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, "~1", "/")
+		tokens[i] = strings.ReplaceAll(token, "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(doc interface{}, path string) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, path)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, path)
+		}
+	}
+	return current, nil
+}
+
+func add(doc interface{}, path string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, pointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		updated := append(node[:index:index], append([]interface{}{value}, node[index:]...)...)
+		return replaceAt(doc, tokens[:len(tokens)-1], updated)
+	}
+	return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, path)
+}
+
+func remove(doc interface{}, path string) (interface{}, interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the document", ErrInvalidPatch)
+	}
+
+	parent, err := get(doc, pointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, path)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[index]
+		updated := append(node[:index:index], node[index+1:]...)
+		doc, err = replaceAt(doc, tokens[:len(tokens)-1], updated)
+		return doc, value, err
+	}
+	return nil, nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, path)
+}
+
+// Arrays change length so their new value has to be set on the parent
+func replaceAt(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, pointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+	}
+	return doc, nil
+}
+
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > length || (index == length && !appending) {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	return index, nil
+}
+
+func pointer(tokens []string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, "~", "~0")
+		escaped[i] = strings.ReplaceAll(token, "/", "~1")
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+func deepCopy(value interface{}) interface{} {
+	data, _ := json.Marshal(value)
+	var copied interface{}
+	json.Unmarshal(data, &copied)
+	return copied
+}
@@ -0,0 +1,199 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FakeName = "fake"
+
+	// Payment method tokens the fake gateway understands,
+	// any other token is authorized straight away
+	FakeDecline           = "tok_decline"
+	FakeInsufficientFunds = "tok_insufficient_funds"
+	FakeChallenge         = "tok_3ds"
+)
+
+var ErrUnknownPayment = errors.New("payment not found at the provider")
+
+// FakeProvider is a deterministic gateway for local development and tests.
+// Payments only live in memory, webhooks are signed like a real provider's.
+type FakeProvider struct {
+	secret []byte
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+	keys     map[string]Result
+}
+
+type fakePayment struct {
+	status   string
+	amount   int
+	captured int
+	refunded int
+}
+
+func NewFakeProvider(secret []byte) *FakeProvider {
+	return &FakeProvider{
+		secret:   secret,
+		payments: map[string]*fakePayment{},
+		keys:     map[string]Result{},
+	}
+}
+
+func (fp *FakeProvider) Name() string {
+	return FakeName
+}
+
+func (fp *FakeProvider) Authorize(ctx context.Context, request Request) (Result, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	// A repeated key gets the result of the first request
+	if result, ok := fp.keys[request.IdempotencyKey]; ok && request.IdempotencyKey != "" {
+		return result, nil
+	}
+
+	// References stay unique across restarts of the fake
+	reference := "fake_" + uuid.NewString()
+
+	payment := &fakePayment{status: StatusAuthorized, amount: request.Amount}
+	fp.payments[reference] = payment
+
+	result := Result{Reference: reference}
+	switch request.Method {
+	case FakeDecline:
+		payment.status = StatusDeclined
+		result.Reason = "card_declined"
+	case FakeInsufficientFunds:
+		payment.status = StatusDeclined
+		result.Reason = "insufficient_funds"
+	case FakeChallenge:
+		payment.status = StatusRequiresAction
+		result.ActionURL = "/api/payment/fake/challenge?reference=" + reference
+	}
+	result.Status = payment.status
+	if request.IdempotencyKey != "" {
+		fp.keys[request.IdempotencyKey] = result
+	}
+	return result, nil
+}
+
+func (fp *FakeProvider) Capture(ctx context.Context, reference string, amount int) (Result, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	payment, ok := fp.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	if payment.status != StatusAuthorized || amount > payment.amount {
+		return Result{Reference: reference, Status: payment.status, Reason: "not_capturable"}, nil
+	}
+
+	payment.status = StatusCaptured
+	payment.captured = amount
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (fp *FakeProvider) Void(ctx context.Context, reference string) (Result, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	payment, ok := fp.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	if payment.status == StatusAuthorized || payment.status == StatusRequiresAction {
+		payment.status = StatusVoided
+	}
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (fp *FakeProvider) Refund(ctx context.Context, reference string, amount int, key string) (Result, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if result, ok := fp.keys["refund_"+key]; ok && key != "" {
+		return result, nil
+	}
+
+	payment, ok := fp.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	if payment.status != StatusCaptured && payment.status != StatusRefunded ||
+		amount > payment.captured-payment.refunded {
+		return Result{Reference: reference, Status: payment.status, Reason: "not_refundable"}, nil
+	}
+
+	payment.status = StatusRefunded
+	payment.refunded += amount
+	result := Result{Reference: reference, Status: payment.status}
+	if key != "" {
+		fp.keys["refund_"+key] = result
+	}
+	return result, nil
+}
+
+func (fp *FakeProvider) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	var event Event
+	if !hmac.Equal([]byte(signature), []byte(fp.sign(payload))) {
+		return event, ErrInvalidSignature
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.Id == "" {
+		return event, ErrInvalidPayload
+	}
+	return event, nil
+}
+
+// Complete the challenge of a payment the way the shopper would,
+// returns the signed webhook the gateway sends about it
+func (fp *FakeProvider) Challenge(reference string, pass bool) ([]byte, string, error) {
+	fp.mu.Lock()
+	payment, ok := fp.payments[reference]
+	if !ok || payment.status != StatusRequiresAction {
+		fp.mu.Unlock()
+		return nil, "", ErrUnknownPayment
+	}
+
+	kind := EventAuthorized
+	payment.status = StatusAuthorized
+	if !pass {
+		kind = EventFailed
+		payment.status = StatusDeclined
+	}
+	amount := payment.amount
+	fp.mu.Unlock()
+
+	return fp.Webhook(kind, reference, amount)
+}
+
+// A signed webhook of the gateway, the same event always has the same id
+func (fp *FakeProvider) Webhook(kind string, reference string, amount int) ([]byte, string, error) {
+	payload, err := json.Marshal(Event{
+		Id:        fmt.Sprintf("evt_%s_%s", reference, kind),
+		Type:      kind,
+		Reference: reference,
+		Amount:    amount,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, fp.sign(payload), nil
+}
+
+func (fp *FakeProvider) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, fp.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
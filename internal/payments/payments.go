@@ -0,0 +1,443 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/orders"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotPayable    = errors.New("order is not awaiting payment")
+	ErrNotRefundable = errors.New("order has nothing left to refund")
+	ErrRefundFailed  = errors.New("provider refused the refund")
+)
+
+// Pay for a pending order. An authorized payment is captured at once and
+// the order is paid, a declined one leaves the order pending for another try.
+// The provider is called outside of any transaction, the order row is only
+// locked while its payment is recorded. Requests carry an idempotency key
+// of the order and its attempt, so concurrent tries end in one payment.
+func Pay(ctx context.Context, db *gorm.DB, provider PaymentProvider, order *models.Order, method string, by uuid.UUID) (models.Payment, error) {
+	var payment models.Payment
+
+	var attempts int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, order); err != nil {
+			return err
+		}
+		if order.Status != orders.StatusPending {
+			return ErrNotPayable
+		}
+		return tx.Model(&models.Payment{}).Where(`"order" = ?`, order.Id).Count(&attempts).Error
+	})
+	if err != nil {
+		return payment, err
+	}
+
+	result, err := provider.Authorize(ctx, Request{
+		Order:          order.Id,
+		Amount:         order.Total,
+		Currency:       order.Currency,
+		Method:         method,
+		IdempotencyKey: fmt.Sprintf("%s-%d", order.Id, attempts+1),
+	})
+	if err != nil {
+		return payment, err
+	}
+
+	payment = models.Payment{
+		Order:     order.Id,
+		Provider:  provider.Name(),
+		Reference: result.Reference,
+		Status:    result.Status,
+		Amount:    order.Total,
+		Currency:  order.Currency,
+		ActionURL: result.ActionURL,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, order); err != nil {
+			return err
+		}
+
+		// A concurrent try with the same key recorded the payment first
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&payment)
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected == 0 {
+			return tx.First(&payment, "provider = ? and reference = ?", payment.Provider, payment.Reference).Error
+		}
+		return nil
+	})
+	if err != nil || payment.Status != StatusAuthorized {
+		return payment, err
+	}
+
+	return payment, settle(ctx, db, provider, &payment, order, &by)
+}
+
+// Apply a provider webhook to its payment and order.
+// Every event is handled once, redeliveries are acknowledged and ignored,
+// but an authorization whose capture did not go through is tried again.
+// The provider is only called once the event is recorded.
+func HandleWebhook(ctx context.Context, db *gorm.DB, provider PaymentProvider, payload []byte, signature string) error {
+	event, err := provider.VerifyWebhook(payload, signature)
+	if err != nil {
+		return err
+	}
+
+	var payment models.Payment
+	var order models.Order
+	capture := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.PaymentEvent{
+			Provider:  provider.Name(),
+			Event:     event.Id,
+			Type:      event.Type,
+			Reference: event.Reference,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if err := tx.First(&payment, "provider = ? and reference = ?", provider.Name(), event.Reference).Error; err != nil {
+			return ErrUnknownPayment
+		}
+		order.Id = payment.Order
+		if err := lockOrder(tx, &order); err != nil {
+			return err
+		}
+		if err := lockPayment(tx, &payment); err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			capture = event.Type == EventAuthorized && payment.Status == StatusAuthorized
+			return nil
+		}
+
+		switch event.Type {
+		case EventAuthorized:
+			if payment.Status != StatusRequiresAction {
+				return nil
+			}
+			capture = true
+			return setStatus(tx, &payment, StatusAuthorized)
+
+		case EventCaptured:
+			return captureResult(tx, Result{Reference: payment.Reference, Status: StatusCaptured}, &payment, &order, nil)
+
+		case EventFailed:
+			if payment.Status == StatusCaptured || payment.Status == StatusRefunded {
+				return nil
+			}
+			return setStatus(tx, &payment, StatusDeclined)
+
+		case EventRefunded:
+			// The amount of a refund event is the total refunded so far
+			return refunded(tx, &payment, &order, max(payment.Refunded, event.Amount), nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if capture {
+		if err := settle(ctx, db, provider, &payment, &order, nil); err != nil && !errors.Is(err, ErrNotPayable) {
+			return err
+		}
+	}
+	return CompleteRefunds(ctx, db, order.Id)
+}
+
+// Amount of the captured payment of the order not refunded yet,
+// pending refunds count as refunded
+func Refundable(db *gorm.DB, order models.Order) (int, error) {
+	payment, err := captured(db, order)
+	if err != nil {
+		return 0, err
+	}
+	return refundable(db, payment)
+}
+
+// Refund the captured payment of the order, all of what is left when
+// amount is zero. The order is refunded once nothing is left. The refund
+// is held in its own transaction before the provider is asked.
+func Refund(ctx context.Context, db *gorm.DB, order *models.Order, amount int, by uuid.UUID) (models.PaymentRefund, error) {
+	var refund models.PaymentRefund
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		refund, err = StartRefund(tx, order, amount, &by)
+		return err
+	})
+	if err != nil {
+		return refund, err
+	}
+	return refund, CompleteRefunds(ctx, db, order.Id)
+}
+
+// Hold an amount of the captured payment of the order for a refund, all of
+// what is left when amount is zero. The order and payment rows are locked
+// so concurrent refunds cannot give back more than was captured. The
+// provider is asked by CompleteRefunds once the transaction is committed.
+func StartRefund(tx *gorm.DB, order *models.Order, amount int, by *uuid.UUID) (models.PaymentRefund, error) {
+	var refund models.PaymentRefund
+	if err := lockOrder(tx, order); err != nil {
+		return refund, err
+	}
+	payment, err := captured(tx, *order)
+	if err != nil {
+		return refund, err
+	}
+	if err := lockPayment(tx, &payment); err != nil {
+		return refund, err
+	}
+	return holdRefund(tx, payment, amount, by)
+}
+
+func holdRefund(tx *gorm.DB, payment models.Payment, amount int, by *uuid.UUID) (models.PaymentRefund, error) {
+	refund := models.PaymentRefund{
+		Payment:   payment.Id,
+		Order:     payment.Order,
+		Status:    RefundPending,
+		CreatedBy: by,
+	}
+
+	left, err := refundable(tx, payment)
+	if err != nil {
+		return refund, err
+	}
+	if amount == 0 {
+		amount = left
+	}
+	if amount <= 0 || amount > left {
+		return refund, ErrNotRefundable
+	}
+
+	refund.Amount = amount
+	return refund, tx.Create(&refund).Error
+}
+
+// Ask the provider for the pending refunds of the order and record how
+// they went, outside of any transaction. A refund the provider did not
+// answer stays pending, it is tried again with the same idempotency key.
+func CompleteRefunds(ctx context.Context, db *gorm.DB, orderId uuid.UUID) error {
+	var pending []models.PaymentRefund
+	if err := db.Where(`"order" = ? and status = ?`, orderId, RefundPending).Order("created_at").Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for i := range pending {
+		if err := completeRefund(ctx, db, &pending[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func completeRefund(ctx context.Context, db *gorm.DB, refund *models.PaymentRefund) error {
+	var payment models.Payment
+	if err := db.First(&payment, "id = ?", refund.Payment).Error; err != nil {
+		return err
+	}
+	provider, err := Lookup(payment.Provider)
+	if err != nil {
+		return err
+	}
+
+	result, err := provider.Refund(ctx, payment.Reference, refund.Amount, refund.Id.String())
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		order := models.Order{Id: refund.Order}
+		if err := lockOrder(tx, &order); err != nil {
+			return err
+		}
+		if err := lockPayment(tx, &payment); err != nil {
+			return err
+		}
+
+		// Another request completed it first
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(refund, "id = ?", refund.Id).Error; err != nil {
+			return err
+		}
+		if refund.Status != RefundPending {
+			return nil
+		}
+
+		if result.Status != StatusRefunded {
+			failed = true
+			refund.Status = RefundFailed
+			return tx.Model(refund).Update("status", refund.Status).Error
+		}
+
+		refund.Status = StatusRefunded
+		if err := tx.Model(refund).Update("status", refund.Status).Error; err != nil {
+			return err
+		}
+
+		// A refund webhook may have counted this refund already
+		var total int
+		if err := tx.Model(&models.PaymentRefund{}).
+			Where("payment = ? and status = ?", payment.Id, StatusRefunded).
+			Select("coalesce(sum(amount), 0)").
+			Scan(&total).Error; err != nil {
+			return err
+		}
+		return refunded(tx, &payment, &order, max(payment.Refunded, total), refund.CreatedBy)
+	})
+	if err != nil {
+		return err
+	}
+	if failed {
+		return ErrRefundFailed
+	}
+	return nil
+}
+
+// Release the payments of a cancelled order, outside of any transaction.
+// Payments authorized later are voided when their authorization arrives.
+func VoidPending(ctx context.Context, db *gorm.DB, order models.Order) error {
+	var pending []models.Payment
+	if err := db.Where(`"order" = ? and status in ?`, order.Id, []string{StatusAuthorized, StatusRequiresAction}).
+		Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for i := range pending {
+		provider, err := Lookup(pending[i].Provider)
+		if err != nil {
+			return err
+		}
+		result, err := provider.Void(ctx, pending[i].Reference)
+		if err != nil {
+			return err
+		}
+		if err := setStatus(db, &pending[i], result.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Capture an authorized payment while its order waits for it, or release
+// it once the order moved on. Provider calls are made outside of any
+// transaction, a capture that lands on a cancelled order is refunded.
+func settle(ctx context.Context, db *gorm.DB, provider PaymentProvider, payment *models.Payment, order *models.Order, by *uuid.UUID) error {
+	if order.Status != orders.StatusPending {
+		voided, err := provider.Void(ctx, payment.Reference)
+		if err != nil {
+			return err
+		}
+		if err := setStatus(db, payment, voided.Status); err != nil {
+			return err
+		}
+		return ErrNotPayable
+	}
+
+	result, err := provider.Capture(ctx, payment.Reference, payment.Amount)
+	if err != nil {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, order); err != nil {
+			return err
+		}
+		if err := lockPayment(tx, payment); err != nil {
+			return err
+		}
+		return captureResult(tx, result, payment, order, by)
+	})
+	if err != nil {
+		return err
+	}
+	return CompleteRefunds(ctx, db, order.Id)
+}
+
+func captured(db *gorm.DB, order models.Order) (models.Payment, error) {
+	var payment models.Payment
+	if err := db.Where(`"order" = ? and status in ?`, order.Id, []string{StatusCaptured, StatusRefunded}).
+		Order("created_at desc").
+		First(&payment).Error; err != nil {
+		return payment, ErrNotRefundable
+	}
+	return payment, nil
+}
+
+// Record the capture of the payment. The order is paid, unless it was
+// cancelled meanwhile, then the whole capture is held for a refund.
+func captureResult(tx *gorm.DB, result Result, payment *models.Payment, order *models.Order, by *uuid.UUID) error {
+	if payment.Status == StatusCaptured || payment.Status == StatusRefunded {
+		return nil
+	}
+	if err := setStatus(tx, payment, result.Status); err != nil {
+		return err
+	}
+	if payment.Status != StatusCaptured {
+		return nil
+	}
+
+	if order.Status == orders.StatusCancelled {
+		_, err := holdRefund(tx, *payment, 0, by)
+		return err
+	}
+	return markPaid(tx, payment, order, by)
+}
+
+func markPaid(tx *gorm.DB, payment *models.Payment, order *models.Order, by *uuid.UUID) error {
+	if order.Status != orders.StatusPending {
+		return nil
+	}
+	return orders.Transition(tx, order, orders.StatusPaid, by, "Payment "+payment.Reference)
+}
+
+func refunded(tx *gorm.DB, payment *models.Payment, order *models.Order, total int, by *uuid.UUID) error {
+	payment.Refunded = total
+	payment.Status = StatusRefunded
+	if err := tx.Model(payment).Updates(map[string]interface{}{
+		"status":   payment.Status,
+		"refunded": payment.Refunded,
+	}).Error; err != nil {
+		return err
+	}
+
+	if payment.Refunded < payment.Amount || !orders.CanMove(order.Status, orders.StatusRefunded) {
+		return nil
+	}
+	return orders.Transition(tx, order, orders.StatusRefunded, by, "Refund "+payment.Reference)
+}
+
+// Amount of the payment neither refunded nor held by a pending refund
+func refundable(db *gorm.DB, payment models.Payment) (int, error) {
+	var pending int
+	if err := db.Model(&models.PaymentRefund{}).
+		Where("payment = ? and status = ?", payment.Id, RefundPending).
+		Select("coalesce(sum(amount), 0)").
+		Scan(&pending).Error; err != nil {
+		return 0, err
+	}
+	return payment.Amount - payment.Refunded - pending, nil
+}
+
+func lockOrder(tx *gorm.DB, order *models.Order) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, "id = ?", order.Id).Error
+}
+
+func lockPayment(tx *gorm.DB, payment *models.Payment) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payment, "id = ?", payment.Id).Error
+}
+
+func setStatus(tx *gorm.DB, payment *models.Payment, status string) error {
+	payment.Status = status
+	return tx.Model(payment).Update("status", status).Error
+}
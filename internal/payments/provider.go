@@ -0,0 +1,123 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Payment statuses, as reported by providers
+const (
+	StatusAuthorized     = "authorized"
+	StatusRequiresAction = "requires_action"
+	StatusCaptured       = "captured"
+	StatusVoided         = "voided"
+	StatusRefunded       = "refunded"
+	StatusDeclined       = "declined"
+)
+
+// Refund statuses, a completed refund has StatusRefunded
+const (
+	RefundPending = "pending"
+	RefundFailed  = "failed"
+)
+
+// Webhook event types
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventFailed     = "payment.failed"
+	EventRefunded   = "payment.refunded"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("payment provider not found")
+	ErrNoProvider       = errors.New("PAYMENT_PROVIDER is not set")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidPayload   = errors.New("invalid webhook payload")
+
+	providers = map[string]PaymentProvider{}
+	mu        sync.RWMutex
+)
+
+// What a payment provider has to offer, amounts are in minor units
+type PaymentProvider interface {
+	Name() string
+
+	// Reserve the amount on the payment method, the shopper may
+	// have to complete a challenge before it is authorized
+	Authorize(ctx context.Context, request Request) (Result, error)
+
+	// Collect an authorized amount
+	Capture(ctx context.Context, reference string, amount int) (Result, error)
+
+	// Release an authorization that will not be captured
+	Void(ctx context.Context, reference string) (Result, error)
+
+	// Give back some or all of a captured amount,
+	// refunds with the same key are made once
+	Refund(ctx context.Context, reference string, amount int, key string) (Result, error)
+
+	// Check the webhook came from the provider and read its event
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+type Request struct {
+	Order    uuid.UUID
+	Amount   int
+	Currency string
+
+	// Token of the payment method, as handed out by the provider
+	Method string
+
+	// Requests with the same key are authorized once by the provider
+	IdempotencyKey string
+}
+
+type Result struct {
+	Reference string
+	Status    string
+
+	// Where the shopper completes a challenge, for StatusRequiresAction
+	ActionURL string
+
+	// Why the payment was declined
+	Reason string
+}
+
+type Event struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	Reference string `json:"reference"`
+	Amount    int    `json:"amount"`
+}
+
+func Register(provider PaymentProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers[provider.Name()] = provider
+}
+
+func Lookup(name string) (PaymentProvider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Provider new payments go to, named by PAYMENT_PROVIDER
+func Default() (PaymentProvider, error) {
+	name := os.Getenv("PAYMENT_PROVIDER")
+	if name == "" {
+		return nil, ErrNoProvider
+	}
+	return Lookup(name)
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+// Reconciler asks the providers again for the refunds they did not answer.
+// A refund stays pending when the provider could not be reached after its
+// amount was held, retries carry the same key so it is only made once.
+type Reconciler struct {
+	db       database.Service
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+var reconcilerInstance *Reconciler
+
+func NewReconciler(db database.Service, interval time.Duration) *Reconciler {
+
+	if reconcilerInstance != nil {
+		return reconcilerInstance
+	}
+
+	reconcilerInstance = &Reconciler{
+		db:       db,
+		interval: interval,
+	}
+
+	return reconcilerInstance
+}
+
+// Run the reconciler in the background until the context is cancelled
+func (r *Reconciler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := r.Run(ctx); err != nil {
+				log.Printf("Refund reconciler failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Complete the refunds pending for longer than an interval,
+// the ones still being asked for are left to their request
+func (r *Reconciler) Run(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pending []models.PaymentRefund
+	if err := r.db.UseGorm().
+		Where("status = ? and created_at <= ?", RefundPending, time.Now().Add(-r.interval)).
+		Order("created_at").
+		Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for i := range pending {
+		if err := completeRefund(ctx, r.db.UseGorm(), &pending[i]); err != nil {
+			log.Printf("Unable to complete refund %s: %v", pending[i].Id, err)
+		}
+	}
+	return nil
+}
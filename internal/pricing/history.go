@@ -0,0 +1,144 @@
+package pricing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	SourceUpdate    = "update"
+	SourceImport    = "import"
+	SourcePromotion = "promotion"
+
+	// Reference period for the previous lowest price of a product on sale
+	LowestPriceWindow = 30 * 24 * time.Hour
+)
+
+// Price the customer paid at the time of the entry
+func SellingPrice(entry models.PriceHistory) int {
+	if !entry.OnSale {
+		return entry.Price
+	}
+	if entry.SalePrice > 0 {
+		return entry.SalePrice
+	}
+	return entry.Price - (entry.Price*entry.SalePercent+50)/100
+}
+
+// Price the customer pays for the product right now
+func CurrentPrice(product models.Product) int {
+	return SellingPrice(models.PriceHistory{
+		Price:       product.Price,
+		OnSale:      product.OnSale,
+		SalePrice:   product.SalePrice,
+		SalePercent: product.SalePercent,
+	})
+}
+
+// Store the price of the product if it changed since the last entry
+func Record(tx *gorm.DB, product models.Product, source string) error {
+	var last models.PriceHistory
+	err := tx.Where("product = ?", product.Id).Order("created_at desc").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err == nil &&
+		last.Price == product.Price &&
+		last.OnSale == product.OnSale &&
+		last.SalePrice == product.SalePrice &&
+		last.SalePercent == product.SalePercent {
+		return nil
+	}
+
+	return tx.Create(&models.PriceHistory{
+		Product:     product.Id,
+		Price:       product.Price,
+		OnSale:      product.OnSale,
+		SalePrice:   product.SalePrice,
+		SalePercent: product.SalePercent,
+		Source:      source,
+		ChangedBy:   product.UpdateBy,
+	}).Error
+}
+
+// Full price history of a product, newest first
+func History(db *gorm.DB, productId uuid.UUID) ([]models.PriceHistory, error) {
+	var entries []models.PriceHistory
+	err := db.Where("product = ?", productId).Order("created_at desc").Find(&entries).Error
+	return entries, err
+}
+
+// Fill LowestPrice30d on every product that is on sale
+func ApplyLowestPrices(db *gorm.DB, items []models.Product) error {
+	var ids []uuid.UUID
+	for _, item := range items {
+		if item.OnSale {
+			ids = append(ids, item.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var entries []models.PriceHistory
+	if err := db.Where("product in ?", ids).Order("created_at asc").Find(&entries).Error; err != nil {
+		return err
+	}
+
+	byProduct := make(map[uuid.UUID][]models.PriceHistory, len(ids))
+	for _, entry := range entries {
+		byProduct[entry.Product] = append(byProduct[entry.Product], entry)
+	}
+
+	for i := range items {
+		if !items[i].OnSale {
+			continue
+		}
+		if lowest, ok := LowestPrice(byProduct[items[i].Id]); ok {
+			items[i].LowestPrice30d = &lowest
+		}
+	}
+
+	return nil
+}
+
+// Lowest selling price in the 30 days before the current sale started.
+// entries must be ordered oldest first, returns false if there is no
+// price known before the sale.
+func LowestPrice(entries []models.PriceHistory) (int, bool) {
+	// Find where the current run of sale entries begins
+	start := len(entries)
+	for start > 0 && entries[start-1].OnSale {
+		start--
+	}
+	if start == 0 {
+		return 0, false
+	}
+
+	// Sale without a recorded entry yet, it started now
+	saleStart := time.Now()
+	if start < len(entries) {
+		saleStart = entries[start].CreatedAt
+	}
+	windowStart := saleStart.Add(-LowestPriceWindow)
+
+	lowest, found := 0, false
+	for i := 0; i < start; i++ {
+		// An entry counts if it was still the price at some point in the window
+		if i+1 < len(entries) && !entries[i+1].CreatedAt.After(windowStart) {
+			continue
+		}
+
+		price := SellingPrice(entries[i])
+		if !found || price < lowest {
+			lowest, found = price, true
+		}
+	}
+
+	return lowest, found
+}
@@ -0,0 +1,187 @@
+package promotions
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+)
+
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+	TypeBuyXGetY   = "buy_x_get_y"
+
+	TargetProduct  = "product"
+	TargetBrand    = "brand"
+	TargetCategory = "category"
+)
+
+// Price of a product after promotions
+type Price struct {
+	Price      int         `json:"price"`
+	Promotions []uuid.UUID `json:"promotions"`
+}
+
+// Validate a promotion before it is stored
+func Validate(promotion models.Promotion) error {
+	switch promotion.Type {
+	case TypePercentage:
+		if promotion.Value <= 0 || promotion.Value > 100 {
+			return errors.New("percentage must be between 1 and 100")
+		}
+	case TypeFixed:
+		if promotion.Value <= 0 {
+			return errors.New("fixed amount must be positive")
+		}
+	case TypeBuyXGetY:
+		if promotion.BuyQuantity <= 0 || promotion.GetQuantity <= 0 {
+			return errors.New("buy and get quantities must be positive")
+		}
+	default:
+		return errors.New("unknown promotion type")
+	}
+
+	switch promotion.TargetType {
+	case TargetProduct, TargetBrand, TargetCategory:
+	default:
+		return errors.New("unknown promotion target")
+	}
+
+	if len(promotion.Targets) == 0 {
+		return errors.New("promotion requires at least one target")
+	}
+
+	if !promotion.EndsAt.IsZero() && !promotion.EndsAt.After(promotion.StartsAt) {
+		return errors.New("promotion must end after it starts")
+	}
+
+	return nil
+}
+
+// Check if the promotion window contains the given time.
+// A zero EndsAt means the promotion runs until it is deprecated.
+func Running(promotion models.Promotion, now time.Time) bool {
+	if promotion.Deprecated || now.Before(promotion.StartsAt) {
+		return false
+	}
+	return promotion.EndsAt.IsZero() || now.Before(promotion.EndsAt)
+}
+
+// Check if the promotion targets the product
+func Applies(promotion models.Promotion, product models.Product) bool {
+	switch promotion.TargetType {
+	case TargetProduct:
+		return slices.Contains(promotion.Targets, product.Id.String())
+	case TargetBrand:
+		return slices.Contains(promotion.Targets, product.Brand.String())
+	case TargetCategory:
+		for _, category := range product.Categories {
+			if slices.Contains(promotion.Targets, category) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Promotions applicable to the product ordered by priority, highest first
+func ForProduct(product models.Product, promotions []models.Promotion) []models.Promotion {
+	var matched []models.Promotion
+	for _, promotion := range promotions {
+		if promotion.Active && Applies(promotion, product) {
+			matched = append(matched, promotion)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Priority > matched[j].Priority
+	})
+	return matched
+}
+
+// Compute the unit price of a product.
+// The highest priority promotion always applies, lower priority promotions
+// are only added while both the previous and the next one are stackable.
+// A sale set by hand is kept when it is lower than the promotions give.
+// Buy X get Y promotions do not change the unit price, they are listed so
+// the cart can apply them per quantity.
+func EffectivePrice(product models.Product, promotions []models.Promotion) Price {
+	price := Price{
+		Price:      product.Price,
+		Promotions: []uuid.UUID{},
+	}
+
+	applied := 0
+	stacking := true
+	for _, promotion := range ForProduct(product, promotions) {
+		if promotion.Type == TypeBuyXGetY {
+			continue
+		}
+		if applied > 0 && (!stacking || !promotion.Stackable) {
+			break
+		}
+
+		price.Price = Discount(price.Price, promotion)
+		price.Promotions = append(price.Promotions, promotion.Id)
+		stacking = promotion.Stackable
+		applied++
+	}
+
+	if product.OnSale && !product.PromoSale {
+		if sale := pricing.CurrentPrice(product); sale < price.Price {
+			price.Price = sale
+			price.Promotions = []uuid.UUID{}
+		}
+	}
+
+	if promotion, ok := BuyXGetY(product, promotions); ok {
+		price.Promotions = append(price.Promotions, promotion.Id)
+	}
+	return price
+}
+
+// Apply a promotion to a unit price, percentages are rounded half up
+func Discount(price int, promotion models.Promotion) int {
+	switch promotion.Type {
+	case TypePercentage:
+		return price - (price*promotion.Value+50)/100
+	case TypeFixed:
+		return max(price-promotion.Value, 0)
+	}
+	return price
+}
+
+// Number of free units for a buy X get Y promotion on the given quantity
+func FreeUnits(promotion models.Promotion, quantity int) int {
+	if promotion.Type != TypeBuyXGetY {
+		return 0
+	}
+
+	group := promotion.BuyQuantity + promotion.GetQuantity
+	return (quantity / group) * promotion.GetQuantity
+}
+
+// The buy X get Y promotion of the product, only the highest priority one applies
+func BuyXGetY(product models.Product, promotions []models.Promotion) (models.Promotion, bool) {
+	for _, promotion := range ForProduct(product, promotions) {
+		if promotion.Type == TypeBuyXGetY {
+			return promotion, true
+		}
+	}
+	return models.Promotion{}, false
+}
+
+// Amount the buy X get Y promotion of the product takes off quantity units
+// bought at the unit price
+func QuantityDiscount(product models.Product, promotions []models.Promotion, quantity int, unitPrice int) int {
+	promotion, ok := BuyXGetY(product, promotions)
+	if !ok {
+		return 0
+	}
+	return FreeUnits(promotion, quantity) * unitPrice
+}
@@ -0,0 +1,88 @@
+package promotions
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+func TestEffectivePrice(t *testing.T) {
+	product := models.Product{Id: uuid.New(), Brand: uuid.New(), Price: 10000}
+	target := func(promotion models.Promotion) models.Promotion {
+		promotion.Id = uuid.New()
+		promotion.Active = true
+		promotion.TargetType = TargetProduct
+		promotion.Targets = []string{product.Id.String()}
+		return promotion
+	}
+
+	tenOff := target(models.Promotion{Type: TypePercentage, Value: 10, Priority: 3, Stackable: true})
+	fiveOff := target(models.Promotion{Type: TypeFixed, Value: 500, Priority: 2, Stackable: true})
+	exclusive := target(models.Promotion{Type: TypePercentage, Value: 20, Priority: 1})
+	clearance := target(models.Promotion{Type: TypePercentage, Value: 40, Priority: 5})
+	threeForTwo := target(models.Promotion{Type: TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Priority: 9})
+	other := models.Promotion{Id: uuid.New(), Active: true, Type: TypePercentage, Value: 50, TargetType: TargetProduct, Targets: []string{uuid.NewString()}}
+
+	manual := product
+	manual.OnSale, manual.SalePrice = true, 7000
+	promoSale := product
+	promoSale.OnSale, promoSale.SalePrice, promoSale.PromoSale = true, 7000, true
+
+	tests := []struct {
+		name       string
+		product    models.Product
+		promotions []models.Promotion
+		price      int
+		applied    []uuid.UUID
+	}{
+		{"no promotion", product, []models.Promotion{other}, 10000, []uuid.UUID{}},
+		{"stackable promotions add up", product, []models.Promotion{fiveOff, tenOff}, 8500, []uuid.UUID{tenOff.Id, fiveOff.Id}},
+		{"non stackable promotion ends the stack", product, []models.Promotion{exclusive, fiveOff, tenOff}, 8500, []uuid.UUID{tenOff.Id, fiveOff.Id}},
+		{"buy x get y leaves the unit price to the others", product, []models.Promotion{threeForTwo, exclusive}, 8000, []uuid.UUID{exclusive.Id, threeForTwo.Id}},
+		{"manual sale lower than promotions", manual, []models.Promotion{tenOff}, 7000, []uuid.UUID{}},
+		{"promotions lower than manual sale", manual, []models.Promotion{tenOff, clearance}, 6000, []uuid.UUID{clearance.Id}},
+		{"manual sale without promotions", manual, nil, 7000, []uuid.UUID{}},
+		{"sale set by a promotion is not a manual one", promoSale, []models.Promotion{tenOff}, 9000, []uuid.UUID{tenOff.Id}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := EffectivePrice(test.product, test.promotions)
+			if got.Price != test.price {
+				t.Errorf("price = %d, want %d", got.Price, test.price)
+			}
+			if !reflect.DeepEqual(got.Promotions, test.applied) {
+				t.Errorf("promotions = %v, want %v", got.Promotions, test.applied)
+			}
+		})
+	}
+}
+
+func TestQuantityDiscount(t *testing.T) {
+	product := models.Product{Id: uuid.New(), Price: 1000}
+	threeForTwo := models.Promotion{Active: true, Type: TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, TargetType: TargetProduct, Targets: []string{product.Id.String()}, Priority: 1}
+	twoForOne := models.Promotion{Active: true, Type: TypeBuyXGetY, BuyQuantity: 1, GetQuantity: 1, TargetType: TargetProduct, Targets: []string{product.Id.String()}}
+
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		quantity   int
+		want       int
+	}{
+		{"no promotion", nil, 3, 0},
+		{"short of the group", []models.Promotion{threeForTwo}, 2, 0},
+		{"one group", []models.Promotion{threeForTwo}, 3, 1000},
+		{"groups and a rest", []models.Promotion{threeForTwo}, 8, 2000},
+		{"highest priority only", []models.Promotion{twoForOne, threeForTwo}, 6, 2000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := QuantityDiscount(product, test.promotions, test.quantity, product.Price); got != test.want {
+				t.Errorf("QuantityDiscount(%d) = %d, want %d", test.quantity, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package promotions
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"github.com/kevinhartarto/market-be/internal/revisions"
+	"gorm.io/gorm"
+)
+
+// Scheduler activates and deactivates promotions as their window opens and closes,
+// then keeps the sale fields of products and brands in line with the active promotions.
+// Sales set by hand are left alone, only the ones marked promo_sale are managed here.
+type Scheduler struct {
+	db        database.Service
+	interval  time.Duration
+	mu        sync.Mutex
+	afterSync []func()
+}
+
+var schedulerInstance *Scheduler
+
+func NewScheduler(db database.Service, interval time.Duration) *Scheduler {
+
+	if schedulerInstance != nil {
+		return schedulerInstance
+	}
+
+	schedulerInstance = &Scheduler{
+		db:       db,
+		interval: interval,
+	}
+
+	return schedulerInstance
+}
+
+// Register a function called every time products and brands were synced
+func (s *Scheduler) AfterSync(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.afterSync = append(s.afterSync, fn)
+}
+
+// Run the scheduler in the background until the context is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		if err := s.Sync(); err != nil {
+			log.Printf("Promotion sync failed: %v", err)
+		}
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := s.Run(); err != nil {
+				log.Printf("Promotion scheduler failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Update promotion states, products and brands are only synced when a state changed
+func (s *Scheduler) Run() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed, err := s.updateStates()
+	if err != nil || !changed {
+		return err
+	}
+	return s.sync()
+}
+
+// Force products and brands to be recomputed, used after a promotion is edited
+func (s *Scheduler) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.updateStates(); err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *Scheduler) updateStates() (bool, error) {
+	var promotions []models.Promotion
+	if err := s.db.UseGorm().Find(&promotions).Error; err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	changed := false
+	for _, promotion := range promotions {
+		running := Running(promotion, now)
+		if running == promotion.Active {
+			continue
+		}
+
+		if err := s.db.UseGorm().Model(&promotion).Update("active", running).Error; err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+func (s *Scheduler) sync() error {
+	if err := s.syncCatalog(); err != nil {
+		return err
+	}
+
+	for _, fn := range s.afterSync {
+		fn()
+	}
+	return nil
+}
+
+func (s *Scheduler) syncCatalog() error {
+	return s.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var promotions []models.Promotion
+		if err := tx.Where("active").Find(&promotions).Error; err != nil {
+			return err
+		}
+
+		targets := map[string][]string{
+			TargetProduct:  {},
+			TargetBrand:    {},
+			TargetCategory: {},
+		}
+		for _, promotion := range promotions {
+			targets[promotion.TargetType] = append(targets[promotion.TargetType], promotion.Targets...)
+		}
+
+		// Only products a promotion targets now or put on sale before
+		var products []models.Product
+		if err := tx.Where("promo_sale").
+			Or("id::text in ?", targets[TargetProduct]).
+			Or("brand::text in ?", targets[TargetBrand]).
+			Or("exists (select 1 from json_array_elements_text(categories) c where c in ?)", targets[TargetCategory]).
+			Find(&products).Error; err != nil {
+			return err
+		}
+
+		for _, product := range products {
+			// A sale set by hand wins over promotions
+			if product.OnSale && !product.PromoSale {
+				continue
+			}
+
+			price := EffectivePrice(product, promotions)
+
+			onSale := price.Price < product.Price
+			salePrice, salePercent := 0, 0
+			if onSale {
+				salePrice = price.Price
+				salePercent = (product.Price - price.Price) * 100 / product.Price
+			}
+
+			if product.OnSale == onSale && product.SalePrice == salePrice && product.SalePercent == salePercent {
+				continue
+			}
+
+			if err := tx.Model(&product).Updates(map[string]interface{}{
+				"on_sale":      onSale,
+				"sale_price":   salePrice,
+				"sale_percent": salePercent,
+				"promo_sale":   onSale,
+				"version":      gorm.Expr("version + 1"),
+			}).Error; err != nil {
+				return err
+			}
+
+			product.OnSale = onSale
+			product.SalePrice = salePrice
+			product.SalePercent = salePercent
+			product.PromoSale = onSale
+			product.Version++
+			if err := revisions.Record(tx, &product, revisions.SourcePromotion); err != nil {
+				return err
+			}
+			if err := pricing.Record(tx, product, pricing.SourcePromotion); err != nil {
+				return err
+			}
+		}
+
+		var brands []models.Brand
+		if err := tx.Where("promo_sale").Or("id::text in ?", targets[TargetBrand]).Find(&brands).Error; err != nil {
+			return err
+		}
+
+		for _, brand := range brands {
+			if brand.OnSale && !brand.PromoSale {
+				continue
+			}
+
+			onSale := false
+			for _, promotion := range promotions {
+				if promotion.TargetType == TargetBrand && Applies(promotion, models.Product{Brand: brand.Id}) {
+					onSale = true
+					break
+				}
+			}
+
+			if brand.OnSale == onSale {
+				continue
+			}
+
+			if err := tx.Model(&brand).Updates(map[string]interface{}{
+				"on_sale":    onSale,
+				"promo_sale": onSale,
+				"version":    gorm.Expr("version + 1"),
+			}).Error; err != nil {
+				return err
+			}
+
+			brand.OnSale = onSale
+			brand.PromoSale = onSale
+			brand.Version++
+			if err := revisions.Record(tx, &brand, revisions.SourcePromotion); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
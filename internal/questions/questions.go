@@ -0,0 +1,119 @@
+package questions
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/notifications"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const MaxBodyLength = 2000
+
+var (
+	ErrEmptyBody    = errors.New("text is required")
+	ErrBodyTooLong  = errors.New("text is too long")
+	ErrNotVerified  = errors.New("only verified accounts can ask questions")
+	ErrNotAnswerer  = errors.New("only the product owner or an admin can answer")
+	ErrAlreadyVoted = errors.New("answer was already upvoted by this account")
+	ErrOwnAnswer    = errors.New("cannot upvote your own answer")
+)
+
+func ValidateBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", ErrEmptyBody
+	}
+	if len(body) > MaxBodyLength {
+		return "", ErrBodyTooLong
+	}
+	return body, nil
+}
+
+// The owner of the product or of its brand, or an admin, may answer
+func CanAnswer(product models.Product, brand models.Brand, account models.Account, role models.Role) bool {
+	return role.IsAdmin || product.Owner == account.Id || (brand.Id == product.Brand && brand.Owner == account.Id)
+}
+
+// Store the question and let the product owner know about it
+func Ask(tx *gorm.DB, product models.Product, question *models.Question) error {
+	question.Id = uuid.Nil
+	question.Product = product.Id
+	question.Answered = false
+	if err := tx.Create(question).Error; err != nil {
+		return err
+	}
+
+	if product.Owner == uuid.Nil || product.Owner == question.Account {
+		return nil
+	}
+	return notifications.Notify(tx, product.Owner, notifications.TypeQuestion,
+		"New question about "+product.Name+": "+question.Body, question.Id)
+}
+
+// Store the answer and mark the question as answered
+func Reply(tx *gorm.DB, question models.Question, answer *models.Answer) error {
+	answer.Id = uuid.Nil
+	answer.Question = question.Id
+	answer.Upvotes = 0
+	if err := tx.Create(answer).Error; err != nil {
+		return err
+	}
+	return tx.Model(&question).Update("answered", true).Error
+}
+
+// Count an upvote, each account votes once per answer
+func Upvote(tx *gorm.DB, answer models.Answer, account uuid.UUID) error {
+	if answer.Account == account {
+		return ErrOwnAnswer
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.AnswerVote{
+		Answer:  answer.Id,
+		Account: account,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyVoted
+	}
+
+	return tx.Model(&answer).Update("upvotes", gorm.Expr("upvotes + 1")).Error
+}
+
+// Questions of a product with their answers, newest questions first
+func ForProduct(db *gorm.DB, product uuid.UUID) ([]models.Question, error) {
+	var list []models.Question
+	if err := db.Where("product = ?", product).Order("created_at desc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	ids := make([]uuid.UUID, len(list))
+	for i, question := range list {
+		ids[i] = question.Id
+	}
+
+	var answers []models.Answer
+	if err := db.Where("question in ?", ids).Order("upvotes desc, created_at").Find(&answers).Error; err != nil {
+		return nil, err
+	}
+
+	byQuestion := map[uuid.UUID][]models.Answer{}
+	for _, answer := range answers {
+		byQuestion[answer.Question] = append(byQuestion[answer.Question], answer)
+	}
+	for i := range list {
+		list[i].Answers = byQuestion[list[i].Id]
+		if list[i].Answers == nil {
+			list[i].Answers = []models.Answer{}
+		}
+	}
+	return list, nil
+}
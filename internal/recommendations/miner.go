@@ -0,0 +1,108 @@
+package recommendations
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/redis/go-redis/v9"
+)
+
+// Claimed by the instance mining a given night
+const minerLockPrefix = "recommendations-mined:"
+
+// Miner rebuilds the associations once a day at the configured hour.
+// Every instance runs one, the first to claim the night in Redis mines.
+type Miner struct {
+	db        database.Service
+	redis     *redis.Client
+	hour      int
+	mu        sync.Mutex
+	afterMine []func()
+}
+
+var minerInstance *Miner
+
+func NewMiner(db database.Service, redis *redis.Client, hour int) *Miner {
+
+	if minerInstance != nil {
+		return minerInstance
+	}
+
+	minerInstance = &Miner{
+		db:    db,
+		redis: redis,
+		hour:  hour,
+	}
+
+	return minerInstance
+}
+
+// Register a function called every time the associations were rebuilt
+func (m *Miner) AfterMine(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.afterMine = append(m.afterMine, fn)
+}
+
+// Run the miner in the background until the context is cancelled
+func (m *Miner) Start(ctx context.Context) {
+	go func() {
+		for {
+			next := m.next(time.Now())
+			timer := time.NewTimer(time.Until(next))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			if !m.claim(ctx, next) {
+				continue
+			}
+			if err := m.Run(); err != nil {
+				log.Printf("Recommendation mining failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Rebuild the associations now
+func (m *Miner) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := Mine(m.db.UseGorm()); err != nil {
+		return err
+	}
+
+	for _, fn := range m.afterMine {
+		fn()
+	}
+	return nil
+}
+
+// Next time the configured hour is reached
+func (m *Miner) next(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), m.hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Claim the run of the night, false when another instance already did.
+// Without Redis nobody can tell, the night is skipped.
+func (m *Miner) claim(ctx context.Context, night time.Time) bool {
+	claimed, err := m.redis.SetNX(ctx, minerLockPrefix+night.Format(time.DateOnly), 1, 24*time.Hour).Result()
+	if err != nil {
+		log.Printf("Unable to claim recommendation mining: %v", err)
+		return false
+	}
+	return claimed
+}
@@ -0,0 +1,224 @@
+package recommendations
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/workflow"
+	"gorm.io/gorm"
+)
+
+const (
+	KindBoughtTogether = "bought_together"
+	KindAlsoViewed     = "also_viewed"
+
+	// Associations kept per product and kind
+	MaxAssociations = 20
+
+	// History the nightly mining looks at
+	MiningWindow = 90 * 24 * time.Hour
+)
+
+// Products in a single order
+type Basket []uuid.UUID
+
+// Returns the baskets of the orders placed since the given time
+type BasketSource func(db *gorm.DB, since time.Time) ([]Basket, error)
+
+// Until orders are recorded there is no purchase history to mine
+var baskets BasketSource = func(*gorm.DB, time.Time) ([]Basket, error) {
+	return nil, nil
+}
+
+func SetBasketSource(fn BasketSource) {
+	baskets = fn
+}
+
+// Rank published products sharing a category or the brand of the product,
+// shared categories weigh most, then the brand, then how close the price is.
+func Related(db *gorm.DB, product models.Product, limit int) ([]models.Product, error) {
+	query := db.Where("id <> ? and active and status = ?", product.Id, workflow.StatusPublished)
+	if len(product.Categories) > 0 {
+		query = query.Where("brand = ? or exists (select 1 from json_array_elements_text(categories) c where c in ?)",
+			product.Brand, product.Categories)
+	} else {
+		query = query.Where("brand = ?", product.Brand)
+	}
+
+	var candidates []models.Product
+	if err := query.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uuid.UUID]float64, len(candidates))
+	for _, candidate := range candidates {
+		scores[candidate.Id] = similarity(product, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return scores[candidates[i].Id] > scores[candidates[j].Id]
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+func similarity(product models.Product, candidate models.Product) float64 {
+	score := 0.0
+	for _, category := range candidate.Categories {
+		for _, own := range product.Categories {
+			if category == own {
+				score += 2
+			}
+		}
+	}
+	if candidate.Brand == product.Brand {
+		score++
+	}
+
+	highest := math.Max(float64(product.Price), float64(candidate.Price))
+	if highest > 0 {
+		score += 1 - math.Abs(float64(product.Price-candidate.Price))/highest
+	}
+	return score
+}
+
+// Published products associated to the product, best score first
+func Associated(db *gorm.DB, product uuid.UUID, kind string, limit int) ([]models.Product, error) {
+	var associations []models.ProductAssociation
+	if err := db.Where("product = ? and kind = ?", product, kind).
+		Order("score desc").
+		Limit(limit).
+		Find(&associations).Error; err != nil {
+		return nil, err
+	}
+	if len(associations) == 0 {
+		return []models.Product{}, nil
+	}
+
+	ids := make([]uuid.UUID, len(associations))
+	for i, association := range associations {
+		ids[i] = association.Related
+	}
+
+	var found []models.Product
+	if err := db.Where("id in ? and active and status = ?", ids, workflow.StatusPublished).Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uuid.UUID]models.Product, len(found))
+	for _, item := range found {
+		byId[item.Id] = item
+	}
+
+	items := make([]models.Product, 0, len(found))
+	for _, id := range ids {
+		if item, ok := byId[id]; ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// Count how often two products appear in the same group,
+// only the best associations of each product are kept
+func Associate(groups []Basket, kind string) []models.ProductAssociation {
+	pairs := map[uuid.UUID]map[uuid.UUID]float64{}
+	for _, group := range groups {
+		seen := map[uuid.UUID]bool{}
+		unique := make([]uuid.UUID, 0, len(group))
+		for _, id := range group {
+			if !seen[id] {
+				seen[id] = true
+				unique = append(unique, id)
+			}
+		}
+
+		for _, a := range unique {
+			for _, b := range unique {
+				if a == b {
+					continue
+				}
+				if pairs[a] == nil {
+					pairs[a] = map[uuid.UUID]float64{}
+				}
+				pairs[a][b]++
+			}
+		}
+	}
+
+	var associations []models.ProductAssociation
+	for product, related := range pairs {
+		var best []models.ProductAssociation
+		for id, score := range related {
+			best = append(best, models.ProductAssociation{Product: product, Related: id, Kind: kind, Score: score})
+		}
+		sort.Slice(best, func(i, j int) bool {
+			if best[i].Score != best[j].Score {
+				return best[i].Score > best[j].Score
+			}
+			return best[i].Related.String() < best[j].Related.String()
+		})
+		if len(best) > MaxAssociations {
+			best = best[:MaxAssociations]
+		}
+		associations = append(associations, best...)
+	}
+	return associations
+}
+
+// Products each viewer looked at since the given time
+func viewGroups(db *gorm.DB, since time.Time) ([]Basket, error) {
+	var views []models.ProductView
+	if err := db.Select("product, viewer").Where("created_at >= ?", since).Find(&views).Error; err != nil {
+		return nil, err
+	}
+
+	byViewer := map[string]Basket{}
+	for _, view := range views {
+		byViewer[view.Viewer] = append(byViewer[view.Viewer], view.Product)
+	}
+
+	groups := make([]Basket, 0, len(byViewer))
+	for _, group := range byViewer {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// Rebuild the associations of both kinds from the recent orders and views
+func Mine(db *gorm.DB) error {
+	since := time.Now().Add(-MiningWindow)
+
+	orders, err := baskets(db, since)
+	if err != nil {
+		return err
+	}
+	views, err := viewGroups(db, since)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for kind, groups := range map[string][]Basket{KindBoughtTogether: orders, KindAlsoViewed: views} {
+			if err := tx.Where("kind = ?", kind).Delete(&models.ProductAssociation{}).Error; err != nil {
+				return err
+			}
+
+			associations := Associate(groups, kind)
+			if len(associations) == 0 {
+				continue
+			}
+			if err := tx.CreateInBatches(&associations, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		// Views only matter for the mining, older ones are no longer needed
+		return tx.Where("created_at < ?", since).Delete(&models.ProductView{}).Error
+	})
+}
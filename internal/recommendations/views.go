@@ -0,0 +1,98 @@
+package recommendations
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+const (
+	// How often buffered views are written
+	viewFlushInterval = 10 * time.Second
+
+	// Views kept in memory between two writes, more are dropped
+	maxPendingViews = 10000
+)
+
+var viewRecorderInstance *ViewRecorder
+
+// ViewRecorder buffers product detail views and writes them in batches,
+// so reading a product does not cost a write.
+type ViewRecorder struct {
+	db      database.Service
+	mu      sync.Mutex
+	pending []models.ProductView
+}
+
+func NewViewRecorder(db database.Service) *ViewRecorder {
+
+	if viewRecorderInstance != nil {
+		return viewRecorderInstance
+	}
+
+	viewRecorderInstance = &ViewRecorder{
+		db: db,
+	}
+
+	return viewRecorderInstance
+}
+
+// Queue a view of the product by a viewer, an account or a session
+func (vr *ViewRecorder) Record(product uuid.UUID, viewer string) {
+	if viewer == "" {
+		return
+	}
+
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	if len(vr.pending) >= maxPendingViews {
+		return
+	}
+	vr.pending = append(vr.pending, models.ProductView{
+		Product:   product,
+		Viewer:    viewer,
+		CreatedAt: time.Now(),
+	})
+}
+
+// Write the queued views in the background until the context is cancelled,
+// the last ones are written on the way out
+func (vr *ViewRecorder) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(viewFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+
+			if err := vr.Flush(); err != nil {
+				log.Printf("Unable to record product views: %v", err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Write the queued views now
+func (vr *ViewRecorder) Flush() error {
+	vr.mu.Lock()
+	views := vr.pending
+	vr.pending = nil
+	vr.mu.Unlock()
+
+	if len(views) == 0 {
+		return nil
+	}
+	return vr.db.UseGorm().CreateInBatches(&views, 500).Error
+}
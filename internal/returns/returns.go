@@ -0,0 +1,358 @@
+package returns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/notifications"
+	"github.com/kevinhartarto/market-be/internal/orders"
+	"github.com/kevinhartarto/market-be/internal/payments"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusRequested = "requested"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusRefunded  = "refunded"
+
+	ReasonDamaged        = "damaged"
+	ReasonWrongItem      = "wrong_item"
+	ReasonNotAsDescribed = "not_as_described"
+	ReasonNoLongerNeeded = "no_longer_needed"
+	ReasonOther          = "other"
+
+	MaxPhotos     = 5
+	DefaultWindow = 30 * 24 * time.Hour
+)
+
+var (
+	ErrNotReturnable   = errors.New("only delivered orders can be returned")
+	ErrWindowClosed    = errors.New("return window of the order has closed")
+	ErrNoLines         = errors.New("return has no lines")
+	ErrUnknownLine     = errors.New("order line not found")
+	ErrInvalidQuantity = errors.New("quantity is more than can be returned")
+	ErrInvalidReason   = errors.New("unknown return reason")
+	ErrInvalidPhoto    = errors.New("photos must be http or https links")
+	ErrTooManyPhotos   = errors.New("too many photos")
+	ErrInvalidStatus   = errors.New("return cannot move to that status")
+	ErrInvalidRefund   = errors.New("refund is more than is left to refund")
+
+	reasons = map[string]bool{
+		ReasonDamaged:        true,
+		ReasonWrongItem:      true,
+		ReasonNotAsDescribed: true,
+		ReasonNoLongerNeeded: true,
+		ReasonOther:          true,
+	}
+)
+
+// How long after delivery an order can be returned, RETURN_WINDOW_DAYS or 30 days
+func Window() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("RETURN_WINDOW_DAYS"))
+	if err != nil || days <= 0 {
+		return DefaultWindow
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func validateLine(line models.ReturnLine) error {
+	if !reasons[line.Reason] {
+		return ErrInvalidReason
+	}
+	if len(line.Photos) > MaxPhotos {
+		return ErrTooManyPhotos
+	}
+	for _, photo := range line.Photos {
+		link, err := url.Parse(photo)
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+			return ErrInvalidPhoto
+		}
+	}
+	return nil
+}
+
+// Request a return of lines of a delivered order, within the return window.
+// A line can be returned up to what was bought less what was already returned.
+func Request(tx *gorm.DB, order models.Order, request *models.ReturnRequest) error {
+	// Returns of the order wait on each other, so their quantities add up
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", order.Id).Error; err != nil {
+		return err
+	}
+	if order.Status != orders.StatusDelivered {
+		return ErrNotReturnable
+	}
+	if len(request.Lines) == 0 {
+		return ErrNoLines
+	}
+
+	var delivery models.OrderEvent
+	if err := tx.Where(`"order" = ? and "to" = ?`, order.Id, orders.StatusDelivered).
+		Order("created_at desc").
+		First(&delivery).Error; err != nil {
+		return err
+	}
+	if time.Since(delivery.CreatedAt) > Window() {
+		return ErrWindowClosed
+	}
+
+	var ordered []models.OrderLine
+	if err := tx.Where(`"order" = ?`, order.Id).Find(&ordered).Error; err != nil {
+		return err
+	}
+	lines := make(map[uuid.UUID]models.OrderLine, len(ordered))
+	for _, line := range ordered {
+		lines[line.Id] = line
+	}
+
+	returned, err := returnedQuantities(tx, order.Id)
+	if err != nil {
+		return err
+	}
+
+	for i := range request.Lines {
+		line := &request.Lines[i]
+		if err := validateLine(*line); err != nil {
+			return err
+		}
+
+		orderLine, ok := lines[line.OrderLine]
+		if !ok {
+			return ErrUnknownLine
+		}
+		returned[line.OrderLine] += line.Quantity
+		if line.Quantity < 1 || returned[line.OrderLine] > orderLine.Quantity {
+			return ErrInvalidQuantity
+		}
+
+		line.Id = uuid.Nil
+		line.Product = orderLine.Product
+	}
+
+	request.Id = uuid.Nil
+	request.Order = order.Id
+	request.Account = order.Account
+	request.Status = StatusRequested
+	request.ReviewedBy = nil
+	request.Refund = 0
+	if err := tx.Create(request).Error; err != nil {
+		return err
+	}
+
+	for i := range request.Lines {
+		request.Lines[i].Return = request.Id
+	}
+	return tx.Create(&request.Lines).Error
+}
+
+// Units of each order line in returns that were not rejected
+func returnedQuantities(tx *gorm.DB, order uuid.UUID) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		OrderLine uuid.UUID
+		Quantity  int
+	}
+	if err := tx.Table("return_line").
+		Select("return_line.order_line, sum(return_line.quantity) as quantity").
+		Joins(`join return_request on return_request.id = return_line."return"`).
+		Where(`return_request."order" = ? and return_request.status <> ?`, order, StatusRejected).
+		Group("return_line.order_line").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	returned := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		returned[row.OrderLine] = row.Quantity
+	}
+	return returned, nil
+}
+
+// Approve a requested return and hand the customer a shipping label
+func Approve(ctx context.Context, tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, notes string) error {
+	if request.Status != StatusRequested {
+		return ErrInvalidStatus
+	}
+
+	items := 0
+	for _, line := range request.Lines {
+		items += line.Quantity
+	}
+	label, err := carrier.CreateLabel(ctx, LabelRequest{
+		Return:  request.Id,
+		Order:   request.Order,
+		Account: request.Account,
+		Items:   items,
+	})
+	if err != nil {
+		return err
+	}
+
+	request.Status = StatusApproved
+	request.Carrier = carrier.Name()
+	request.Tracking = label.Tracking
+	request.LabelURL = label.URL
+	request.ReviewedBy = &by
+	if notes != "" {
+		request.Notes = notes
+	}
+	if err := save(tx, request); err != nil {
+		return err
+	}
+
+	message := "Your return was approved, ship it with tracking number " + label.Tracking
+	return notifications.Notify(tx, request.Account, notifications.TypeReturn, message, request.Id)
+}
+
+func Reject(tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, notes string) error {
+	if request.Status != StatusRequested {
+		return ErrInvalidStatus
+	}
+
+	request.Status = StatusRejected
+	request.ReviewedBy = &by
+	request.Notes = notes
+	if err := save(tx, request); err != nil {
+		return err
+	}
+
+	message := "Your return was rejected"
+	if notes != "" {
+		message += ": " + notes
+	}
+	return notifications.Notify(tx, request.Account, notifications.TypeReturn, message, request.Id)
+}
+
+// Take the items of an approved return back in stock and hold their refund.
+// Without an amount the lines are refunded at what they cost in the order,
+// their share of the discount and tax included. An order with no captured
+// payment is restocked without a refund. The order and payment rows stay
+// locked until the transaction ends, the provider is asked afterwards with
+// payments.CompleteRefunds.
+func Receive(tx *gorm.DB, request *models.ReturnRequest, by uuid.UUID, amount int) error {
+	if request.Status != StatusApproved {
+		return ErrInvalidStatus
+	}
+
+	order := models.Order{Id: request.Order}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", order.Id).Error; err != nil {
+		return err
+	}
+
+	// Nothing captured, nothing is refunded, the items are still restocked
+	refundable, err := payments.Refundable(tx, order)
+	if errors.Is(err, payments.ErrNotRefundable) {
+		refundable, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+	if amount == 0 {
+		amount, err = refundFor(tx, order, request.Lines)
+		if err != nil {
+			return err
+		}
+		amount = min(amount, refundable)
+	}
+	if amount < 0 || amount > refundable {
+		return ErrInvalidRefund
+	}
+
+	quantities := map[uuid.UUID]int{}
+	for _, line := range request.Lines {
+		quantities[line.Product] += line.Quantity
+	}
+	if err := inventory.Restock(tx, quantities); err != nil {
+		return err
+	}
+
+	if amount > 0 {
+		// Locks the payment and checks the amount again under the lock
+		if _, err := payments.StartRefund(tx, &order, amount, &by); err != nil {
+			return err
+		}
+	}
+
+	request.Status = StatusRefunded
+	request.Refund = amount
+	if err := save(tx, request); err != nil {
+		return err
+	}
+
+	message := "Your return was received"
+	if amount > 0 {
+		message = fmt.Sprintf("Your return was received, %d %s is being refunded", amount, order.Currency)
+	}
+	return notifications.Notify(tx, request.Account, notifications.TypeReturn, message, request.Id)
+}
+
+// What the returned lines cost in the order, in proportion to its total
+// less shipping, which is not given back
+func refundFor(tx *gorm.DB, order models.Order, lines []models.ReturnLine) (int, error) {
+	if order.Subtotal == 0 {
+		return 0, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.OrderLine)
+	}
+	var ordered []models.OrderLine
+	if err := tx.Where("id in ?", ids).Find(&ordered).Error; err != nil {
+		return 0, err
+	}
+	prices := make(map[uuid.UUID]int, len(ordered))
+	for _, line := range ordered {
+		prices[line.Id] = line.UnitPrice
+	}
+
+	returned := 0
+	for _, line := range lines {
+		returned += prices[line.OrderLine] * line.Quantity
+	}
+	goods := order.Total - order.Shipping
+	return (returned*goods + order.Subtotal/2) / order.Subtotal, nil
+}
+
+// Load the lines of the returns
+func LoadLines(db *gorm.DB, requests []models.ReturnRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(requests))
+	for i, request := range requests {
+		ids[i] = request.Id
+	}
+
+	var lines []models.ReturnLine
+	if err := db.Where(`"return" in ?`, ids).Find(&lines).Error; err != nil {
+		return err
+	}
+
+	byReturn := map[uuid.UUID][]models.ReturnLine{}
+	for _, line := range lines {
+		byReturn[line.Return] = append(byReturn[line.Return], line)
+	}
+	for i := range requests {
+		requests[i].Lines = byReturn[requests[i].Id]
+		if requests[i].Lines == nil {
+			requests[i].Lines = []models.ReturnLine{}
+		}
+	}
+	return nil
+}
+
+func save(tx *gorm.DB, request *models.ReturnRequest) error {
+	return tx.Model(request).
+		Select("status", "notes", "carrier", "tracking", "label_url", "refund", "reviewed_by", "updated_at").
+		Updates(request).Error
+}
@@ -0,0 +1,220 @@
+package reviews
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+
+	MaxPhotos     = 5
+	MaxBodyLength = 5000
+)
+
+var (
+	ErrInvalidRating   = errors.New("rating must be between 1 and 5")
+	ErrInvalidPhoto    = errors.New("photos must be http or https links")
+	ErrTooManyPhotos   = errors.New("too many photos")
+	ErrBodyTooLong     = errors.New("review is too long")
+	ErrNotPurchased    = errors.New("only customers who bought the product can review it")
+	ErrAlreadyReviewed = errors.New("product was already reviewed by this account")
+	ErrAlreadyVoted    = errors.New("review was already voted by this account")
+	ErrOwnReview       = errors.New("cannot vote on your own review")
+)
+
+// Words that send a review to the top of the moderation queue,
+// extended with the comma separated PROFANITY_WORDS
+var profanity = map[string]bool{
+	"ass":      true,
+	"bastard":  true,
+	"bitch":    true,
+	"crap":     true,
+	"damn":     true,
+	"dick":     true,
+	"fuck":     true,
+	"fucking":  true,
+	"idiot":    true,
+	"piss":     true,
+	"shit":     true,
+	"shitty":   true,
+	"stupid":   true,
+	"wanker":   true,
+	"bullshit": true,
+}
+
+func init() {
+	for _, word := range strings.Split(os.Getenv("PROFANITY_WORDS"), ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			profanity[word] = true
+		}
+	}
+}
+
+// Tells whether the account bought the product
+type PurchaseVerifier func(db *gorm.DB, account uuid.UUID, product uuid.UUID) (bool, error)
+
+// Until orders are recorded nobody counts as a verified purchaser
+var verifier PurchaseVerifier = func(*gorm.DB, uuid.UUID, uuid.UUID) (bool, error) {
+	return false, nil
+}
+
+func SetPurchaseVerifier(fn PurchaseVerifier) {
+	verifier = fn
+}
+
+// Only verified purchasers may review when REVIEWS_VERIFIED_ONLY is set
+func VerifiedOnly() bool {
+	value := strings.ToLower(os.Getenv("REVIEWS_VERIFIED_ONLY"))
+	return value == "true" || value == "1"
+}
+
+// Check the content of a review sent by a customer
+func Validate(review models.Review) error {
+	if review.Rating < 1 || review.Rating > 5 {
+		return ErrInvalidRating
+	}
+	if len(review.Body) > MaxBodyLength {
+		return ErrBodyTooLong
+	}
+	if len(review.Photos) > MaxPhotos {
+		return ErrTooManyPhotos
+	}
+	for _, photo := range review.Photos {
+		link, err := url.Parse(photo)
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+			return ErrInvalidPhoto
+		}
+	}
+	return nil
+}
+
+// Whether the text contains a word of the profanity list
+func ContainsProfanity(text string) bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		if profanity[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// Store a new review, it stays pending until a moderator approves it
+func Submit(db *gorm.DB, review *models.Review) error {
+	var existing int64
+	if err := db.Model(&models.Review{}).
+		Where("product = ? and account = ?", review.Product, review.Account).
+		Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrAlreadyReviewed
+	}
+
+	verified, err := verifier(db, review.Account, review.Product)
+	if err != nil {
+		return err
+	}
+	if !verified && VerifiedOnly() {
+		return ErrNotPurchased
+	}
+
+	review.Id = uuid.Nil
+	review.VerifiedPurchase = verified
+	review.Status = StatusPending
+	review.Flagged = ContainsProfanity(review.Title + " " + review.Body)
+	review.Helpful = 0
+	review.ModeratedBy = nil
+	review.ModerationNote = ""
+	return db.Create(review).Error
+}
+
+// Count a helpful vote, each account votes once per review
+func Vote(tx *gorm.DB, review models.Review, account uuid.UUID) error {
+	if review.Account == account {
+		return ErrOwnReview
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ReviewVote{
+		Review:  review.Id,
+		Account: account,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyVoted
+	}
+
+	return tx.Model(&review).Update("helpful", gorm.Expr("helpful + 1")).Error
+}
+
+// Rating summaries of the products, only approved reviews count
+func Summaries(db *gorm.DB, products []uuid.UUID) (map[uuid.UUID]*models.RatingSummary, error) {
+	var rows []struct {
+		Product uuid.UUID
+		Rating  int
+		Total   int
+	}
+	if err := db.Model(&models.Review{}).
+		Select("product, rating, count(*) as total").
+		Where("product in ? and status = ?", products, StatusApproved).
+		Group("product, rating").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	summaries := map[uuid.UUID]*models.RatingSummary{}
+	sums := map[uuid.UUID]int{}
+	for _, row := range rows {
+		summary, ok := summaries[row.Product]
+		if !ok {
+			summary = &models.RatingSummary{Distribution: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+			summaries[row.Product] = summary
+		}
+		summary.Distribution[row.Rating] += row.Total
+		summary.Count += row.Total
+		sums[row.Product] += row.Rating * row.Total
+	}
+
+	for id, summary := range summaries {
+		// Rounded to one decimal, as shown on the product page
+		summary.Average = math.Round(float64(sums[id])/float64(summary.Count)*10) / 10
+	}
+	return summaries, nil
+}
+
+// Fill the rating of each product
+func ApplyRatings(db *gorm.DB, items []models.Product) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.Id
+	}
+
+	summaries, err := Summaries(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Rating = summaries[items[i].Id]
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package revisions
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/catalog"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SourceUpdate    = "update"
+	SourcePatch     = "patch"
+	SourceImport    = "import"
+	SourcePromotion = "promotion"
+	SourcePublish   = "publish"
+	SourceArchive   = "archive"
+	SourceRollback  = "rollback"
+)
+
+var (
+	ErrUnknownRecord = errors.New("revisions are only kept for products, brands and categories")
+	ErrNumberTaken   = errors.New("unable to number the revision")
+)
+
+// Concurrent writers of the same record retry with the next number
+const numberAttempts = 5
+
+// Bookkeeping fields that change on every save, a diff never reports them
+var ignoredFields = []string{"version", "updated_at", "updated_by"}
+
+// A field that differs between two revisions
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Store a snapshot of the record as its next revision.
+// The record must be the row as saved, including its new version.
+// Numbers are unique per record, a number taken by a concurrent
+// writer is skipped and the next one is tried.
+func Record(tx *gorm.DB, record any, source string) error {
+	entity, id, changedBy, err := describe(record)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := Snapshot(record)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < numberAttempts; attempt++ {
+		var last int
+		if err := tx.Model(&models.Revision{}).
+			Where("entity = ? and record = ?", entity, id).
+			Select("coalesce(max(number), 0)").
+			Scan(&last).Error; err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Revision{
+			Entity:    entity,
+			Record:    id,
+			Number:    last + 1,
+			Snapshot:  snapshot,
+			Source:    source,
+			ChangedBy: changedBy,
+		})
+		if result.Error != nil || result.RowsAffected == 1 {
+			return result.Error
+		}
+	}
+	return ErrNumberTaken
+}
+
+// Stored fields of the record keyed by their json name,
+// values computed on read are left out
+func Snapshot(record any) (map[string]interface{}, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	t := reflect.Indirect(reflect.ValueOf(record)).Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("gorm") == "-" {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			delete(snapshot, name)
+		}
+	}
+	return snapshot, nil
+}
+
+// Revisions of a record, newest first
+func List(db *gorm.DB, entity string, id uuid.UUID) ([]models.Revision, error) {
+	var revisions []models.Revision
+	err := db.Where("entity = ? and record = ?", entity, id).Order("number desc").Find(&revisions).Error
+	return revisions, err
+}
+
+// Field level changes needed to go from one snapshot to the other
+func Diff(from, to map[string]interface{}) []Change {
+	fields := map[string]bool{}
+	for field := range from {
+		fields[field] = true
+	}
+	for field := range to {
+		fields[field] = true
+	}
+
+	changes := []Change{}
+	for field := range fields {
+		if ignored(field) {
+			continue
+		}
+		if !reflect.DeepEqual(from[field], to[field]) {
+			changes = append(changes, Change{Field: field, From: from[field], To: to[field]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// Decode the snapshot of a revision into the record it was taken from
+func Restore(revision models.Revision, record any) error {
+	data, err := json.Marshal(revision.Snapshot)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, record)
+}
+
+func ignored(field string) bool {
+	for _, name := range ignoredFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(record any) (string, uuid.UUID, uuid.UUID, error) {
+	switch r := record.(type) {
+	case *models.Product:
+		return catalog.EntityProduct, r.Id, r.UpdateBy, nil
+	case *models.Brand:
+		return catalog.EntityBrand, r.Id, r.UpdateBy, nil
+	case *models.Category:
+		return catalog.EntityCategory, r.Id, r.UpdateBy, nil
+	}
+	return "", uuid.Nil, uuid.Nil, ErrUnknownRecord
+}
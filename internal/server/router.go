@@ -3,13 +3,32 @@ package server
 import (
 	"context"
 	"fmt"
+	"log"
+	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/kevinhartarto/market-be/internal/cache"
+	"github.com/kevinhartarto/market-be/internal/carts"
 	"github.com/kevinhartarto/market-be/internal/controllers"
+	"github.com/kevinhartarto/market-be/internal/currency"
 	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/documents"
+	"github.com/kevinhartarto/market-be/internal/inventory"
+	"github.com/kevinhartarto/market-be/internal/middlewares"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/orders"
+	"github.com/kevinhartarto/market-be/internal/payments"
+	"github.com/kevinhartarto/market-be/internal/promotions"
+	"github.com/kevinhartarto/market-be/internal/recommendations"
+	"github.com/kevinhartarto/market-be/internal/reviews"
+	"github.com/kevinhartarto/market-be/internal/shipping"
+	"github.com/kevinhartarto/market-be/internal/tax"
+	"github.com/kevinhartarto/market-be/internal/wishlists"
+	"github.com/kevinhartarto/market-be/internal/workflow"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -25,6 +44,25 @@ func NewHandler(db database.Service, redis *redis.Client) *fiber.App {
 
 	marketAPI := app.Group("/api")
 
+	// Retried POST and PUT requests with the same Idempotency-Key get the first response
+	idempotencyTTL, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL"))
+	if err != nil || idempotencyTTL <= 0 {
+		idempotencyTTL = 24 * time.Hour
+	}
+	marketAPI.Use(middlewares.Idempotency(redis, idempotencyTTL))
+
+	// Authentication and the admin check shared by the routes below
+	user := middlewares.NewUserMiddleware(context, *redis)
+	isAdmin := middlewares.Permission(func(role models.Role) bool {
+		return role.IsAdmin
+	})
+
+	// Read-through cache of the catalog endpoints
+	catalogCache := cache.NewCache(redis)
+	marketAPI.Get("/cache/stats", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return c.JSON(catalogCache.Stats())
+	})
+
 	// Login and Register APIs
 	account := controllers.NewAccountController(db, redis)
 	account.LoadRoles(context)
@@ -40,12 +78,24 @@ func NewHandler(db database.Service, redis *redis.Client) *fiber.App {
 	accountAPI.Post("/register", func(c *fiber.Ctx) error {
 		return account.CreateAccount(c)
 	})
-	accountAPI.Put("/update", func(c *fiber.Ctx) error {
+	accountAPI.Put("/update", user.Authenticate(db), func(c *fiber.Ctx) error {
 		return account.UpdateAccount(c)
 	})
+	accountAPI.Patch("/patch", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return account.PatchAccount(c)
+	})
+
+	notification := controllers.NewNotificationController(db, redis)
+	notificationAPI := accountAPI.Group("/notifications", user.Authenticate(db))
+	notificationAPI.Get("/", func(c *fiber.Ctx) error {
+		return notification.GetNotifications(c)
+	})
+	notificationAPI.Put("/read", func(c *fiber.Ctx) error {
+		return notification.ReadNotifications(c)
+	})
 
 	// For admin and owner roles
-	superUserAPI := accountAPI.Group("/super")
+	superUserAPI := accountAPI.Group("/super", user.Authenticate(db), isAdmin)
 	superUserAPI.Get("/reload", func(c *fiber.Ctx) error {
 		account.LoadRoles(context)
 		return c.SendStatus(fiber.StatusOK)
@@ -59,55 +109,452 @@ func NewHandler(db database.Service, redis *redis.Client) *fiber.App {
 	superUserAPI.Put("/update", func(c *fiber.Ctx) error {
 		return account.UpdateRole(c)
 	})
+	superUserAPI.Patch("/role/patch", func(c *fiber.Ctx) error {
+		return account.PatchRole(c)
+	})
+
+	// Exchange rates are needed before any price is served
+	currencies := controllers.NewCurrencyController(db, redis)
+	currencies.LoadRates()
+	fmt.Println("Exchange rates loaded")
+
+	currencyAPI := marketAPI.Group("/currency")
+	currencyAPI.Get("/rates", func(c *fiber.Ctx) error {
+		return currencies.GetRates(c)
+	})
+	currencyAPI.Put("/rates", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return currencies.UpdateRates(c)
+	})
+
+	// Cart, stock held for carts is released once the hold expires
+	sweeper := inventory.NewSweeper(db, time.Minute)
+	sweeper.Start(context)
+	fmt.Println("Reservation sweeper started")
 
-	// Cart
 	cart := controllers.NewCartController(db, redis)
-	cartAPI := marketAPI.Group("/cart")
+	cartAPI := marketAPI.Group("/cart", user.Identify(db))
 	cartAPI.Get("/", func(c *fiber.Ctx) error {
 		return cart.GetCart(c)
 	})
 	cartAPI.Put("/update", func(c *fiber.Ctx) error {
 		return cart.UpdateCart(c)
 	})
+	cartAPI.Post("/line", func(c *fiber.Ctx) error {
+		return cart.AddLine(c)
+	})
+	cartAPI.Put("/line", func(c *fiber.Ctx) error {
+		return cart.UpdateLine(c)
+	})
+	cartAPI.Delete("/line", func(c *fiber.Ctx) error {
+		return cart.RemoveLine(c)
+	})
+	cartAPI.Put("/coupon", func(c *fiber.Ctx) error {
+		return cart.ApplyCoupon(c)
+	})
+	cartAPI.Delete("/coupon", func(c *fiber.Ctx) error {
+		return cart.RemoveCoupon(c)
+	})
+
+	// Taxes are worked out from the rates of the tax_rate table
+	carts.SetTaxEstimator(tax.Estimate)
+	documents.SetTaxBreakdown(tax.Breakdown)
+
+	// Shipping quotes, the stub carrier prices carrier methods until a live one is registered
+	shipping.Register(shipping.StubCarrier{Currency: currency.NewConverter().Base()})
+	cartAPI.Get("/shipping", func(c *fiber.Ctx) error {
+		return cart.GetShippingQuotes(c)
+	})
+
+	// Cache-Control policies of the catalog reads
+	productListCache := cachePolicy("CACHE_CONTROL_PRODUCT_LIST", "public, max-age=60")
+	productDetailCache := cachePolicy("CACHE_CONTROL_PRODUCT_DETAIL", "public, max-age=300")
 
 	product := controllers.NewProductController(db, redis)
 	productAPI := marketAPI.Group("/product")
-	productAPI.Get("/", func(c *fiber.Ctx) error {
+	productAPI.Get("/", middlewares.ConditionalGet(productListCache), func(c *fiber.Ctx) error {
 		return product.GetAllProducts(c)
 	})
-	productAPI.Get("/brands", func(c *fiber.Ctx) error {
+	productAPI.Get("/brands", middlewares.ConditionalGet(productListCache), func(c *fiber.Ctx) error {
 		return product.GetAllBrands(c)
 	})
-	productAPI.Get("/categories", func(c *fiber.Ctx) error {
+	productAPI.Get("/categories", middlewares.ConditionalGet(productListCache), func(c *fiber.Ctx) error {
 		return product.GetAllCategories(c)
 	})
 
-	productAPI.Get("/brand/products", func(c *fiber.Ctx) error {
+	productAPI.Get("/brand/products", middlewares.ConditionalGet(productListCache), func(c *fiber.Ctx) error {
 		return product.GetProductsByBrand(c)
 	})
-	productAPI.Get("/category/products", func(c *fiber.Ctx) error {
+	productAPI.Get("/category/products", middlewares.ConditionalGet(productListCache), func(c *fiber.Ctx) error {
 		return product.GetProductsByCategory(c)
 	})
 
-	productAPI.Get("/detail", func(c *fiber.Ctx) error {
+	productAPI.Get("/detail", middlewares.ConditionalGet(productDetailCache), func(c *fiber.Ctx) error {
 		return product.GetProductDetails(c)
 	})
-	productAPI.Get("/brand/detail", func(c *fiber.Ctx) error {
+	productAPI.Get("/detail/recommendations", func(c *fiber.Ctx) error {
+		return product.GetRecommendations(c)
+	})
+	productAPI.Get("/brand/detail", middlewares.ConditionalGet(productDetailCache), func(c *fiber.Ctx) error {
 		return product.GetBrandDetails(c)
 	})
-	productAPI.Get("/category/detail", func(c *fiber.Ctx) error {
+	productAPI.Get("/category/detail", middlewares.ConditionalGet(productDetailCache), func(c *fiber.Ctx) error {
 		return product.GetCategoryDetails(c)
 	})
 
-	productAPI.Put("/update", func(c *fiber.Ctx) error {
+	productAPI.Put("/update", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
 		return product.UpdateProduct(c)
 	})
-	productAPI.Put("/brand/update", func(c *fiber.Ctx) error {
+	productAPI.Get("/price-history", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return product.GetPriceHistory(c)
+	})
+	productAPI.Get("/prices", func(c *fiber.Ctx) error {
+		return currencies.GetProductPrices(c)
+	})
+	productAPI.Put("/prices", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return currencies.UpdateProductPrices(c)
+	})
+	productAPI.Put("/brand/update", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
 		return product.UpdateBrand(c)
 	})
-	productAPI.Put("/category/update", func(c *fiber.Ctx) error {
+	productAPI.Put("/category/update", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
 		return product.UpdateCategory(c)
 	})
 
+	productAPI.Patch("/patch", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return product.PatchProduct(c)
+	})
+	productAPI.Patch("/brand/patch", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return product.PatchBrand(c)
+	})
+	productAPI.Patch("/category/patch", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return product.PatchCategory(c)
+	})
+
+	// Product lifecycle, edits go through drafts that need a review
+	publisher := workflow.NewPublisher(db, time.Minute)
+	publisher.AfterPublish(func() {
+		catalogCache.Drop(context, cache.TagProducts)
+	})
+	publisher.Start(context)
+	fmt.Println("Draft publisher started")
+
+	draft := controllers.NewDraftController(db, redis, publisher)
+	draftAPI := productAPI.Group("/draft", user.Authenticate(db))
+	draftAPI.Get("/", func(c *fiber.Ctx) error {
+		return draft.GetAllDrafts(c)
+	})
+	draftAPI.Get("/detail", func(c *fiber.Ctx) error {
+		return draft.GetDraft(c)
+	})
+	draftAPI.Post("/create", func(c *fiber.Ctx) error {
+		return draft.CreateDraft(c)
+	})
+	draftAPI.Put("/update", func(c *fiber.Ctx) error {
+		return draft.UpdateDraft(c)
+	})
+	draftAPI.Post("/submit", func(c *fiber.Ctx) error {
+		return draft.SubmitDraft(c)
+	})
+	draftAPI.Post("/approve", func(c *fiber.Ctx) error {
+		return draft.ApproveDraft(c)
+	})
+	draftAPI.Post("/reject", func(c *fiber.Ctx) error {
+		return draft.RejectDraft(c)
+	})
+	draftAPI.Post("/archive", func(c *fiber.Ctx) error {
+		return draft.ArchiveProduct(c)
+	})
+
+	// Revisions of products, brands and categories
+	revision := controllers.NewRevisionController(db, redis)
+	productAPI.Get("/revisions", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return revision.GetRevisions(c)
+	})
+	productAPI.Get("/revisions/diff", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return revision.GetRevisionDiff(c)
+	})
+	productAPI.Post("/revisions/rollback", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return revision.RollbackRevision(c)
+	})
+
+	// Reviews are published once a moderator approves them
+	review := controllers.NewReviewController(db, redis)
+	reviewAPI := productAPI.Group("/review")
+	reviewAPI.Get("/", func(c *fiber.Ctx) error {
+		return review.GetReviews(c)
+	})
+	reviewAPI.Post("/create", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return review.CreateReview(c)
+	})
+	reviewAPI.Post("/helpful", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return review.VoteReview(c)
+	})
+	reviewAPI.Get("/moderation", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return review.GetModerationQueue(c)
+	})
+	reviewAPI.Post("/moderate", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return review.ModerateReview(c)
+	})
+
+	// Questions and answers between shoppers and product owners
+	question := controllers.NewQuestionController(db, redis)
+	questionAPI := productAPI.Group("/question")
+	questionAPI.Get("/", func(c *fiber.Ctx) error {
+		return question.GetQuestions(c)
+	})
+	questionAPI.Post("/ask", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return question.AskQuestion(c)
+	})
+	questionAPI.Post("/answer", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return question.AnswerQuestion(c)
+	})
+	questionAPI.Post("/upvote", user.Authenticate(db), func(c *fiber.Ctx) error {
+		return question.UpvoteAnswer(c)
+	})
+
+	// Bulk catalog import and export, for admins
+	catalog := controllers.NewCatalogController(db, redis)
+	productAPI.Post("/import", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return catalog.ImportCatalog(c)
+	})
+	productAPI.Get("/import/status", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return catalog.GetImportJob(c)
+	})
+	productAPI.Get("/export", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return catalog.ExportCatalog(c)
+	})
+
+	// Co-purchase and co-view associations are mined every night
+	miner := recommendations.NewMiner(db, redis, 3)
+	miner.AfterMine(func() {
+		catalogCache.Drop(context, cache.TagRecommendations)
+	})
+	miner.Start(context)
+	recommendations.NewViewRecorder(db).Start(context)
+	fmt.Println("Recommendation miner started")
+
+	// Wishlists, owners are told about price drops and restocks
+	watcher := wishlists.NewWatcher(db, 15*time.Minute)
+	watcher.Start(context)
+	fmt.Println("Wishlist watcher started")
+
+	wishlist := controllers.NewWishlistController(db, redis)
+	wishlistAPI := marketAPI.Group("/wishlist")
+
+	// Share links are public, registered before the authenticated routes
+	wishlistAPI.Get("/shared", func(c *fiber.Ctx) error {
+		return wishlist.GetSharedWishlist(c)
+	})
+
+	canWishlist := middlewares.Permission(func(role models.Role) bool {
+		return role.CanWishlist
+	})
+	ownWishlistAPI := wishlistAPI.Group("/", user.Authenticate(db), canWishlist)
+	ownWishlistAPI.Get("/", func(c *fiber.Ctx) error {
+		return wishlist.GetWishlists(c)
+	})
+	ownWishlistAPI.Post("/create", func(c *fiber.Ctx) error {
+		return wishlist.CreateWishlist(c)
+	})
+	ownWishlistAPI.Put("/update", func(c *fiber.Ctx) error {
+		return wishlist.UpdateWishlist(c)
+	})
+	ownWishlistAPI.Delete("/delete", func(c *fiber.Ctx) error {
+		return wishlist.DeleteWishlist(c)
+	})
+	ownWishlistAPI.Post("/item", func(c *fiber.Ctx) error {
+		return wishlist.AddItem(c)
+	})
+	ownWishlistAPI.Delete("/item", func(c *fiber.Ctx) error {
+		return wishlist.RemoveItem(c)
+	})
+	ownWishlistAPI.Post("/item/move", func(c *fiber.Ctx) error {
+		return wishlist.MoveToCart(c)
+	})
+
+	// Orders can only be paid with a provider and the secret its webhooks
+	// are signed with. The fake gateway is only there with PAYMENT_FAKE=true,
+	// for local development.
+	var fakeGateway *payments.FakeProvider
+	paymentsEnabled := false
+	webhookSecret := []byte(os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+	if len(webhookSecret) == 0 {
+		log.Println("PAYMENT_WEBHOOK_SECRET is not set, payments are disabled")
+	} else {
+		if os.Getenv("PAYMENT_FAKE") == "true" {
+			fakeGateway = payments.NewFakeProvider(webhookSecret)
+			payments.Register(fakeGateway)
+		}
+		if _, err := payments.Default(); err != nil {
+			log.Printf("Unable to use the payment provider, payments are disabled: %v", err)
+		} else {
+			paymentsEnabled = true
+		}
+	}
+
+	// Orders, purchases verify reviews and feed the recommendations
+	reviews.SetPurchaseVerifier(orders.Purchased)
+	recommendations.SetBasketSource(orders.Baskets)
+	orders.AfterTransition(documents.IssueOnPaid)
+
+	order := controllers.NewOrderController(db, redis)
+	orderAPI := marketAPI.Group("/order", user.Authenticate(db))
+	orderAPI.Get("/", func(c *fiber.Ctx) error {
+		return order.GetOrders(c)
+	})
+	orderAPI.Get("/detail", func(c *fiber.Ctx) error {
+		return order.GetOrder(c)
+	})
+	orderAPI.Get("/invoice", func(c *fiber.Ctx) error {
+		return order.GetInvoice(c)
+	})
+	orderAPI.Get("/packing-slip", func(c *fiber.Ctx) error {
+		return order.GetPackingSlip(c)
+	})
+
+	canBuy := middlewares.Permission(func(role models.Role) bool {
+		return role.CanBuy
+	})
+	orderAPI.Post("/checkout", canBuy, func(c *fiber.Ctx) error {
+		return order.Checkout(c)
+	})
+	if paymentsEnabled {
+		orderAPI.Post("/pay", canBuy, func(c *fiber.Ctx) error {
+			return order.PayOrder(c)
+		})
+	}
+	orderAPI.Put("/cancel", canBuy, func(c *fiber.Ctx) error {
+		return order.CancelOrder(c)
+	})
+
+	orderAPI.Put("/status", isAdmin, func(c *fiber.Ctx) error {
+		return order.UpdateStatus(c)
+	})
+
+	// Shipping zones and methods are set up by admins
+	shippingSetup := controllers.NewShippingController(db, redis)
+	shippingAPI := marketAPI.Group("/shipping", user.Authenticate(db), isAdmin)
+	shippingAPI.Get("/zone", func(c *fiber.Ctx) error {
+		return shippingSetup.GetZones(c)
+	})
+	shippingAPI.Post("/zone", func(c *fiber.Ctx) error {
+		return shippingSetup.CreateZone(c)
+	})
+	shippingAPI.Put("/zone", func(c *fiber.Ctx) error {
+		return shippingSetup.UpdateZone(c)
+	})
+	shippingAPI.Delete("/zone", func(c *fiber.Ctx) error {
+		return shippingSetup.DeleteZone(c)
+	})
+	shippingAPI.Post("/method", func(c *fiber.Ctx) error {
+		return shippingSetup.CreateMethod(c)
+	})
+	shippingAPI.Put("/method", func(c *fiber.Ctx) error {
+		return shippingSetup.UpdateMethod(c)
+	})
+	shippingAPI.Delete("/method", func(c *fiber.Ctx) error {
+		return shippingSetup.DeleteMethod(c)
+	})
+
+	// Tax rates per jurisdiction and tax class, for admins
+	taxRates := controllers.NewTaxController(db, redis)
+	taxAPI := marketAPI.Group("/tax", user.Authenticate(db), isAdmin)
+	taxAPI.Get("/rate", func(c *fiber.Ctx) error {
+		return taxRates.GetRates(c)
+	})
+	taxAPI.Put("/rate", func(c *fiber.Ctx) error {
+		return taxRates.SaveRate(c)
+	})
+	taxAPI.Delete("/rate", func(c *fiber.Ctx) error {
+		return taxRates.DeleteRate(c)
+	})
+
+	// Returns are approved and received by admins
+	returnRequest := controllers.NewReturnController(db, redis)
+	returnAPI := orderAPI.Group("/return")
+	returnAPI.Get("/", func(c *fiber.Ctx) error {
+		return returnRequest.GetReturns(c)
+	})
+	returnAPI.Get("/label", func(c *fiber.Ctx) error {
+		return returnRequest.GetReturnLabel(c)
+	})
+	returnAPI.Post("/", canBuy, func(c *fiber.Ctx) error {
+		return returnRequest.RequestReturn(c)
+	})
+	returnAPI.Put("/approve", isAdmin, func(c *fiber.Ctx) error {
+		return returnRequest.ApproveReturn(c)
+	})
+	returnAPI.Put("/reject", isAdmin, func(c *fiber.Ctx) error {
+		return returnRequest.RejectReturn(c)
+	})
+	returnAPI.Put("/receive", isAdmin, func(c *fiber.Ctx) error {
+		return returnRequest.ReceiveReturn(c)
+	})
+
+	// Payments, providers drive the orders through their webhooks
+	if paymentsEnabled {
+		reconciler := payments.NewReconciler(db, time.Minute)
+		reconciler.Start(context)
+		fmt.Println("Refund reconciler started")
+
+		payment := controllers.NewPaymentController(db, redis, fakeGateway)
+		paymentAPI := marketAPI.Group("/payment")
+		paymentAPI.Post("/webhook/:provider", func(c *fiber.Ctx) error {
+			return payment.Webhook(c)
+		})
+		if fakeGateway != nil {
+			paymentAPI.Get("/fake/challenge", func(c *fiber.Ctx) error {
+				return payment.FakeChallenge(c)
+			})
+		}
+	}
+
+	// Promotions, the scheduler keeps product sale fields in line
+	scheduler := promotions.NewScheduler(db, time.Minute)
+	scheduler.AfterSync(func() {
+		catalogCache.Drop(context, cache.TagProducts, cache.TagBrands)
+	})
+	scheduler.Start(context)
+	fmt.Println("Promotion scheduler started")
+
+	promotion := controllers.NewPromotionController(db, redis, scheduler)
+	promotionAPI := marketAPI.Group("/promotion")
+	promotionAPI.Get("/", func(c *fiber.Ctx) error {
+		return promotion.GetAllPromotions(c)
+	})
+	promotionAPI.Get("/detail", func(c *fiber.Ctx) error {
+		return promotion.GetPromotion(c)
+	})
+	promotionAPI.Post("/create", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return promotion.CreatePromotion(c)
+	})
+	promotionAPI.Put("/update", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return promotion.UpdatePromotion(c)
+	})
+
+	// Coupons
+	coupon := controllers.NewCouponController(db, redis)
+	couponAPI := marketAPI.Group("/coupon")
+	couponAPI.Get("/", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return coupon.GetAllCoupons(c)
+	})
+	couponAPI.Post("/create", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return coupon.CreateCoupon(c)
+	})
+	couponAPI.Post("/generate", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return coupon.GenerateCoupons(c)
+	})
+	couponAPI.Put("/update", user.Authenticate(db), isAdmin, func(c *fiber.Ctx) error {
+		return coupon.UpdateCoupon(c)
+	})
+
 	return app
 }
+
+// Cache-Control value of a route, overridable from the environment
+func cachePolicy(env string, fallback string) string {
+	if policy := os.Getenv(env); policy != "" {
+		return policy
+	}
+	return fallback
+}
@@ -0,0 +1,74 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrUnknownCarrier = errors.New("shipping carrier not found")
+
+	carriers = map[string]Carrier{}
+	mu       sync.RWMutex
+)
+
+// A carrier prices parcels live for carrier methods
+type Carrier interface {
+	Name() string
+	Rates(ctx context.Context, request RateRequest) ([]Rate, error)
+}
+
+// The parcel to price, weight in grams and dimensions in millimetres
+type RateRequest struct {
+	Country  string
+	Postcode string
+	Weight   int
+	Length   int
+	Width    int
+	Height   int
+}
+
+type Rate struct {
+	Service  string
+	Amount   int
+	Currency string
+	MinDays  int
+	MaxDays  int
+}
+
+func Register(carrier Carrier) {
+	mu.Lock()
+	defer mu.Unlock()
+	carriers[carrier.Name()] = carrier
+}
+
+func Lookup(name string) (Carrier, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	carrier, ok := carriers[name]
+	if !ok {
+		return nil, ErrUnknownCarrier
+	}
+	return carrier, nil
+}
+
+const StubName = "stub"
+
+// StubCarrier stands in for a live carrier when developing locally,
+// rates are worked out from the weight of the parcel alone
+type StubCarrier struct {
+	Currency string
+}
+
+func (StubCarrier) Name() string {
+	return StubName
+}
+
+func (sc StubCarrier) Rates(ctx context.Context, request RateRequest) ([]Rate, error) {
+	kilos := (request.Weight + 999) / 1000
+	return []Rate{
+		{Service: "standard", Amount: 500 + 100*kilos, Currency: sc.Currency, MinDays: 3, MaxDays: 5},
+		{Service: "express", Amount: 1500 + 250*kilos, Currency: sc.Currency, MinDays: 1, MaxDays: 2},
+	}, nil
+}
@@ -0,0 +1,332 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	KindFlat    = "flat"
+	KindWeight  = "weight"
+	KindCarrier = "carrier"
+)
+
+var (
+	ErrNoZone         = errors.New("no shipping to that address")
+	ErrUnknownMethod  = errors.New("shipping method is not available for the address")
+	ErrTooHeavy       = errors.New("parcel is too heavy for the shipping method")
+	ErrNoRate         = errors.New("carrier has no rate for the parcel")
+	ErrInvalidAddress = errors.New("address needs a name, first line, city, postcode and country")
+	ErrInvalidZone    = errors.New("zone needs a name, countries and valid postcode ranges")
+	ErrInvalidMethod  = errors.New("invalid shipping method")
+
+	kinds = map[string]bool{
+		KindFlat:    true,
+		KindWeight:  true,
+		KindCarrier: true,
+	}
+)
+
+// What is shipped, weight in grams and dimensions in millimetres.
+// Value is what the goods cost after the discount, in the base currency.
+type Parcel struct {
+	Weight int
+	Length int
+	Width  int
+	Height int
+	Value  int
+}
+
+// Price of a shipping method for a parcel, in the base currency
+type Quote struct {
+	Method   uuid.UUID `json:"method"`
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Carrier  string    `json:"carrier,omitempty"`
+	Amount   int       `json:"amount"`
+	Currency string    `json:"currency"`
+	MinDays  int       `json:"min_days"`
+	MaxDays  int       `json:"max_days"`
+}
+
+// The parcel of the cart lines, items are stacked on top of each other
+func ParcelOf(lines []coupons.Line, discount int) Parcel {
+	parcel := Parcel{Value: -discount}
+	for _, line := range lines {
+		product := line.Product
+		parcel.Weight += product.Weight * line.Quantity
+		parcel.Length = max(parcel.Length, product.Length)
+		parcel.Width = max(parcel.Width, product.Width)
+		parcel.Height += product.Height * line.Quantity
+		parcel.Value += line.UnitPrice * line.Quantity
+	}
+	parcel.Value = max(parcel.Value, 0)
+	return parcel
+}
+
+// Upper case without spaces or dashes, so "sw1a 1aa" is "SW1A1AA"
+func NormalisePostcode(postcode string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(postcode))
+}
+
+func ValidateAddress(address *models.Address) error {
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	address.Postcode = strings.TrimSpace(address.Postcode)
+	if strings.TrimSpace(address.Name) == "" || strings.TrimSpace(address.Line1) == "" ||
+		strings.TrimSpace(address.City) == "" || address.Postcode == "" || len(address.Country) != 2 {
+		return ErrInvalidAddress
+	}
+	return nil
+}
+
+// Check the zone definition before it is stored
+func ValidateZone(zone *models.ShippingZone) error {
+	if strings.TrimSpace(zone.Name) == "" || len(zone.Countries) == 0 {
+		return ErrInvalidZone
+	}
+	for i, country := range zone.Countries {
+		zone.Countries[i] = strings.ToUpper(strings.TrimSpace(country))
+		if len(zone.Countries[i]) != 2 {
+			return ErrInvalidZone
+		}
+	}
+
+	// Bounds of a range have the same length, postcodes are compared on that many characters
+	for i, postcodes := range zone.Postcodes {
+		from, to := NormalisePostcode(postcodes.From), NormalisePostcode(postcodes.To)
+		if from == "" || len(from) != len(to) || from > to {
+			return ErrInvalidZone
+		}
+		zone.Postcodes[i] = models.PostcodeRange{From: from, To: to}
+	}
+	return nil
+}
+
+// Check the method definition before it is stored
+func ValidateMethod(method models.ShippingMethod) error {
+	if strings.TrimSpace(method.Name) == "" || !kinds[method.Kind] {
+		return ErrInvalidMethod
+	}
+	if method.Price < 0 || method.PerKg < 0 || method.FreeOver < 0 || method.MaxWeight < 0 ||
+		method.MinDays < 0 || method.MaxDays < method.MinDays {
+		return ErrInvalidMethod
+	}
+	if method.Kind == KindCarrier {
+		if _, err := Lookup(method.Carrier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func covers(zone models.ShippingZone, country string, postcode string) bool {
+	found := false
+	for _, code := range zone.Countries {
+		if code == country {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	if len(zone.Postcodes) == 0 {
+		return true
+	}
+
+	postcode = NormalisePostcode(postcode)
+	for _, postcodes := range zone.Postcodes {
+		if len(postcode) < len(postcodes.From) {
+			continue
+		}
+		prefix := postcode[:len(postcodes.From)]
+		if prefix >= postcodes.From && prefix <= postcodes.To {
+			return true
+		}
+	}
+	return false
+}
+
+// The active zone of the address. Zones listing postcodes are more
+// specific than the ones covering whole countries and are preferred.
+func Match(db *gorm.DB, address models.Address) (models.ShippingZone, error) {
+	var zones []models.ShippingZone
+	if err := db.Where("active = ?", true).Order("created_at").Find(&zones).Error; err != nil {
+		return models.ShippingZone{}, err
+	}
+
+	var match *models.ShippingZone
+	for i := range zones {
+		if !covers(zones[i], address.Country, address.Postcode) {
+			continue
+		}
+		if len(zones[i].Postcodes) > 0 {
+			return zones[i], nil
+		}
+		if match == nil {
+			match = &zones[i]
+		}
+	}
+	if match == nil {
+		return models.ShippingZone{}, ErrNoZone
+	}
+	return *match, nil
+}
+
+// Quotes of the methods of the zone of the address, cheapest first.
+// Methods the parcel is too heavy for or the carrier cannot price are left out.
+func Quotes(ctx context.Context, db *gorm.DB, converter *currency.Converter, address models.Address, parcel Parcel) ([]Quote, error) {
+	zone, err := Match(db, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []models.ShippingMethod
+	if err := db.Where("zone = ? and active = ?", zone.Id, true).Find(&methods).Error; err != nil {
+		return nil, err
+	}
+
+	quotes := []Quote{}
+	for _, method := range methods {
+		quote, err := price(ctx, converter, method, address, parcel)
+		if err != nil {
+			if !errors.Is(err, ErrTooHeavy) {
+				log.Printf("Shipping quote of method %s failed: %v", method.Id, err)
+			}
+			continue
+		}
+		quotes = append(quotes, quote)
+	}
+
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Amount < quotes[j].Amount
+	})
+	return quotes, nil
+}
+
+// Quote of one method, which has to be offered for the address
+func QuoteMethod(ctx context.Context, db *gorm.DB, converter *currency.Converter, address models.Address, parcel Parcel, id uuid.UUID) (Quote, error) {
+	zone, err := Match(db, address)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	var method models.ShippingMethod
+	if err := db.First(&method, "id = ? and zone = ? and active = ?", id, zone.Id, true).Error; err != nil {
+		return Quote{}, ErrUnknownMethod
+	}
+	return price(ctx, converter, method, address, parcel)
+}
+
+func price(ctx context.Context, converter *currency.Converter, method models.ShippingMethod, address models.Address, parcel Parcel) (Quote, error) {
+	quote := Quote{
+		Method:   method.Id,
+		Name:     method.Name,
+		Kind:     method.Kind,
+		Currency: converter.Base(),
+		MinDays:  method.MinDays,
+		MaxDays:  method.MaxDays,
+	}
+	if method.MaxWeight > 0 && parcel.Weight > method.MaxWeight {
+		return quote, ErrTooHeavy
+	}
+
+	switch method.Kind {
+	case KindFlat:
+		quote.Amount = method.Price
+	case KindWeight:
+		// Every started kilogram is charged
+		quote.Amount = method.Price + method.PerKg*((parcel.Weight+999)/1000)
+	case KindCarrier:
+		rate, err := carrierRate(ctx, method, address, parcel)
+		if err != nil {
+			return quote, err
+		}
+		if quote.Amount, err = converter.Convert(rate.Amount, rate.Currency, quote.Currency); err != nil {
+			return quote, err
+		}
+		quote.Carrier = method.Carrier
+		if quote.MaxDays == 0 {
+			quote.MinDays, quote.MaxDays = rate.MinDays, rate.MaxDays
+		}
+	default:
+		return quote, ErrInvalidMethod
+	}
+
+	if method.FreeOver > 0 && parcel.Value >= method.FreeOver {
+		quote.Amount = 0
+	}
+	return quote, nil
+}
+
+// The rate of the service of the method, the cheapest when it names none
+func carrierRate(ctx context.Context, method models.ShippingMethod, address models.Address, parcel Parcel) (Rate, error) {
+	carrier, err := Lookup(method.Carrier)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	rates, err := carrier.Rates(ctx, RateRequest{
+		Country:  address.Country,
+		Postcode: address.Postcode,
+		Weight:   parcel.Weight,
+		Length:   parcel.Length,
+		Width:    parcel.Width,
+		Height:   parcel.Height,
+	})
+	if err != nil {
+		return Rate{}, err
+	}
+
+	var best *Rate
+	for i := range rates {
+		if method.Service != "" && rates[i].Service != method.Service {
+			continue
+		}
+		if best == nil || rates[i].Amount < best.Amount {
+			best = &rates[i]
+		}
+	}
+	if best == nil {
+		return Rate{}, ErrNoRate
+	}
+	return *best, nil
+}
+
+// Load the methods of the zones
+func LoadMethods(db *gorm.DB, zones []models.ShippingZone) error {
+	if len(zones) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(zones))
+	for i, zone := range zones {
+		ids[i] = zone.Id
+	}
+
+	var methods []models.ShippingMethod
+	if err := db.Where("zone in ?", ids).Order("created_at").Find(&methods).Error; err != nil {
+		return err
+	}
+
+	byZone := map[uuid.UUID][]models.ShippingMethod{}
+	for _, method := range methods {
+		byZone[method.Zone] = append(byZone[method.Zone], method)
+	}
+	for i := range zones {
+		zones[i].Methods = byZone[zones[i].Id]
+		if zones[i].Methods == nil {
+			zones[i].Methods = []models.ShippingMethod{}
+		}
+	}
+	return nil
+}
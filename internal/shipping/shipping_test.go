@@ -0,0 +1,111 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+func TestNormalisePostcode(t *testing.T) {
+	tests := []struct {
+		postcode string
+		want     string
+	}{
+		{"sw1a 1aa", "SW1A1AA"},
+		{"12345-6789", "123456789"},
+		{"2000", "2000"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := NormalisePostcode(test.postcode); got != test.want {
+			t.Errorf("NormalisePostcode(%q) = %q, want %q", test.postcode, got, test.want)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	country := models.ShippingZone{Countries: []string{"AU", "NZ"}}
+	sydney := models.ShippingZone{
+		Countries: []string{"AU"},
+		Postcodes: []models.PostcodeRange{{From: "2000", To: "2234"}},
+	}
+	london := models.ShippingZone{
+		Countries: []string{"GB"},
+		Postcodes: []models.PostcodeRange{{From: "E1", To: "E9"}, {From: "SW1", To: "SW9"}},
+	}
+
+	tests := []struct {
+		name     string
+		zone     models.ShippingZone
+		country  string
+		postcode string
+		want     bool
+	}{
+		{"whole country", country, "NZ", "6011", true},
+		{"other country", country, "GB", "2000", false},
+		{"first postcode of the range", sydney, "AU", "2000", true},
+		{"last postcode of the range", sydney, "AU", "2234", true},
+		{"postcode past the range", sydney, "AU", "2235", false},
+		{"postcode before the range", sydney, "AU", "1999", false},
+		{"postcode of another country", sydney, "NZ", "2000", false},
+		{"postcode shorter than the range", sydney, "AU", "200", false},
+		{"prefix in the second range", london, "GB", "sw1a 1aa", true},
+		{"prefix in the first range", london, "GB", "E2 8AA", true},
+		{"prefix out of every range", london, "GB", "N1 9GU", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := covers(test.zone, test.country, test.postcode); got != test.want {
+				t.Errorf("covers(%q, %q) = %v, want %v", test.country, test.postcode, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateZone(t *testing.T) {
+	tests := []struct {
+		name  string
+		zone  models.ShippingZone
+		valid bool
+		want  []models.PostcodeRange
+	}{
+		{
+			name:  "ranges are normalised",
+			zone:  models.ShippingZone{Name: "London", Countries: []string{"gb"}, Postcodes: []models.PostcodeRange{{From: "sw1", To: "sw9"}}},
+			valid: true,
+			want:  []models.PostcodeRange{{From: "SW1", To: "SW9"}},
+		},
+		{
+			name: "bounds of different lengths",
+			zone: models.ShippingZone{Name: "Sydney", Countries: []string{"AU"}, Postcodes: []models.PostcodeRange{{From: "200", To: "2234"}}},
+		},
+		{
+			name: "bounds the wrong way round",
+			zone: models.ShippingZone{Name: "Sydney", Countries: []string{"AU"}, Postcodes: []models.PostcodeRange{{From: "2234", To: "2000"}}},
+		},
+		{
+			name: "country that is not a code",
+			zone: models.ShippingZone{Name: "Europe", Countries: []string{"Germany"}},
+		},
+		{
+			name: "no countries",
+			zone: models.ShippingZone{Name: "Nowhere"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateZone(&test.zone)
+			if (err == nil) != test.valid {
+				t.Fatalf("ValidateZone() error = %v, want valid %v", err, test.valid)
+			}
+			for i, postcodes := range test.want {
+				if test.zone.Postcodes[i] != postcodes {
+					t.Errorf("postcodes[%d] = %v, want %v", i, test.zone.Postcodes[i], postcodes)
+				}
+			}
+		})
+	}
+}
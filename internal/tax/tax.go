@@ -0,0 +1,258 @@
+package tax
+
+import (
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/coupons"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/shipping"
+	"gorm.io/gorm"
+)
+
+const (
+	ClassStandard = "standard"
+
+	// Shipping is taxed at the rate of this class, or as standard goods without one
+	ClassShipping = "shipping"
+
+	// Tax is rounded on every line and summed, or summed and rounded once per rate
+	RoundLine  = "line"
+	RoundOrder = "order"
+)
+
+var (
+	ErrInvalidRate  = errors.New("tax rate needs a country, class, name and a rate from 0 to 100")
+	ErrUnknownClass = errors.New("tax class has no rates")
+)
+
+// Whether the prices of the store include tax, PRICES_INCLUDE_TAX
+func Inclusive() bool {
+	inclusive, _ := strconv.ParseBool(os.Getenv("PRICES_INCLUDE_TAX"))
+	return inclusive
+}
+
+// How tax is rounded, TAX_ROUNDING or per line
+func Rounding() string {
+	if os.Getenv("TAX_ROUNDING") == RoundOrder {
+		return RoundOrder
+	}
+	return RoundLine
+}
+
+// Check the rate before it is stored
+func ValidateRate(rate *models.TaxRate) error {
+	rate.Country = strings.ToUpper(strings.TrimSpace(rate.Country))
+	rate.Postcode = shipping.NormalisePostcode(rate.Postcode)
+	rate.Class = strings.ToLower(strings.TrimSpace(rate.Class))
+	if len(rate.Country) != 2 || rate.Class == "" || strings.TrimSpace(rate.Name) == "" ||
+		rate.Rate < 0 || rate.Rate > 100 {
+		return ErrInvalidRate
+	}
+	return nil
+}
+
+// Tax classes products can be in, the standard class and those with rates
+func Classes(db *gorm.DB) ([]string, error) {
+	classes := []string{}
+	if err := db.Model(&models.TaxRate{}).Distinct("class").Pluck("class", &classes).Error; err != nil {
+		return nil, err
+	}
+	if !slices.Contains(classes, ClassStandard) {
+		classes = append(classes, ClassStandard)
+	}
+	return classes, nil
+}
+
+// Check the tax class of a product before it is stored, no class is standard
+func ValidateClass(db *gorm.DB, class string) error {
+	if class == "" {
+		return nil
+	}
+	classes, err := Classes(db)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(classes, class) {
+		return ErrUnknownClass
+	}
+	return nil
+}
+
+// Rates of the classes where the address is, the rate with the longest
+// postcode matching the address wins over the one of the whole country.
+// Addresses without a country are taxed where the store is, TAX_COUNTRY.
+func Rates(db *gorm.DB, address models.Address, classes []string) (map[string]models.TaxRate, error) {
+	country := strings.ToUpper(address.Country)
+	if country == "" {
+		country = strings.ToUpper(os.Getenv("TAX_COUNTRY"))
+	}
+
+	var list []models.TaxRate
+	if err := db.Where("country = ? and class in ?", country, classes).Find(&list).Error; err != nil {
+		return nil, err
+	}
+
+	postcode := shipping.NormalisePostcode(address.Postcode)
+	rates := map[string]models.TaxRate{}
+	for _, rate := range list {
+		if !strings.HasPrefix(postcode, rate.Postcode) {
+			continue
+		}
+		if best, ok := rates[rate.Class]; !ok || len(rate.Postcode) > len(best.Postcode) {
+			rates[rate.Class] = rate
+		}
+	}
+	return rates, nil
+}
+
+// Tax of cart lines and their shipping to the address, for carts.SetTaxEstimator.
+// The discount is shared by the lines in proportion to what they cost,
+// lines of a class without a rate are not taxed.
+func Estimate(db *gorm.DB, address models.Address, lines []coupons.Line, discount int, shipping int) (carts.Tax, error) {
+	result := carts.Tax{Inclusive: Inclusive(), Lines: []models.TaxLine{}}
+	if len(lines) == 0 {
+		return result, nil
+	}
+
+	classes := []string{}
+	amounts := make([]int, len(lines))
+	for i, line := range lines {
+		classes = append(classes, class(line.Product))
+		amounts[i] = line.UnitPrice * line.Quantity
+	}
+
+	rates, err := Rates(db, address, append([]string{ClassShipping, ClassStandard}, classes...))
+	if err != nil {
+		return result, err
+	}
+
+	// Shipping is not discounted, it is taxed after the lines share the discount
+	nets := share(amounts, discount)
+	if shipping > 0 {
+		if _, ok := rates[ClassShipping]; ok {
+			classes = append(classes, ClassShipping)
+		} else {
+			classes = append(classes, ClassStandard)
+		}
+		nets = append(nets, shipping)
+	}
+	return apply(nets, classes, rates, result.Inclusive, Rounding()), nil
+}
+
+// Tax of the net amounts at the rates of their classes, grouped by rate
+// and rounded on every amount or once per rate
+func apply(nets []int, classes []string, rates map[string]models.TaxRate, inclusive bool, round string) carts.Tax {
+	result := carts.Tax{Inclusive: inclusive, Lines: []models.TaxLine{}}
+
+	type group struct {
+		line  models.TaxLine
+		net   int
+		exact float64
+	}
+	groups := []*group{}
+	byRate := map[string]*group{}
+
+	for i, net := range nets {
+		rate, ok := rates[classes[i]]
+		if !ok || net == 0 {
+			continue
+		}
+
+		key := rate.Name + "|" + strconv.FormatFloat(rate.Rate, 'f', -1, 64)
+		g, ok := byRate[key]
+		if !ok {
+			g = &group{line: models.TaxLine{Name: rate.Name, Rate: rate.Rate}}
+			byRate[key] = g
+			groups = append(groups, g)
+		}
+
+		// Tax in an inclusive price is the part above what it would be without
+		exact := float64(net) * rate.Rate / 100
+		if result.Inclusive {
+			exact = float64(net) * rate.Rate / (100 + rate.Rate)
+		}
+
+		g.net += net
+		g.exact += exact
+		if round == RoundLine {
+			g.line.Amount += int(math.Round(exact))
+		}
+	}
+
+	for _, g := range groups {
+		if round == RoundOrder {
+			g.line.Amount = int(math.Round(g.exact))
+		}
+
+		// Taxable amounts never include the tax
+		g.line.Taxable = g.net
+		if result.Inclusive {
+			g.line.Taxable = g.net - g.line.Amount
+		}
+
+		result.Amount += g.line.Amount
+		result.Lines = append(result.Lines, g.line)
+	}
+	return result
+}
+
+// Tax breakdown of an order as it was worked out at checkout, for the invoice
+func Breakdown(db *gorm.DB, order models.Order) ([]models.TaxLine, error) {
+	if order.Taxes == nil {
+		return []models.TaxLine{}, nil
+	}
+	return order.Taxes, nil
+}
+
+func class(product models.Product) string {
+	if product.TaxClass == "" {
+		return ClassStandard
+	}
+	return product.TaxClass
+}
+
+// Take the discount off the amounts in proportion to them. What is left
+// after rounding down goes to the largest remainders, so it adds up exactly.
+func share(amounts []int, discount int) []int {
+	total := 0
+	for _, amount := range amounts {
+		total += amount
+	}
+
+	net := make([]int, len(amounts))
+	copy(net, amounts)
+	if discount <= 0 || total == 0 {
+		return net
+	}
+	discount = min(discount, total)
+
+	remainders := make([]int, len(amounts))
+	given := 0
+	for i, amount := range amounts {
+		part := amount * discount / total
+		remainders[i] = amount * discount % total
+		net[i] -= part
+		given += part
+	}
+
+	for ; given < discount; given++ {
+		largest := -1
+		for i := range remainders {
+			if remainders[i] >= 0 && net[i] > 0 && (largest < 0 || remainders[i] > remainders[largest]) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			break
+		}
+		net[largest]--
+		remainders[largest] = -1
+	}
+	return net
+}
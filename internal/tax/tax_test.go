@@ -0,0 +1,125 @@
+package tax
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kevinhartarto/market-be/internal/models"
+)
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		amounts  []int
+		discount int
+		want     []int
+	}{
+		{"no discount", []int{1000, 500}, 0, []int{1000, 500}},
+		{"negative discount", []int{1000, 500}, -100, []int{1000, 500}},
+		{"in proportion", []int{1000, 3000}, 400, []int{900, 2700}},
+		{"remainder to the largest share", []int{333, 667}, 100, []int{300, 600}},
+		{"equal remainders in line order", []int{1, 1, 1}, 2, []int{0, 0, 1}},
+		{"discount above the total", []int{500, 300}, 1000, []int{0, 0}},
+		{"nothing to discount", []int{0, 0}, 100, []int{0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := share(test.amounts, test.discount)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("share(%v, %d) = %v, want %v", test.amounts, test.discount, got, test.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	rates := map[string]models.TaxRate{
+		ClassStandard: {Name: "VAT", Rate: 10},
+		"reduced":     {Name: "VAT reduced", Rate: 5},
+		ClassShipping: {Name: "VAT", Rate: 10},
+	}
+
+	tests := []struct {
+		name      string
+		nets      []int
+		classes   []string
+		inclusive bool
+		round     string
+		want      []models.TaxLine
+	}{
+		{
+			name:    "rounded on every line",
+			nets:    []int{105, 105},
+			classes: []string{ClassStandard, ClassStandard},
+			round:   RoundLine,
+			want:    []models.TaxLine{{Name: "VAT", Rate: 10, Taxable: 210, Amount: 22}},
+		},
+		{
+			name:    "rounded once for the order",
+			nets:    []int{105, 105},
+			classes: []string{ClassStandard, ClassStandard},
+			round:   RoundOrder,
+			want:    []models.TaxLine{{Name: "VAT", Rate: 10, Taxable: 210, Amount: 21}},
+		},
+		{
+			name:    "a line per rate",
+			nets:    []int{1000, 1000},
+			classes: []string{ClassStandard, "reduced"},
+			round:   RoundLine,
+			want: []models.TaxLine{
+				{Name: "VAT", Rate: 10, Taxable: 1000, Amount: 100},
+				{Name: "VAT reduced", Rate: 5, Taxable: 1000, Amount: 50},
+			},
+		},
+		{
+			name:    "shipping grouped with goods of the same rate",
+			nets:    []int{1000, 500},
+			classes: []string{ClassStandard, ClassShipping},
+			round:   RoundLine,
+			want:    []models.TaxLine{{Name: "VAT", Rate: 10, Taxable: 1500, Amount: 150}},
+		},
+		{
+			name:      "tax taken out of inclusive prices",
+			nets:      []int{1100, 525},
+			classes:   []string{ClassStandard, "reduced"},
+			inclusive: true,
+			round:     RoundLine,
+			want: []models.TaxLine{
+				{Name: "VAT", Rate: 10, Taxable: 1000, Amount: 100},
+				{Name: "VAT reduced", Rate: 5, Taxable: 500, Amount: 25},
+			},
+		},
+		{
+			name:    "class without a rate is not taxed",
+			nets:    []int{1000},
+			classes: []string{"exempt"},
+			round:   RoundLine,
+			want:    []models.TaxLine{},
+		},
+		{
+			name:    "fully discounted line is left out",
+			nets:    []int{0, 1000},
+			classes: []string{"reduced", ClassStandard},
+			round:   RoundLine,
+			want:    []models.TaxLine{{Name: "VAT", Rate: 10, Taxable: 1000, Amount: 100}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := apply(test.nets, test.classes, rates, test.inclusive, test.round)
+			if !reflect.DeepEqual(got.Lines, test.want) {
+				t.Errorf("apply() lines = %+v, want %+v", got.Lines, test.want)
+			}
+
+			amount := 0
+			for _, line := range test.want {
+				amount += line.Amount
+			}
+			if got.Amount != amount || got.Inclusive != test.inclusive {
+				t.Errorf("apply() = %d inclusive %v, want %d inclusive %v", got.Amount, got.Inclusive, amount, test.inclusive)
+			}
+		})
+	}
+}
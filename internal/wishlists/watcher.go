@@ -0,0 +1,130 @@
+package wishlists
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/notifications"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"gorm.io/gorm"
+)
+
+// Watcher tells wishlist owners when a listed product gets cheaper
+// or comes back in stock
+type Watcher struct {
+	db       database.Service
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+var watcherInstance *Watcher
+
+func NewWatcher(db database.Service, interval time.Duration) *Watcher {
+
+	if watcherInstance != nil {
+		return watcherInstance
+	}
+
+	watcherInstance = &Watcher{
+		db:       db,
+		interval: interval,
+	}
+
+	return watcherInstance
+}
+
+// Run the watcher in the background until the context is cancelled
+func (w *Watcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := w.Run(); err != nil {
+				log.Printf("Wishlist watcher failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Compare every wishlisted product with what its owner last saw
+func (w *Watcher) Run() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			models.WishlistItem
+			Account uuid.UUID
+		}
+		if err := tx.Table("wishlist_item").
+			Select("wishlist_item.*, wishlist.account").
+			Joins("join wishlist on wishlist.id = wishlist_item.wishlist").
+			Scan(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.Product)
+		}
+
+		var listed []models.Product
+		if err := tx.Where("id in ?", ids).Find(&listed).Error; err != nil {
+			return err
+		}
+		products := make(map[uuid.UUID]models.Product, len(listed))
+		for _, product := range listed {
+			products[product.Id] = product
+		}
+
+		for _, row := range rows {
+			product, ok := products[row.Product]
+			if !ok {
+				continue
+			}
+
+			price := pricing.CurrentPrice(product)
+			inStock := product.Stock > 0
+			if price == row.LastPrice && inStock == row.InStock {
+				continue
+			}
+
+			if price < row.LastPrice {
+				message := fmt.Sprintf("%s dropped from %d to %d %s", product.Name, row.LastPrice, price, product.Currency)
+				if err := notifications.Notify(tx, row.Account, notifications.TypePriceDrop, message, product.Id); err != nil {
+					return err
+				}
+			}
+			if inStock && !row.InStock {
+				message := product.Name + " is back in stock"
+				if err := notifications.Notify(tx, row.Account, notifications.TypeBackInStock, message, product.Id); err != nil {
+					return err
+				}
+			}
+
+			if err := tx.Model(&models.WishlistItem{}).Where("id = ?", row.Id).Updates(map[string]interface{}{
+				"last_price": price,
+				"in_stock":   inStock,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
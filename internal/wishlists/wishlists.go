@@ -0,0 +1,109 @@
+package wishlists
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/carts"
+	"github.com/kevinhartarto/market-be/internal/currency"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultName   = "My wishlist"
+	MaxNameLength = 100
+)
+
+var (
+	ErrInvalidName   = errors.New("wishlist name is too long")
+	ErrAlreadyListed = errors.New("product is already in the wishlist")
+)
+
+func NormaliseName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return DefaultName, nil
+	}
+	if len(name) > MaxNameLength {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+// Random token of the public share link
+func NewShareToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Add the product to the wishlist, remembering its current price and stock
+func AddItem(tx *gorm.DB, item *models.WishlistItem, product models.Product) error {
+	item.Id = uuid.Nil
+	item.Product = product.Id
+	item.LastPrice = pricing.CurrentPrice(product)
+	item.InStock = product.Stock > 0
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(item)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyListed
+	}
+	return nil
+}
+
+// Load the items of the wishlists, newest first
+func LoadItems(db *gorm.DB, lists []models.Wishlist) error {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(lists))
+	for i, list := range lists {
+		ids[i] = list.Id
+	}
+
+	var items []models.WishlistItem
+	if err := db.Where("wishlist in ?", ids).Order("created_at desc").Find(&items).Error; err != nil {
+		return err
+	}
+
+	byList := map[uuid.UUID][]models.WishlistItem{}
+	for _, item := range items {
+		byList[item.Wishlist] = append(byList[item.Wishlist], item)
+	}
+	for i := range lists {
+		lists[i].Items = byList[lists[i].Id]
+		if lists[i].Items == nil {
+			lists[i].Items = []models.WishlistItem{}
+		}
+	}
+	return nil
+}
+
+// Put one unit of the item in the cart of the account and take it off the wishlist
+func MoveToCart(tx *gorm.DB, converter *currency.Converter, item models.WishlistItem, account uuid.UUID) (models.Cart, error) {
+	if _, err := carts.AddLine(tx, converter, account, models.CartLine{
+		Product:  item.Product,
+		Colour:   item.Colour,
+		Size:     item.Size,
+		Quantity: 1,
+	}); err != nil {
+		return models.Cart{}, err
+	}
+
+	if err := tx.Delete(&item).Error; err != nil {
+		return models.Cart{}, err
+	}
+	return carts.Load(tx, account)
+}
@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kevinhartarto/market-be/internal/database"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"gorm.io/gorm"
+)
+
+// Publisher publishes scheduled drafts once their publish time is reached
+type Publisher struct {
+	db           database.Service
+	interval     time.Duration
+	mu           sync.Mutex
+	afterPublish []func()
+}
+
+var publisherInstance *Publisher
+
+func NewPublisher(db database.Service, interval time.Duration) *Publisher {
+
+	if publisherInstance != nil {
+		return publisherInstance
+	}
+
+	publisherInstance = &Publisher{
+		db:       db,
+		interval: interval,
+	}
+
+	return publisherInstance
+}
+
+// Register a function called every time drafts were published
+func (p *Publisher) AfterPublish(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.afterPublish = append(p.afterPublish, fn)
+}
+
+// Run the publisher in the background until the context is cancelled
+func (p *Publisher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := p.Run(); err != nil {
+				log.Printf("Draft publisher failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Publish every scheduled draft that is due
+func (p *Publisher) Run() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var due []models.ProductDraft
+	if err := p.db.UseGorm().
+		Where("status = ? and publish_at <= ?", StatusScheduled, time.Now()).
+		Order("publish_at").
+		Find(&due).Error; err != nil {
+		return err
+	}
+
+	published := 0
+	for i := range due {
+		err := p.db.UseGorm().Transaction(func(tx *gorm.DB) error {
+			return Publish(tx, &due[i])
+		})
+		if errors.Is(err, ErrStaleDraft) {
+			// Back to its author, it has to be redone on the current product
+			log.Printf("Draft %s is stale, sent back to its author", due[i].Id)
+			if err := p.db.UseGorm().Model(&due[i]).Updates(map[string]interface{}{
+				"status": StatusDraft,
+				"notes":  ErrStaleDraft.Error(),
+			}).Error; err != nil {
+				log.Printf("Unable to send draft %s back: %v", due[i].Id, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Unable to publish draft %s: %v", due[i].Id, err)
+			continue
+		}
+		published++
+	}
+
+	if published > 0 {
+		p.Published()
+	}
+	return nil
+}
+
+// Notify the hooks that the live catalog changed
+func (p *Publisher) Published() {
+	for _, fn := range p.afterPublish {
+		fn()
+	}
+}
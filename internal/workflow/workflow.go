@@ -0,0 +1,170 @@
+package workflow
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kevinhartarto/market-be/internal/models"
+	"github.com/kevinhartarto/market-be/internal/pricing"
+	"github.com/kevinhartarto/market-be/internal/revisions"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Lifecycle of a product draft, published and archived
+// are also the states of the live product
+const (
+	StatusDraft     = "draft"
+	StatusInReview  = "in_review"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+var (
+	ErrInvalidTransition = errors.New("draft cannot move to this status")
+	ErrNotReviewer       = errors.New("only the assigned reviewer can review this draft")
+	ErrSelfReview        = errors.New("the author cannot review their own draft")
+	ErrNotEditor         = errors.New("reviewer role is not allowed to edit products")
+	ErrStaleDraft        = errors.New("product changed since the draft was started")
+)
+
+// Columns of the live product a draft changes. Stock, sales and
+// activation are managed on the live product and are never published.
+var contentColumns = []string{
+	"name", "image", "price", "currency", "colour", "brand", "categories", "size",
+	"tax_class", "weight", "length", "width", "height", "is_new", "description",
+}
+
+// Whether the column of the live product is only changed through drafts
+func IsContent(column string) bool {
+	return slices.Contains(contentColumns, column)
+}
+
+// Statuses a draft may move to from its current one
+var transitions = map[string][]string{
+	StatusDraft:     {StatusInReview, StatusArchived},
+	StatusInReview:  {StatusDraft, StatusScheduled, StatusPublished},
+	StatusScheduled: {StatusDraft, StatusPublished},
+}
+
+func CanMove(from string, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the reviewer may approve or reject the draft
+func CheckReviewer(draft models.ProductDraft, reviewer models.Account, role models.Role) error {
+	if draft.Reviewer == nil || *draft.Reviewer != reviewer.Id {
+		return ErrNotReviewer
+	}
+	if draft.Author == reviewer.Id {
+		return ErrSelfReview
+	}
+	if !role.CanEdit {
+		return ErrNotEditor
+	}
+	return nil
+}
+
+// Replace the content of the live product with the one of the draft.
+// New products are created, existing ones only get the drafted content
+// columns and a new version so pending edits on them become stale. The
+// draft is refused when the live product changed since it was started.
+func Publish(tx *gorm.DB, draft *models.ProductDraft) error {
+	if !CanMove(draft.Status, StatusPublished) {
+		return ErrInvalidTransition
+	}
+
+	product := draft.Data
+	product.Id = draft.Product
+	product.Status = StatusPublished
+	product.UpdateBy = draft.Author
+	if draft.ApprovedBy != nil {
+		product.UpdateBy = *draft.ApprovedBy
+	}
+
+	var live models.Product
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&live, "id = ?", product.Id).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if draft.BaseVersion != 0 {
+			return ErrStaleDraft
+		}
+
+		// Another draft of the same new product may have been published first
+		product.Version = 1
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&product)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrStaleDraft
+		}
+	case err != nil:
+		return err
+	default:
+		if live.Version != draft.BaseVersion {
+			return ErrStaleDraft
+		}
+
+		product.Version = live.Version + 1
+		columns := append([]string{"status", "updated_by", "updated_at", "version"}, contentColumns...)
+		if err := tx.Model(&product).Select(columns).Updates(&product).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.First(&product, "id = ?", product.Id).Error; err != nil {
+		return err
+	}
+	if err := revisions.Record(tx, &product, revisions.SourcePublish); err != nil {
+		return err
+	}
+	if err := pricing.Record(tx, product, pricing.SourceUpdate); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	draft.Data = product
+	draft.Status = StatusPublished
+	draft.PublishedAt = &now
+	return tx.Save(draft).Error
+}
+
+// Take the live product out of the public catalog
+func Archive(tx *gorm.DB, productId uuid.UUID, by uuid.UUID) (models.Product, error) {
+	var product models.Product
+	result := tx.Model(&product).Where("id = ? and status = ?", productId, StatusPublished).Updates(map[string]interface{}{
+		"status":     StatusArchived,
+		"updated_by": by,
+		"version":    gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return product, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return product, ErrInvalidTransition
+	}
+	if err := discardPending(tx, productId); err != nil {
+		return product, err
+	}
+
+	if err := tx.First(&product, "id = ?", productId).Error; err != nil {
+		return product, err
+	}
+	return product, revisions.Record(tx, &product, revisions.SourceArchive)
+}
+
+// Drafts of the product still waiting to be published are no longer needed
+func discardPending(tx *gorm.DB, productId uuid.UUID) error {
+	return tx.Model(&models.ProductDraft{}).
+		Where("product = ? and status in ?", productId, []string{StatusDraft, StatusInReview, StatusScheduled}).
+		Update("status", StatusArchived).Error
+}
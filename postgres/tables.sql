@@ -9,7 +9,8 @@ create table public.role (
     can_wishlist    boolean default false,
     is_admin        boolean default false,
     is_owner        boolean default false,
-    deprecated      boolean default false
+    deprecated      boolean default false,
+    version         int not null default 1
 );
 
 create table public.account (
@@ -20,13 +21,14 @@ create table public.account (
     role        UUID references public.role(id),
     verified    boolean default false ,
     active      boolean default true,
+    version     int not null default 1,
     created_at  timestamp,
     updated_at  timestamp
 );
 
 create table public.cart (
     id          UUID PRIMARY KEY references public.account(id),
-    content     json,
+    coupon      text,
     updated_at  timestamp
 );
 
@@ -35,8 +37,10 @@ create table public.brand (
     name        text,
     logo        text,
     on_sale     boolean default false,
+    promo_sale  boolean default false,
     active      boolean default true,
     owner       UUID references public.account(id),
+    version     int not null default 1,
     created_at  timestamp,
     updated_by  UUID,
     updated_at  timestamp
@@ -49,6 +53,7 @@ create table public.category (
     featured    boolean default false,
     active      boolean default true,
     owner       UUID references public.account(id),
+    version     int not null default 1,
     created_at  timestamp,
     updated_by  UUID,
     updated_at  timestamp
@@ -59,6 +64,7 @@ create table public.product (
     name            text,
     image           json,
     price           int default 0,
+    currency        text default 'EUR',
     colour          json,
     brand           UUID references public.brand(id),
     categories      json,
@@ -66,15 +72,456 @@ create table public.product (
     on_sale         boolean default false,
     sale_price      int,
     sale_percent    int,
+    promo_sale      boolean default false,
     stock           int default 0,
+    tax_class       text default 'standard',
+    weight          int default 0,
+    length          int default 0,
+    width           int default 0,
+    height          int default 0,
     is_new          boolean default true,
     description     text,
     owner           UUID references public.account(id),
     active          boolean default true,
+    status          text not null default 'published',
+    version         int not null default 1,
     created_at      timestamp,
     updated_by      UUID,
     updated_at      timestamp
 );
 
+create table public.cart_line (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    cart        UUID references public.cart(id) on delete cascade,
+    product     UUID references public.product(id),
+    colour      text default '',
+    size        text default '',
+    quantity    int not null check (quantity > 0),
+    unit_price  int not null,
+    currency    text not null,
+    added_at    timestamp,
+    updated_at  timestamp,
+    UNIQUE (cart, product, colour, size)
+);
+
+create table public.import_job (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    entity          text not null,
+    format          text not null,
+    dry_run         boolean default false,
+    status          text not null,
+    total_rows      int default 0,
+    processed_rows  int default 0,
+    failed_rows     int default 0,
+    errors          json,
+    created_by      UUID,
+    created_at      timestamp,
+    updated_at      timestamp
+);
+
+create table public.promotion (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    name            text not null,
+    type            text not null,
+    value           int default 0,
+    buy_quantity    int default 0,
+    get_quantity    int default 0,
+    target_type     text not null,
+    targets         json,
+    priority        int default 0,
+    stackable       boolean default false,
+    starts_at       timestamp not null,
+    ends_at         timestamp,
+    active          boolean default false,
+    deprecated      boolean default false,
+    created_at      timestamp,
+    updated_at      timestamp
+);
+
+create table public.coupon (
+    id                  UUID PRIMARY KEY default uuid_generate_v4(),
+    code                text unique not null,
+    type                text not null,
+    value               int default 0,
+    min_spend           int default 0,
+    usage_limit         int default 0,
+    per_account_limit   int default 0,
+    used_count          int default 0,
+    brands              json,
+    categories          json,
+    roles               json,
+    batch               text,
+    expires_at          timestamp,
+    active              boolean default true,
+    created_at          timestamp,
+    updated_at          timestamp
+);
+
+create table public.coupon_redemption (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    coupon      UUID references public.coupon(id),
+    account     UUID references public.account(id),
+    amount      int default 0,
+    created_at  timestamp
+);
+
+create table public.price_history (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    product         UUID references public.product(id),
+    price           int default 0,
+    on_sale         boolean default false,
+    sale_price      int,
+    sale_percent    int,
+    source          text,
+    changed_by      UUID,
+    created_at      timestamp
+);
+
+create index price_history_product_idx on public.price_history (product, created_at);
+
+create table public.product_price (
+    product     UUID references public.product(id),
+    currency    text not null,
+    price       int not null,
+    sale_price  int default 0,
+    updated_at  timestamp,
+    PRIMARY KEY (product, currency)
+);
+
+create table public.exchange_rate (
+    currency    text PRIMARY KEY,
+    rate        double precision not null,
+    updated_at  timestamp
+);
+
+create table public.product_draft (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    product         UUID not null,
+    data            json not null,
+    status          text not null default 'draft',
+    base_version    int not null default 0,
+    author          UUID references public.account(id),
+    reviewer        UUID references public.account(id),
+    approved_by     UUID references public.account(id),
+    notes           text,
+    publish_at      timestamp,
+    published_at    timestamp,
+    created_at      timestamp,
+    updated_at      timestamp
+);
+
+create index product_draft_status_idx on public.product_draft (status, publish_at);
+
+create table public.revision (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    entity          text not null,
+    record          UUID not null,
+    number          int not null,
+    snapshot        json not null,
+    source          text,
+    changed_by      UUID,
+    created_at      timestamp
+);
+
+create unique index revision_record_idx on public.revision (entity, record, number);
+
+create table public.review (
+    id                  UUID PRIMARY KEY default uuid_generate_v4(),
+    product             UUID references public.product(id),
+    account             UUID references public.account(id),
+    rating              int not null check (rating between 1 and 5),
+    title               text,
+    body                text,
+    photos              json,
+    verified_purchase   boolean default false,
+    status              text not null default 'pending',
+    flagged             boolean default false,
+    helpful             int default 0,
+    moderated_by        UUID references public.account(id),
+    moderation_note     text,
+    created_at          timestamp,
+    updated_at          timestamp,
+    UNIQUE (product, account)
+);
+
+create index review_product_idx on public.review (product, status);
+
+create table public.review_vote (
+    review      UUID references public.review(id),
+    account     UUID references public.account(id),
+    created_at  timestamp,
+    PRIMARY KEY (review, account)
+);
+
+create table public.question (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    product     UUID references public.product(id),
+    account     UUID references public.account(id),
+    body        text not null,
+    answered    boolean default false,
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create index question_product_idx on public.question (product, created_at);
+
+create table public.answer (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    question    UUID references public.question(id),
+    account     UUID references public.account(id),
+    body        text not null,
+    upvotes     int default 0,
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create table public.answer_vote (
+    answer      UUID references public.answer(id),
+    account     UUID references public.account(id),
+    created_at  timestamp,
+    PRIMARY KEY (answer, account)
+);
+
+create table public.notification (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    account     UUID references public.account(id),
+    type        text not null,
+    message     text,
+    reference   UUID,
+    read        boolean default false,
+    created_at  timestamp
+);
+
+create index notification_account_idx on public.notification (account, read);
+
+create table public.product_view (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    product     UUID references public.product(id),
+    viewer      text not null,
+    created_at  timestamp
+);
 
+create index product_view_viewer_idx on public.product_view (viewer, created_at);
 
+create table public.product_association (
+    product     UUID references public.product(id),
+    related     UUID references public.product(id),
+    kind        text not null,
+    score       double precision not null,
+    updated_at  timestamp,
+    PRIMARY KEY (product, related, kind)
+);
+
+create table public.wishlist (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    account         UUID references public.account(id),
+    name            text not null,
+    public          boolean default false,
+    share_token     text UNIQUE,
+    created_at      timestamp,
+    updated_at      timestamp
+);
+
+create table public.wishlist_item (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    wishlist        UUID references public.wishlist(id) on delete cascade,
+    product         UUID references public.product(id),
+    colour          text default '',
+    size            text default '',
+    last_price      int default 0,
+    in_stock        boolean default true,
+    created_at      timestamp,
+    UNIQUE (wishlist, product, colour, size)
+);
+
+create table public.stock_reservation (
+    cart        UUID not null,
+    product     UUID references public.product(id) on delete cascade,
+    quantity    int not null check (quantity > 0),
+    expires_at  timestamp not null,
+    updated_at  timestamp,
+    PRIMARY KEY (cart, product)
+);
+
+create index stock_reservation_product_idx on public.stock_reservation (product, expires_at);
+
+create table public."order" (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    account     UUID references public.account(id),
+    status      text not null,
+    coupon      text,
+    subtotal    int not null,
+    discount    int default 0,
+    tax         int default 0,
+    shipping    int default 0,
+    total       int not null,
+    currency    text not null,
+    taxes       json,
+    tax_inclusive boolean default false,
+    shipping_method UUID,
+    shipping_name text,
+    ship_to     json,
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create index order_account_idx on public."order" (account, created_at);
+
+create table public.order_line (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    "order"     UUID references public."order"(id),
+    product     UUID references public.product(id),
+    name        text not null,
+    brand       UUID,
+    colour      text default '',
+    size        text default '',
+    quantity    int not null check (quantity > 0),
+    unit_price  int not null,
+    total       int not null
+);
+
+create table public.order_event (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    "order"     UUID references public."order"(id),
+    "from"      text,
+    "to"        text not null,
+    note        text,
+    changed_by  UUID,
+    created_at  timestamp
+);
+
+create table public.payment (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    "order"     UUID references public."order"(id),
+    provider    text not null,
+    reference   text not null,
+    status      text not null,
+    amount      int not null,
+    refunded    int default 0,
+    currency    text not null,
+    action_url  text,
+    created_at  timestamp,
+    updated_at  timestamp,
+    UNIQUE (provider, reference)
+);
+
+create table public.payment_refund (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    payment     UUID references public.payment(id),
+    "order"     UUID references public."order"(id),
+    amount      int not null,
+    status      text not null,
+    created_by  UUID references public.account(id),
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create table public.payment_event (
+    provider    text not null,
+    event       text not null,
+    type        text not null,
+    reference   text,
+    received_at timestamp,
+    PRIMARY KEY (provider, event)
+);
+
+create table public.return_request (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    "order"     UUID references public."order"(id),
+    account     UUID references public.account(id),
+    status      text not null,
+    notes       text,
+    carrier     text,
+    tracking    text,
+    label_url   text,
+    refund      int default 0,
+    reviewed_by UUID,
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create index return_request_status_idx on public.return_request (status, created_at);
+
+create table public.return_line (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    "return"    UUID references public.return_request(id) on delete cascade,
+    order_line  UUID references public.order_line(id),
+    product     UUID references public.product(id),
+    quantity    int not null check (quantity > 0),
+    reason      text not null,
+    comment     text,
+    photos      json
+);
+
+create table public.invoice_sequence (
+    year        int PRIMARY KEY,
+    last        int not null default 0
+);
+
+create table public.invoice (
+    id              UUID PRIMARY KEY default uuid_generate_v4(),
+    "order"         UUID UNIQUE references public."order"(id),
+    number          text UNIQUE not null,
+    year            int not null,
+    sequence        int not null,
+    seller_name     text,
+    seller_address  text,
+    seller_tax_id   text,
+    buyer_name      text,
+    buyer_email     text,
+    subtotal        int not null,
+    discount        int default 0,
+    tax             int default 0,
+    shipping        int default 0,
+    total           int not null,
+    currency        text not null,
+    ship_to         json,
+    taxes           json,
+    tax_inclusive   boolean default false,
+    invoice_key     text,
+    slip_key        text,
+    issued_at       timestamp,
+    UNIQUE (year, sequence)
+);
+
+create table public.shipping_zone (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    name        text not null,
+    countries   json not null,
+    postcodes   json,
+    active      boolean default true,
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create table public.shipping_method (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    zone        UUID references public.shipping_zone(id) on delete cascade,
+    name        text not null,
+    kind        text not null,
+    price       int default 0,
+    per_kg      int default 0,
+    free_over   int default 0,
+    max_weight  int default 0,
+    carrier     text,
+    service     text,
+    min_days    int default 0,
+    max_days    int default 0,
+    active      boolean default true,
+    created_at  timestamp,
+    updated_at  timestamp
+);
+
+create index shipping_method_zone_idx on public.shipping_method (zone);
+
+create table public.tax_rate (
+    id          UUID PRIMARY KEY default uuid_generate_v4(),
+    country     text not null,
+    postcode    text not null default '',
+    class       text not null,
+    name        text not null,
+    rate        double precision not null check (rate >= 0),
+    created_at  timestamp,
+    updated_at  timestamp,
+    UNIQUE (country, postcode, class)
+);